@@ -0,0 +1,27 @@
+package gotcpws
+
+import "time"
+
+// clock is source of time for the connection, tests replace it
+// with a fake one to avoid real waiting
+type clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+
+	// AfterFunc calls f in its own goroutine after duration d
+	AfterFunc(d time.Duration, f func()) timer
+}
+
+// timer is timer created by clock
+type timer interface {
+	Stop() bool
+}
+
+// realClock is clock based on time package
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+func (realClock) AfterFunc(d time.Duration, f func()) timer { return time.AfterFunc(d, f) }
@@ -0,0 +1,56 @@
+package gotcpws
+
+import "io"
+
+// FrameHeader is header of a frame without preambule
+type FrameHeader struct {
+	Fin    bool
+	Rsv    [3]bool
+	OpCode byte
+
+	// Length is length of the payload
+	Length int64
+
+	// MaskingKey is 4 bytes key of masked payload, nil if it is not masked
+	MaskingKey []byte
+}
+
+// HeaderCodec encodes and decodes headers of frames with alternate layout,
+// preambule, protocol version and payload with masking are handled
+// by the connection
+type HeaderCodec interface {
+	Encode(h FrameHeader) []byte
+	Decode(r io.ByteReader) (FrameHeader, error)
+}
+
+// recordingByteReader records bytes read by HeaderCodec
+type recordingByteReader struct {
+	io.ByteReader
+	read []byte
+}
+
+func (r *recordingByteReader) ReadByte() (byte, error) {
+	b, err := r.ByteReader.ReadByte()
+	if err == nil {
+		r.read = append(r.read, b)
+	}
+
+	return b, err
+}
+
+// SetHeaderCodec sets codec of headers of read and written frames,
+// if codec is nil the default layout is used. Resync does not check
+// plausibility of headers with custom codec
+func (conn *Conn) SetHeaderCodec(codec HeaderCodec) {
+	conn.rio.Lock()
+	if factory, ok := conn.frameReaderFactory.(*tcpFrameReaderFactory); ok {
+		factory.codec = codec
+	}
+	conn.rio.Unlock()
+
+	conn.wio.Lock()
+	if factory, ok := conn.frameWriterFactory.(*tcpFrameWriterFactory); ok {
+		factory.codec = codec
+	}
+	conn.wio.Unlock()
+}
@@ -0,0 +1,102 @@
+package gotcpws
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// swappedCodec puts opcode to high bits and Fin with RSV bits to low bits
+// of the first byte, length is always 8 bytes
+type swappedCodec struct{}
+
+func (swappedCodec) Encode(h FrameHeader) []byte {
+	b := h.OpCode << 4
+	if h.Fin {
+		b |= 0x01
+	}
+
+	for i := 0; i < 3; i++ {
+		if h.Rsv[i] {
+			b |= 1 << uint(i+1)
+		}
+	}
+
+	header := []byte{b, 0x00}
+	if h.MaskingKey != nil {
+		header[1] = 0x01
+	}
+
+	header = binary.BigEndian.AppendUint64(header, uint64(h.Length))
+	return append(header, h.MaskingKey...)
+}
+
+func (swappedCodec) Decode(r io.ByteReader) (FrameHeader, error) {
+	var raw [10]byte
+	for i := range raw {
+		b, err := r.ReadByte()
+		if err != nil {
+			return FrameHeader{}, err
+		}
+		raw[i] = b
+	}
+
+	h := FrameHeader{
+		Fin:    raw[0]&0x01 != 0,
+		OpCode: raw[0] >> 4,
+		Length: int64(binary.BigEndian.Uint64(raw[2:])),
+	}
+
+	for i := 0; i < 3; i++ {
+		h.Rsv[i] = raw[0]&(1<<uint(i+1)) != 0
+	}
+
+	if raw[1] == 0x01 {
+		h.MaskingKey = make([]byte, 4)
+		for i := range h.MaskingKey {
+			b, err := r.ReadByte()
+			if err != nil {
+				return FrameHeader{}, err
+			}
+			h.MaskingKey[i] = b
+		}
+	}
+
+	return h, nil
+}
+
+func TestConnHeaderCodec(t *testing.T) {
+	for _, masked := range []bool{false, true} {
+		connBuffer := testConn{
+			Buffer: bytes.NewBuffer(nil),
+		}
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, masked)
+		conn.SetHeaderCodec(swappedCodec{})
+
+		t.Run("check round trip with custom codec", func(t *testing.T) {
+			_, err := conn.WritePriority([]byte("priority"), true)
+			assert.Equal(t, nil, err, "should not be error to write")
+			assert.Equal(t, byte(TextFrame<<4|0x09), connBuffer.Bytes()[len(preambule)], "should encode header by codec")
+
+			got, high, err := conn.ReadFramePriority()
+			assert.Equal(t, nil, err, "should not be error to read")
+			assert.True(t, high, "should decode RSV3 bit by codec")
+			assert.Equal(t, []byte("priority"), got, "should be equal messages")
+		})
+
+		t.Run("check fragments with custom codec", func(t *testing.T) {
+			_, err := conn.WriteFragmented(BinaryFrame, []byte("fragmented"), 4)
+			assert.Equal(t, nil, err, "should not be error to write")
+
+			for _, want := range []string{"frag", "ment", "ed"} {
+				payloadType, _, got, err := conn.ReadFrameRaw()
+				assert.Equal(t, nil, err, "should not be error to read")
+				assert.Equal(t, byte(BinaryFrame), payloadType, "should be binary frame")
+				assert.Equal(t, []byte(want), got, "should be equal fragments")
+			}
+		})
+	}
+}
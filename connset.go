@@ -0,0 +1,129 @@
+package gotcpws
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// ConnSet is set of connections to broadcast messages and close them all
+// on shutdown of a server
+type ConnSet struct {
+	mu    sync.Mutex
+	conns map[*Conn]struct{}
+
+	// WriteTimeout is deadline of a write to each connection in Broadcast,
+	// if 0 there is no deadline
+	WriteTimeout time.Duration
+}
+
+// NewConnSet creates new empty set of connections
+func NewConnSet() *ConnSet {
+	return &ConnSet{conns: make(map[*Conn]struct{})}
+}
+
+// Add adds conn to the set
+func (set *ConnSet) Add(conn *Conn) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	set.conns[conn] = struct{}{}
+}
+
+// Remove removes conn from the set
+func (set *ConnSet) Remove(conn *Conn) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	delete(set.conns, conn)
+}
+
+// Len returns number of connections in the set
+func (set *ConnSet) Len() int {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	return len(set.conns)
+}
+
+// Broadcast writes msg to all connections of the set concurrently
+// and returns joined errors of the writes
+func (set *ConnSet) Broadcast(msg []byte) error {
+	return set.each(func(conn *Conn) error {
+		if set.WriteTimeout > 0 {
+			// connection may not support deadlines, write without it then
+			_ = conn.setWriteDeadline(time.Now().Add(set.WriteTimeout))
+			defer conn.restoreWriteDeadline()
+		}
+
+		_, err := conn.Write(msg)
+		return err
+	})
+}
+
+// BroadcastFragmented writes msg to all connections of the set concurrently
+// as a message of PayloadType of each connection fragmented identically into
+// frames with payload of at most fragmentSize bytes. Connections which do
+// not receive the message within WriteTimeout are closed without close
+// frame and removed from the set. It returns joined errors
+func (set *ConnSet) BroadcastFragmented(msg []byte, fragmentSize int) error {
+	return set.each(func(conn *Conn) error {
+		if set.WriteTimeout > 0 {
+			_ = conn.setWriteDeadline(time.Now().Add(set.WriteTimeout))
+			defer conn.restoreWriteDeadline()
+		}
+
+		conn.wio.Lock()
+		_, err := conn.writeFragmented(conn.PayloadType, msg, fragmentSize)
+		conn.wio.Unlock()
+
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			set.Remove(conn)
+
+			// the message may be partially written, so the stream is
+			// misaligned and close frame can not be written
+			_ = conn.closeWithoutHandshake()
+		}
+
+		return err
+	})
+}
+
+// CloseAll sends close frame with status and reason to all connections
+// of the set, closes them and removes from the set
+func (set *ConnSet) CloseAll(status int, reason string) error {
+	err := set.each(func(conn *Conn) error {
+		return conn.CloseWithStatus(status, reason)
+	})
+
+	set.mu.Lock()
+	clear(set.conns)
+	set.mu.Unlock()
+
+	return err
+}
+
+// each calls fn for all connections of the set concurrently
+// and returns joined errors
+func (set *ConnSet) each(fn func(conn *Conn) error) error {
+	set.mu.Lock()
+	conns := make([]*Conn, 0, len(set.conns))
+	for conn := range set.conns {
+		conns = append(conns, conn)
+	}
+	set.mu.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(conns))
+	for i, conn := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(conn)
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
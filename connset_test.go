@@ -0,0 +1,184 @@
+package gotcpws
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnSet(t *testing.T) {
+	const count = 3
+
+	set := NewConnSet()
+	set.WriteTimeout = time.Second
+
+	var clients []net.Conn
+	for i := 0; i < count; i++ {
+		server, client := net.Pipe()
+		set.Add(NewFrameConnection(server, nil, nil, 0, false))
+		clients = append(clients, client)
+	}
+	assert.Equal(t, count, set.Len(), "should add all connections")
+
+	t.Run("check broadcast", func(t *testing.T) {
+		want := []byte("broadcast message")
+
+		var wg sync.WaitGroup
+		got := make([][]byte, count)
+		for i, client := range clients {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				got[i], _ = NewFrameConnection(client, nil, nil, 0, false).ReadFrame()
+			}()
+		}
+
+		assert.Equal(t, nil, set.Broadcast(want), "should not be error to broadcast")
+		wg.Wait()
+
+		for i := range got {
+			assert.Equal(t, want, got[i], "should be equal messages of client %d", i)
+		}
+	})
+
+	t.Run("check broadcast errors", func(t *testing.T) {
+		server, client := net.Pipe()
+		_ = client.Close()
+
+		conn := NewFrameConnection(server, nil, nil, 0, false)
+		set.Add(conn)
+
+		var wg sync.WaitGroup
+		for _, client := range clients {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _ = NewFrameConnection(client, nil, nil, 0, false).ReadFrame()
+			}()
+		}
+
+		err := set.Broadcast([]byte("message"))
+		wg.Wait()
+		assert.ErrorIs(t, err, io.ErrClosedPipe, "should return error of closed connection")
+
+		set.Remove(conn)
+		assert.Equal(t, count, set.Len(), "should remove connection")
+	})
+
+	t.Run("check close all", func(t *testing.T) {
+		var wg sync.WaitGroup
+		got := make([][]byte, count)
+		for i, client := range clients {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				readerFactory := tcpFrameReaderFactory{Reader: bufio.NewReader(client)}
+				frame, err := readerFactory.NewFrameReader()
+				if err != nil {
+					return
+				}
+
+				if frame.PayloadType() == CloseFrame {
+					got[i], _ = io.ReadAll(frame)
+				}
+			}()
+		}
+
+		assert.Equal(
+			t,
+			nil,
+			set.CloseAll(closeStatusGoingAway, "shutdown"),
+			"should not be error to close all connections",
+		)
+		wg.Wait()
+
+		want := binary.BigEndian.AppendUint16(nil, closeStatusGoingAway)
+		want = append(want, "shutdown"...)
+		for i := range got {
+			t.Run(fmt.Sprintf("check close frame of client %d", i), func(t *testing.T) {
+				assert.Equal(t, want, got[i], "should be equal close payloads")
+			})
+		}
+		assert.Equal(t, 0, set.Len(), "should remove all connections")
+	})
+}
+
+func TestConnSetBroadcastFragmented(t *testing.T) {
+	const (
+		fast = 3
+		slow = 2
+	)
+
+	set := NewConnSet()
+	set.WriteTimeout = 100 * time.Millisecond
+
+	var fastClients, slowClients []net.Conn
+	for i := 0; i < fast+slow; i++ {
+		server, client := net.Pipe()
+		set.Add(NewFrameConnection(server, nil, nil, 0, false))
+		if i < fast {
+			fastClients = append(fastClients, client)
+		} else {
+			slowClients = append(slowClients, client)
+		}
+	}
+
+	msg := make([]byte, 1000)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+
+	var wg sync.WaitGroup
+	got := make([][]byte, fast)
+	fins := make([][]bool, fast)
+	for i, client := range fastClients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			conn := NewFrameConnection(client, nil, nil, 0, false)
+			for {
+				_, fin, data, err := conn.ReadFrameRaw()
+				if err != nil {
+					return
+				}
+
+				got[i] = append(got[i], data...)
+				fins[i] = append(fins[i], fin)
+				if fin {
+					return
+				}
+			}
+		}()
+	}
+
+	err := set.BroadcastFragmented(msg, 300)
+	wg.Wait()
+
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded, "should return deadline errors of slow connections")
+	assert.Equal(t, fast, set.Len(), "should drop slow connections")
+
+	for i := range got {
+		t.Run(fmt.Sprintf("check fast client %d", i), func(t *testing.T) {
+			assert.Equal(t, msg, got[i], "should be equal messages")
+			assert.Equal(t, []bool{false, false, false, true}, fins[i], "should be equal fragmentation")
+		})
+	}
+
+	for i, client := range slowClients {
+		t.Run(fmt.Sprintf("check slow client %d is closed", i), func(t *testing.T) {
+			_ = client.SetReadDeadline(time.Now().Add(time.Second))
+			_, err := io.Copy(io.Discard, client)
+			assert.Equal(t, nil, err, "should read until closed connection")
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package gotcpws
+
+import "sync"
+
+// writeCredit limits bytes of payloads written but not acknowledged yet
+type writeCredit struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	// limit is max unacknowledged bytes, if limit <= 0 there is no limit
+	limit    int64
+	inflight int64
+	closed   bool
+}
+
+func newWriteCredit() *writeCredit {
+	c := &writeCredit{}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// setLimit sets max unacknowledged bytes and wakes up blocked writes
+func (c *writeCredit) setLimit(n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.limit = n
+	c.cond.Broadcast()
+}
+
+// acquire blocks until n bytes fit into the credit, a write greater than
+// the limit waits for all bytes to be acknowledged. It returns ErrConnClosed
+// if the credit is closed
+func (c *writeCredit) acquire(n int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for !c.closed && c.limit > 0 && c.inflight > 0 && c.inflight+n > c.limit {
+		c.cond.Wait()
+	}
+
+	if c.closed {
+		return ErrConnClosed
+	}
+
+	c.inflight += n
+	return nil
+}
+
+// release returns n bytes to the credit
+func (c *writeCredit) release(n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.inflight = max(c.inflight-n, 0)
+	c.cond.Broadcast()
+}
+
+// close wakes up blocked writes with ErrConnClosed
+func (c *writeCredit) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	c.cond.Broadcast()
+}
+
+// SetWriteCredit limits bytes of payloads of data frames written but not
+// acknowledged by AckBytes, writes block until the credit is replenished.
+// If n <= 0 there is no limit
+func (conn *Conn) SetWriteCredit(n int64) {
+	conn.credit.setLimit(n)
+}
+
+// AckBytes replenishes the write credit by n acknowledged bytes
+func (conn *Conn) AckBytes(n int64) {
+	conn.credit.release(n)
+}
@@ -0,0 +1,111 @@
+package gotcpws
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnWriteCredit(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+	conn.SetWriteCredit(10)
+
+	_, err := conn.Write(make([]byte, 6))
+	assert.Equal(t, nil, err, "should not be error to write within credit")
+
+	t.Run("check write blocks until ack", func(t *testing.T) {
+		done := make(chan error, 1)
+		go func() {
+			_, err := conn.Write(make([]byte, 6))
+			done <- err
+		}()
+
+		select {
+		case <-done:
+			t.Fatal("should block write without credit")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		conn.AckBytes(6)
+		assert.Equal(t, nil, <-done, "should not be error to write after ack")
+	})
+
+	t.Run("check close unblocks write", func(t *testing.T) {
+		done := make(chan error, 1)
+		go func() {
+			_, err := conn.Write(make([]byte, 6))
+			done <- err
+		}()
+
+		time.Sleep(20 * time.Millisecond)
+		assert.Equal(t, nil, conn.Close(), "should not be error to close")
+		assert.Equal(t, ErrConnClosed, <-done, "should be ErrConnClosed error to blocked write")
+	})
+}
+
+func TestConnWriteCreditPing(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	conn := NewFrameConnection(server, nil, nil, 0, false)
+	peer := NewFrameConnection(client, nil, nil, 0, false)
+	conn.SetWriteCredit(4)
+
+	// the peer acknowledges messages of the connection
+	go func() {
+		for {
+			msg, err := conn.ReadFrame()
+			if err != nil {
+				return
+			}
+			if string(msg) == "ack" {
+				conn.AckBytes(4)
+			}
+		}
+	}()
+
+	received := make(chan []byte, 2)
+	go func() {
+		for {
+			msg, err := peer.ReadFrame()
+			if err != nil {
+				return
+			}
+			received <- msg
+		}
+	}()
+
+	_, err := conn.Write([]byte("data"))
+	assert.Equal(t, nil, err, "should not be error to write within credit")
+	assert.Equal(t, []byte("data"), <-received, "should receive message")
+
+	done := make(chan error, 1)
+	go func() {
+		// blocks in the write credit holding wio until the ack
+		_, err := conn.Write([]byte("more"))
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	t.Run("check ping does not block reader with exhausted credit", func(t *testing.T) {
+		go func() {
+			_ = peer.Ping([]byte("ping"))
+			_, _ = peer.Write([]byte("ack"))
+		}()
+
+		select {
+		case err := <-done:
+			assert.Equal(t, nil, err, "should not be error to write after ack")
+		case <-time.After(time.Second):
+			t.Fatal("should read ack while write is blocked by credit")
+		}
+		assert.Equal(t, []byte("more"), <-received, "should receive message after ack")
+	})
+}
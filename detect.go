@@ -0,0 +1,44 @@
+package gotcpws
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+)
+
+// Mode is framing mode of a connection detected by DetectMode
+type Mode int
+
+const (
+	// ModePreambule is framing with the preambule at the start of each frame
+	ModePreambule Mode = iota
+
+	// ModeStandard is standard RFC 6455 framing without the preambule
+	ModeStandard
+)
+
+// DetectMode peeks first bytes of c and returns connection configured for
+// detected mode: if they match the preambule frames are read and written
+// with it, otherwise frames are read and written without any preambule.
+// In standard mode the HTTP upgrade must be done before the call, so the
+// first bytes are the first frame. Peeked bytes are not lost, they are
+// read by the returned connection. It blocks until the peer sends
+// len of preambule bytes or the read deadline of c
+func DetectMode(c net.Conn) (Mode, *Conn, error) {
+	br := bufio.NewReader(c)
+	buf := bufio.NewReadWriter(br, bufio.NewWriter(c))
+
+	prefix, err := br.Peek(len(preambule))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	conn := NewFrameConnection(c, buf, nil, 0, false)
+	if bytes.Equal(prefix, preambule) {
+		return ModePreambule, conn, nil
+	}
+
+	conn.SetReadPreambule([]byte{})
+	conn.SetWritePreambule([]byte{})
+	return ModeStandard, conn, nil
+}
@@ -0,0 +1,56 @@
+package gotcpws
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectMode(t *testing.T) {
+	t.Run("check preambule mode", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer server.Close()
+		defer client.Close()
+
+		peer := NewFrameConnection(client, nil, nil, 0, true)
+		go func() { _, _ = peer.Write([]byte("with preambule")) }()
+
+		mode, conn, err := DetectMode(server)
+		assert.Equal(t, nil, err, "should not be error to detect mode")
+		assert.Equal(t, ModePreambule, mode, "should detect preambule mode")
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, []byte("with preambule"), got, "should read peeked frame")
+
+		go func() { _, _ = conn.Write([]byte("reply")) }()
+		got, err = peer.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read reply")
+		assert.Equal(t, []byte("reply"), got, "should write frames with preambule")
+	})
+
+	t.Run("check standard mode", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer server.Close()
+		defer client.Close()
+
+		peer := NewFrameConnection(client, nil, nil, 0, true)
+		peer.SetReadPreambule([]byte{})
+		peer.SetWritePreambule([]byte{})
+		go func() { _, _ = peer.Write([]byte("standard framing")) }()
+
+		mode, conn, err := DetectMode(server)
+		assert.Equal(t, nil, err, "should not be error to detect mode")
+		assert.Equal(t, ModeStandard, mode, "should detect standard mode")
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, []byte("standard framing"), got, "should read peeked frame")
+
+		go func() { _, _ = conn.Write([]byte("reply")) }()
+		got, err = peer.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read reply")
+		assert.Equal(t, []byte("reply"), got, "should write frames without preambule")
+	})
+}
@@ -0,0 +1,215 @@
+package gotcpws
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// ErrCircuitOpen returns by DialRetry while circuit breaker is open
+var ErrCircuitOpen = errors.New("error circuit breaker is open")
+
+// DialContext connects to the address on the named network
+// and returns framing connection without masking
+func DialContext(ctx context.Context, network, address string) (*Conn, error) {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFrameConnection(c, nil, nil, 0, false), nil
+}
+
+// BreakerState is state of circuit breaker of Dialer
+type BreakerState int
+
+const (
+	// BreakerClosed allows dials
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen fails dials fast until cooldown passes
+	BreakerOpen
+
+	// BreakerHalfOpen allows one trial dial after cooldown, the circuit
+	// is closed if it succeeds and opened again otherwise
+	BreakerHalfOpen
+)
+
+// Dialer dials framing connections with retries and circuit breaker,
+// zero value is ready to use
+type Dialer struct {
+	// Network is network to dial, default is tcp
+	Network string
+
+	// Retries is amount of dials after failed one, RetryDelay is delay
+	// before each of them
+	Retries    int
+	RetryDelay time.Duration
+
+	// FailureThreshold is amount of consecutive failed dials which opens
+	// the circuit for Cooldown. Defaults are 5 and 30s
+	FailureThreshold int
+	Cooldown         time.Duration
+
+	// Masking enables masking of frames written by dialed connections
+	Masking bool
+
+	dial  func(ctx context.Context, network, address string) (net.Conn, error)
+	clock clock
+
+	// state of circuit breaker guarded by mu
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// DialRetry connects to the address with retries. While the circuit is open
+// it fails fast with ErrCircuitOpen. Dials failed because ctx is done are
+// not counted as failures by the circuit breaker
+func (d *Dialer) DialRetry(ctx context.Context, address string) (*Conn, error) {
+	network := d.Network
+	if network == "" {
+		network = "tcp"
+	}
+
+	dial := d.dial
+	if dial == nil {
+		var nd net.Dialer
+		dial = nd.DialContext
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := d.allow(); err != nil {
+			return nil, err
+		}
+
+		c, err := dial(ctx, network, address)
+		if err != nil && ctx.Err() != nil {
+			// canceled dial says nothing about the peer
+			d.abandon()
+			return nil, err
+		}
+
+		d.record(err == nil)
+		if err == nil {
+			return NewFrameConnection(c, nil, nil, 0, d.Masking), nil
+		}
+
+		if attempt >= d.Retries {
+			return nil, err
+		}
+
+		if err := d.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// BreakerState returns state of circuit breaker of the dialer
+func (d *Dialer) BreakerState() BreakerState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.state
+}
+
+// allow reports whether a dial is allowed by circuit breaker
+func (d *Dialer) allow() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch d.state {
+	case BreakerOpen:
+		if d.getClock().Now().Sub(d.openedAt) < d.cooldown() {
+			return ErrCircuitOpen
+		}
+
+		d.state = BreakerHalfOpen
+		d.trial = true
+	case BreakerHalfOpen:
+		// only one trial dial is allowed
+		if d.trial {
+			return ErrCircuitOpen
+		}
+		d.trial = true
+	}
+
+	return nil
+}
+
+// record updates circuit breaker with result of a dial
+func (d *Dialer) record(success bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.trial = false
+	if success {
+		d.state = BreakerClosed
+		d.failures = 0
+		return
+	}
+
+	d.failures++
+	threshold := d.FailureThreshold
+	if threshold <= 0 {
+		threshold = defaultFailureThreshold
+	}
+
+	if d.state == BreakerHalfOpen || d.failures >= threshold {
+		d.state = BreakerOpen
+		d.openedAt = d.getClock().Now()
+	}
+}
+
+// abandon drops a dial which is not recorded, so the next dial
+// may be a trial of half-open circuit breaker
+func (d *Dialer) abandon() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.trial = false
+}
+
+// wait waits RetryDelay or until ctx is done
+func (d *Dialer) wait(ctx context.Context) error {
+	if d.RetryDelay <= 0 {
+		return ctx.Err()
+	}
+
+	done := make(chan struct{})
+	t := d.getClock().AfterFunc(d.RetryDelay, func() { close(done) })
+	defer t.Stop()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Dialer) cooldown() time.Duration {
+	if d.Cooldown <= 0 {
+		return defaultBreakerCooldown
+	}
+
+	return d.Cooldown
+}
+
+func (d *Dialer) getClock() clock {
+	if d.clock == nil {
+		return realClock{}
+	}
+
+	return d.clock
+}
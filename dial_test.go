@@ -0,0 +1,120 @@
+package gotcpws
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn := NewFrameConnection(c, nil, nil, 0, false)
+		_, _ = conn.Write([]byte("hello"))
+		_ = conn.Close()
+	}()
+
+	conn, err := DialContext(context.Background(), "tcp", ln.Addr().String())
+	assert.Equal(t, nil, err, "should not be error to dial")
+	defer conn.Close()
+
+	got, err := conn.ReadFrame()
+	assert.Equal(t, nil, err, "should not be error to read")
+	assert.Equal(t, []byte("hello"), got, "should be equal messages")
+}
+
+func TestDialerCircuitBreaker(t *testing.T) {
+	errDial := errors.New("dial failed")
+
+	var (
+		dials   int
+		failing = true
+	)
+	clock := newFakeClock()
+	d := &Dialer{
+		Retries:          5,
+		FailureThreshold: 3,
+		Cooldown:         time.Minute,
+		clock:            clock,
+		dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dials++
+			if failing {
+				return nil, errDial
+			}
+
+			c, _ := net.Pipe()
+			return c, nil
+		},
+	}
+
+	t.Run("check breaker trips", func(t *testing.T) {
+		_, err := d.DialRetry(context.Background(), "server:8080")
+		assert.Equal(t, ErrCircuitOpen, err, "should open circuit after failures")
+		assert.Equal(t, 3, dials, "should dial until threshold")
+		assert.Equal(t, BreakerOpen, d.BreakerState(), "should be open breaker")
+	})
+
+	t.Run("check fail fast", func(t *testing.T) {
+		clock.Advance(time.Minute / 2)
+
+		_, err := d.DialRetry(context.Background(), "server:8080")
+		assert.Equal(t, ErrCircuitOpen, err, "should fail fast while circuit is open")
+		assert.Equal(t, 3, dials, "should not dial while circuit is open")
+	})
+
+	t.Run("check failed trial", func(t *testing.T) {
+		clock.Advance(time.Minute / 2)
+
+		_, err := d.DialRetry(context.Background(), "server:8080")
+		assert.Equal(t, ErrCircuitOpen, err, "should open circuit after failed trial")
+		assert.Equal(t, 4, dials, "should dial once after cooldown")
+		assert.Equal(t, BreakerOpen, d.BreakerState(), "should be open breaker")
+	})
+
+	t.Run("check breaker resets", func(t *testing.T) {
+		clock.Advance(time.Minute)
+		failing = false
+
+		conn, err := d.DialRetry(context.Background(), "server:8080")
+		assert.Equal(t, nil, err, "should not be error to dial after cooldown")
+		assert.NotEqual(t, nil, conn, "should return connection")
+		assert.Equal(t, BreakerClosed, d.BreakerState(), "should close breaker on success")
+	})
+
+	t.Run("check retries", func(t *testing.T) {
+		failing = true
+		d.Retries = 1
+		dials = 0
+
+		_, err := d.DialRetry(context.Background(), "server:8080")
+		assert.Equal(t, errDial, err, "should return error of the last dial")
+		assert.Equal(t, 2, dials, "should dial again once")
+		assert.Equal(t, BreakerClosed, d.BreakerState(), "should not open breaker under threshold")
+	})
+
+	t.Run("check canceled dial", func(t *testing.T) {
+		d.Retries = 0
+		dials = 0
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := d.DialRetry(ctx, "server:8080")
+		assert.Equal(t, errDial, err, "should return error of the dial")
+		assert.Equal(t, 1, dials, "should dial once")
+		assert.Equal(t, BreakerClosed, d.BreakerState(), "should not count canceled dial as failure")
+	})
+}
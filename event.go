@@ -0,0 +1,107 @@
+package gotcpws
+
+import "io"
+
+// Event is event of the connection returned by NextEvent, it is one of
+// DataEvent, PingEvent, PongEvent and CloseEvent
+type Event interface {
+	event()
+}
+
+// DataEvent is data message with payload of all its fragments
+type DataEvent struct {
+	PayloadType byte
+	Payload     []byte
+}
+
+// PingEvent is ping frame
+type PingEvent struct {
+	Payload []byte
+}
+
+// PongEvent is pong frame
+type PongEvent struct {
+	Payload []byte
+}
+
+// CloseEvent is close frame with its status and reason
+type CloseEvent struct {
+	Status int
+	Reason string
+}
+
+func (DataEvent) event()  {}
+func (PingEvent) event()  {}
+func (PongEvent) event()  {}
+func (CloseEvent) event() {}
+
+// NextEvent reads the next data message or control frame of the connection
+// and returns it as event, so all frames are handled in one switch.
+// Ping and pong frames interleaved with fragments of a message are returned
+// after the message. Ping frames are not answered and close frame is not
+// answered, it is up to the caller. After close frame NextEvent returns io.EOF
+func (conn *Conn) NextEvent() (Event, error) {
+	if err := conn.checkPrefetch(); err != nil {
+		return nil, err
+	}
+
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	if event, ok := conn.popEvent(); ok {
+		return event, nil
+	}
+
+	conn.controlHook = conn.queueEvent
+	defer func() { conn.controlHook = nil }()
+
+	closeReceived := conn.closeReceived
+	frame, err := conn.nextFrameUntil(func() bool { return len(conn.events) > 0 })
+	if err == io.EOF && !closeReceived && conn.closeReceived {
+		return CloseEvent{Status: conn.closeStatus, Reason: conn.closeReason}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if frame == nil {
+		event, _ := conn.popEvent()
+		return event, nil
+	}
+
+	payload, err := conn.readPayload(frame)
+	if err == nil && !frame.Fin() {
+		payload, err = conn.readFragments(frame, payload)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return DataEvent{PayloadType: frame.PayloadType(), Payload: payload}, nil
+}
+
+// queueEvent queues ping or pong frame with payload as event,
+// rio must be held by the caller
+func (conn *Conn) queueEvent(payloadType byte, payload []byte) error {
+	if payloadType == PingFrame {
+		conn.events = append(conn.events, PingEvent{Payload: payload})
+	} else {
+		conn.events = append(conn.events, PongEvent{Payload: payload})
+	}
+
+	return nil
+}
+
+// popEvent returns the first queued event if it exists,
+// rio must be held by the caller
+func (conn *Conn) popEvent() (Event, bool) {
+	if len(conn.events) == 0 {
+		return nil, false
+	}
+
+	event := conn.events[0]
+	conn.events = conn.events[1:]
+	return event, true
+}
@@ -0,0 +1,48 @@
+package gotcpws
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnNextEvent(t *testing.T) {
+	connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+
+	_, err := conn.Write([]byte("data"))
+	assert.Equal(t, nil, err, "should not be error to write")
+	assert.Equal(t, nil, conn.Ping([]byte("ping")), "should not be error to write ping")
+	_, err = conn.WriteFrame(BinaryFrame, false, []byte("frag"))
+	assert.Equal(t, nil, err, "should not be error to write first fragment")
+	assert.Equal(t, nil, conn.Pong([]byte("pong")), "should not be error to write pong")
+	_, err = conn.WriteFrame(ContinuationFrame, true, []byte("mented"))
+	assert.Equal(t, nil, err, "should not be error to write final fragment")
+	_, err = conn.writeFrame(CloseFrame, closePayload(closeStatusGoingAway, "bye"))
+	assert.Equal(t, nil, err, "should not be error to write close frame")
+
+	want := []Event{
+		DataEvent{PayloadType: TextFrame, Payload: []byte("data")},
+		PingEvent{Payload: []byte("ping")},
+		DataEvent{PayloadType: BinaryFrame, Payload: []byte("fragmented")},
+		PongEvent{Payload: []byte("pong")},
+		CloseEvent{Status: closeStatusGoingAway, Reason: "bye"},
+	}
+
+	for _, event := range want {
+		got, err := conn.NextEvent()
+		assert.Equal(t, nil, err, "should not be error to read event")
+		assert.Equal(t, event, got, "should be equal events")
+	}
+
+	t.Run("check ping is not answered", func(t *testing.T) {
+		assert.Equal(t, 0, connBuffer.Len(), "should not write pong frame")
+	})
+
+	t.Run("check events after close frame", func(t *testing.T) {
+		_, err := conn.NextEvent()
+		assert.Equal(t, io.EOF, err, "should be io.EOF after close frame")
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"net"
 	"os"
+	"time"
 
 	gotcpws "github.com/sazonovItas/go-tcpws"
 )
@@ -22,9 +23,11 @@ func main() {
 	}
 	log.Println("new connection on addr:", c.RemoteAddr())
 
-	conn := gotcpws.NewFrameConnection(c, nil, nil, 0, false)
+	conn := gotcpws.NewConn(c)
 	defer conn.Close()
 
+	go keepalive(conn)
+
 	rd := bufio.NewReader(os.Stdout)
 	go func() {
 		for {
@@ -49,3 +52,15 @@ func main() {
 		}
 	}
 }
+
+// keepalive pings the server while connection is alive
+func keepalive(conn *gotcpws.Conn) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := conn.Ping([]byte("keepalive")); err != nil {
+			return
+		}
+	}
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"time"
 
 	gotcpws "github.com/sazonovItas/go-tcpws"
 )
@@ -18,32 +22,32 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	defer listener.Close()
 	log.Println("server listen on address:", listener.Addr())
 
-	log.Fatal(ListenAndServe(listener))
-}
+	srv := &gotcpws.Server{}
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt)
+		<-stop
 
-func ListenAndServe(ln net.Listener) error {
-	for {
-		c, err := ln.Accept()
-		if err != nil {
-			if err == net.ErrClosed {
-				return err
-			}
-			continue
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Println("shutdown:", err)
 		}
+	}()
 
-		log.Println("New connection on address:", c.RemoteAddr())
-		conn := gotcpws.NewFrameConnection(c, nil, nil, 0, false)
-		go func() {
-			defer conn.Close()
-			Serve(conn)
-		}()
-	}
+	log.Println(srv.Serve(listener, Serve))
 }
 
 func Serve(conn *gotcpws.Conn) {
+	log.Println("New connection on address:", conn.RemoteAddr())
+
+	done := make(chan struct{})
+	defer close(done)
+	go keepalive(conn, done)
+
 	var err error
 	var msg []byte
 	for {
@@ -62,3 +66,20 @@ func Serve(conn *gotcpws.Conn) {
 
 	log.Println(err)
 }
+
+// keepalive pings the client until done is closed
+func keepalive(conn *gotcpws.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := conn.Ping([]byte("keepalive")); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"net"
+	"sync"
 )
 
 const (
@@ -14,12 +16,51 @@ const (
 	TextFrame         = 1
 	BinaryFrame       = 2
 	CloseFrame        = 8
+	PingFrame         = 9
+	PongFrame         = 10
 	UnknownFrame      = 255
 
+	// reserved opcodes for non-control frames
+	minReservedDataFrame = 3
+	maxReservedDataFrame = 7
+
+	// reserved opcodes for control frames
+	minReservedControlFrame = 11
+	maxReservedControlFrame = 15
+
 	DefaultMaxPayloadBytes = 32 << 20 // 32MB
 
+	// maxDiscardPayloadBytes is max declared len of payload of too large
+	// frame which is discarded, frame declaring more closes the connection
+	maxDiscardPayloadBytes = DefaultMaxPayloadBytes
+
+	// maxControlPayloadBytes is max len of payload of a control frame
+	maxControlPayloadBytes = 125
+
 	maxHeaderLengthWithPreambule = 18
 	minHeaderLengthWithPreambule = 6
+
+	// maxPacketBytes is max len of a datagram of NewPacketFrameConnection
+	maxPacketBytes = 64 << 10 // 64KB
+
+	// maxPacketPayloadBytes is max len of payload of a frame which fits
+	// a datagram with preambule, protocol version, the longest header
+	// and sequence number
+	maxPacketPayloadBytes = maxPacketBytes - maxHeaderLengthWithPreambule - 1 - sequenceLength
+
+	// defaultFrameSizeHint is size of fragments of WriteFrom without hint
+	defaultFrameSizeHint = 32 << 10 // 32KB
+
+	// controlFramesBuffer is capacity of channel of ControlFrames
+	controlFramesBuffer = 16
+
+	// maxHeaderLength is max len of the header without preambule:
+	// 2 bytes + 8 bytes of extended payload len + 4 bytes of masking key
+	maxHeaderLength = 14
+
+	// sequenceLength is len of sequence number written after the header
+	// of each frame if sequencing is enabled
+	sequenceLength = 4
 )
 
 var (
@@ -30,6 +71,51 @@ var (
 	ErrBadHeader     = errors.New("error bad header")
 	ErrBadMaskingKey = errors.New("bad masking key")
 	ErrFrameTooLarge = errors.New("error frame is too large")
+
+	// ErrControlFrameTooLarge returns when payload of a control frame
+	// is greater than 125 bytes
+	ErrControlFrameTooLarge = errors.New("error control frame is too large")
+
+	// ErrBadOpCode returns when a frame has reserved opcode
+	ErrBadOpCode = errors.New("error bad opcode")
+
+	// ErrMaskingMismatch reports when unmasking offset of a read frame
+	// does not match length of its payload
+	ErrMaskingMismatch = errors.New("error masking offset mismatch")
+
+	// ErrUnexpectedFragment returns when a continuation frame arrives without
+	// a started message or a new message starts before the previous is finished
+	ErrUnexpectedFragment = errors.New("error unexpected fragment")
+
+	// ErrHeaderTooLarge returns when preambule with header of a frame
+	// is greater than max set by SetMaxFrameHeaderBytes
+	ErrHeaderTooLarge = errors.New("error frame header is too large")
+
+	// ErrMaskingPolicy returns when masking of a read frame violates
+	// ReadExpectMasked policy of the connection
+	ErrMaskingPolicy = errors.New("error frame masking violates policy")
+
+	// ErrVersionMismatch returns when protocol version of a read frame
+	// does not match ProtocolVersion of the connection
+	ErrVersionMismatch = errors.New("error protocol version mismatch")
+
+	// ErrSequenceGap returns when sequence number of a read frame is not
+	// the next one, it is wrapped by SequenceGapError
+	ErrSequenceGap = errors.New("error sequence gap")
+)
+
+// MaskingPolicy is policy of masking of read frames
+type MaskingPolicy int
+
+const (
+	// MaskingAny accepts both masked and unmasked frames
+	MaskingAny MaskingPolicy = iota
+
+	// MaskingRequired accepts only masked frames
+	MaskingRequired
+
+	// MaskingForbidden accepts only unmasked frames
+	MaskingForbidden
 )
 
 // tcpFrameHeader is header of the frame (without preambule)
@@ -46,18 +132,39 @@ type tcpFrameHeader struct {
 type tcpFrameReader struct {
 	reader io.Reader
 
-	header tcpFrameHeader
-	pos    int64
-	length int
+	header   tcpFrameHeader
+	pos      int64
+	length   int
+	consumed int64
+
+	// wireOpCode is opcode of the frame as it is read, the handler
+	// replaces opcode of the header with payload type of the message
+	wireOpCode byte
+
+	// seq is sequence number of the frame if sequenced is true
+	seq       uint32
+	sequenced bool
+
+	// onMaskingMismatch, if set, is called once at the end of the payload
+	// if unmasking offset does not match length of the payload
+	onMaskingMismatch func(err error)
 }
 
 func (frame *tcpFrameReader) Read(msg []byte) (int, error) {
 	n, err := frame.reader.Read(msg)
+	frame.consumed += int64(n)
 	if frame.header.MaskingKey != nil {
 		for i := 0; i < n; i++ {
 			msg[i] ^= frame.header.MaskingKey[frame.pos%4]
 			frame.pos++
 		}
+
+		if err == io.EOF && frame.onMaskingMismatch != nil {
+			if frame.pos != frame.header.Length {
+				frame.onMaskingMismatch(ErrMaskingMismatch)
+			}
+			frame.onMaskingMismatch = nil
+		}
 	}
 
 	return n, err
@@ -83,30 +190,286 @@ func (frame *tcpFrameReader) Len() int {
 	return frame.length
 }
 
+func (frame *tcpFrameReader) Fin() bool {
+	return frame.header.Fin
+}
+
+func (frame *tcpFrameReader) Rsv() [3]bool {
+	return frame.header.Rsv
+}
+
+func (frame *tcpFrameReader) Consumed() int64 {
+	return frame.consumed
+}
+
 type tcpFrameReaderFactory struct {
 	*bufio.Reader
+
+	// limiter throttles reading of frames, if nil there is no limit
+	limiter *rateLimiter
+
+	// resync skips bytes on bad preambule until the next preambule
+	// followed by a plausible header instead of returning ErrBadPreambule
+	resync bool
+
+	// onMaskingMismatch, if set, verifies unmasking of read frames
+	onMaskingMismatch func(err error)
+
+	// preambule expected at the start of each frame, if nil default is used
+	preambule []byte
+
+	// version, if set and not 0, points to protocol version expected
+	// after the preambule of each frame
+	version *byte
+
+	// lengthOrder, if set and not nil, points to byte order of extended
+	// length fields of headers, BigEndian is used by default
+	lengthOrder *binary.ByteOrder
+
+	// codec, if set, decodes headers of frames instead of the default layout
+	codec HeaderCodec
+
+	// maxHeaderBytes, if positive, is max len of preambule with header
+	maxHeaderBytes int
+
+	// sequencing reads sequence number after the header of each frame
+	sequencing bool
+
+	// packet reads each frame from one datagram buffered by Reader,
+	// the rest of the datagram is dropped if the frame is bad
+	packet bool
+
+	// limited, if not nil, is reused as payload reader of each frame
+	// instead of allocating a new one, so a frame reader is valid only
+	// until the next frame reader is created
+	limited *io.LimitedReader
+
+	// scratch, if not nil, is reused as frame reader of each frame with
+	// buffers of its header, so reading of a frame does not allocate
+	scratch *scratchFrame
+}
+
+// scratchFrame is frame reader reused between frames
+// with buffers of its header and payload reader
+type scratchFrame struct {
+	tcpFrameReader
+
+	headerBuf  [maxHeaderLength + sequenceLength]byte
+	maskingKey [4]byte
+	data       bytes.Buffer
+	limited    io.LimitedReader
+}
+
+// newScratchFrame creates scratch frame reading payloads of the factory
+func (buf tcpFrameReaderFactory) newScratchFrame() *scratchFrame {
+	return &scratchFrame{limited: io.LimitedReader{R: buf.payloadReader()}}
 }
 
 // NewFrameReader reads header of a frame and creates new frameReader
 // If while reading header occured error return nil, err
 func (buf tcpFrameReaderFactory) NewFrameReader() (frameReader, error) {
-	tcpFrame := new(tcpFrameReader)
+	if buf.packet {
+		return buf.newPacketFrameReader()
+	}
+
+	return buf.newFrameReader()
+}
+
+// newPacketFrameReader creates new frameReader of a frame of a datagram,
+// the payload of the frame must be in the same datagram, bytes of
+// the datagram after the payload are discarded
+func (buf tcpFrameReaderFactory) newPacketFrameReader() (frameReader, error) {
+	frame, err := buf.newFrameReader()
+	if err == nil && int64(buf.Buffered()) < frame.(*tcpFrameReader).header.Length {
+		err = ErrBadHeader
+	}
+
+	if err != nil {
+		// the next frame starts at the next datagram
+		_, _ = buf.Discard(buf.Buffered())
+		return nil, err
+	}
+
+	r := frame.(*tcpFrameReader)
+	trailing := buf.Buffered() - int(r.header.Length)
+	switch {
+	case trailing == 0:
+	case r.header.Length == 0:
+		_, _ = buf.Discard(trailing)
+	default:
+		r.reader = &packetPayloadReader{
+			reader:    r.reader,
+			buf:       buf.Reader,
+			remaining: r.header.Length,
+			trailing:  trailing,
+		}
+	}
+
+	return frame, nil
+}
+
+// packetPayloadReader reads payload of a frame of a datagram and discards
+// the rest of the datagram after the last byte of the payload is read,
+// so the next frame starts at the next datagram
+type packetPayloadReader struct {
+	reader    io.Reader
+	buf       *bufio.Reader
+	remaining int64
+	trailing  int
+}
+
+func (r *packetPayloadReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining == 0 && r.trailing > 0 {
+		_, _ = r.buf.Discard(r.trailing)
+		r.trailing = 0
+	}
+
+	return n, err
+}
+
+// newFrameReader reads preambule and header of a frame
+// and creates new frameReader
+func (buf tcpFrameReaderFactory) newFrameReader() (frameReader, error) {
+	preambule := buf.framePreambule()
 
 	// check preambule of a frame
-	for i := range preambule {
+	if buf.resync {
+		if err := buf.syncPreambule(true); err != nil {
+			return nil, err
+		}
+	} else {
+		for i := range preambule {
+			b, err := buf.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+
+			if b != preambule[i] {
+				return nil, ErrBadPreambule
+			}
+		}
+	}
+
+	return buf.readFrameHeader()
+}
+
+// recoverFrameReader skips bytes until the next preambule, followed by
+// a plausible header if checkHeader is true, and creates new frameReader
+func (buf tcpFrameReaderFactory) recoverFrameReader(checkHeader bool) (frameReader, error) {
+	if err := buf.syncPreambule(checkHeader); err != nil {
+		return nil, err
+	}
+
+	return buf.readFrameHeader()
+}
+
+// readFrameHeader reads header of a frame after the preambule
+// and creates new frameReader
+func (buf tcpFrameReaderFactory) readFrameHeader() (frameReader, error) {
+	var (
+		tcpFrame          *tcpFrameReader
+		headerBuf, keyBuf []byte
+	)
+	if scratch := buf.scratch; scratch != nil {
+		scratch.tcpFrameReader = tcpFrameReader{}
+		tcpFrame = &scratch.tcpFrameReader
+		headerBuf, keyBuf = scratch.headerBuf[:0], scratch.maskingKey[:0]
+	} else {
+		tcpFrame = new(tcpFrameReader)
+	}
+
+	version := buf.protocolVersion()
+	if version != 0 {
 		b, err := buf.ReadByte()
 		if err != nil {
 			return nil, err
 		}
 
-		if b != preambule[i] {
-			return nil, ErrBadPreambule
+		if b != version {
+			return nil, ErrVersionMismatch
+		}
+	}
+
+	header, err := buf.readHeader(&tcpFrame.header, headerBuf, keyBuf)
+	if err != nil {
+		return nil, err
+	}
+
+	if buf.sequencing {
+		var seq [sequenceLength]byte
+		if _, err := io.ReadFull(buf.Reader, seq[:]); err != nil {
+			return nil, err
+		}
+
+		// sequence number is a part of the header on the wire
+		header = append(header, seq[:]...)
+		tcpFrame.seq = binary.BigEndian.Uint32(seq[:])
+		tcpFrame.sequenced = true
+	}
+
+	// payload is not consumed, the stream can not be read further
+	if buf.maxHeaderBytes > 0 && buf.prefixLen()+len(header) > buf.maxHeaderBytes {
+		return nil, ErrHeaderTooLarge
+	}
+
+	buf.limiter.wait(buf.prefixLen() + len(header))
+
+	tcpFrame.wireOpCode = tcpFrame.header.OpCode
+	tcpFrame.onMaskingMismatch = buf.onMaskingMismatch
+	tcpFrame.length = len(header) + int(tcpFrame.header.Length)
+	tcpFrame.consumed = int64(len(header))
+
+	if scratch := buf.scratch; scratch != nil {
+		scratch.data.Reset()
+		scratch.data.Write(header)
+		tcpFrame.header.data = &scratch.data
+
+		scratch.limited.N = tcpFrame.header.Length
+		tcpFrame.reader = &scratch.limited
+		return tcpFrame, nil
+	}
+
+	tcpFrame.header.data = bytes.NewBuffer(header)
+	if buf.limited != nil {
+		buf.limited.N = tcpFrame.header.Length
+		tcpFrame.reader = buf.limited
+	} else {
+		tcpFrame.reader = io.LimitReader(buf.payloadReader(), tcpFrame.header.Length)
+	}
+	return tcpFrame, nil
+}
+
+// readHeader reads header of a frame into h with codec if it is set
+// and returns raw bytes of the header. Raw bytes and masking key
+// of the default layout are appended to headerBuf and keyBuf
+func (buf tcpFrameReaderFactory) readHeader(h *tcpFrameHeader, headerBuf, keyBuf []byte) ([]byte, error) {
+	if buf.codec != nil {
+		r := &recordingByteReader{ByteReader: buf.Reader}
+		fh, err := buf.codec.Decode(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if fh.Length < 0 {
+			return nil, ErrBadHeader
+		}
+
+		h.Fin, h.Rsv, h.OpCode, h.Length = fh.Fin, fh.Rsv, fh.OpCode, fh.Length
+		if fh.MaskingKey != nil {
+			if len(fh.MaskingKey) != 4 {
+				return nil, ErrBadMaskingKey
+			}
+			h.MaskingKey = append([]byte{}, fh.MaskingKey...)
 		}
+
+		return r.read, nil
 	}
 
 	var (
 		b      byte
-		header []byte
+		header = headerBuf
 		err    error
 	)
 
@@ -117,12 +480,12 @@ func (buf tcpFrameReaderFactory) NewFrameReader() (frameReader, error) {
 	}
 
 	header = append(header, b)
-	tcpFrame.header.Fin = (b & 0x80) != 0
+	h.Fin = (b & 0x80) != 0
 	for i := 0; i < 3; i++ {
 		shift := uint(6 - i)
-		tcpFrame.header.Rsv[i] = ((b >> shift) & 1) != 0
+		h.Rsv[i] = ((b >> shift) & 1) != 0
 	}
-	tcpFrame.header.OpCode = b & 0x0f
+	h.OpCode = b & 0x0f
 
 	// read payload len
 	b, err = buf.ReadByte()
@@ -138,7 +501,7 @@ func (buf tcpFrameReaderFactory) NewFrameReader() (frameReader, error) {
 	lengthFields := 0
 	switch {
 	case b <= 125:
-		tcpFrame.header.Length = int64(b)
+		h.Length = int64(b)
 	case b == 126:
 		lengthFields = 2
 	case b == 127:
@@ -152,11 +515,21 @@ func (buf tcpFrameReaderFactory) NewFrameReader() (frameReader, error) {
 		}
 
 		header = append(header, b)
-		tcpFrame.header.Length = tcpFrame.header.Length*256 + int64(b)
+		h.Length = h.Length*256 + int64(b)
+	}
+
+	if order := buf.byteOrder(); lengthFields > 0 && order != binary.BigEndian {
+		h.Length = decodeLength(order, header[len(header)-lengthFields:])
+	}
+
+	// the most significant bit of 64-bit length must be 0
+	if h.Length < 0 {
+		return nil, ErrBadHeader
 	}
 
 	// check mask's bytes if it exists
 	if mask {
+		key := keyBuf
 		for i := 0; i < 4; i++ {
 			b, err = buf.ReadByte()
 			if err != nil {
@@ -164,34 +537,278 @@ func (buf tcpFrameReaderFactory) NewFrameReader() (frameReader, error) {
 			}
 
 			header = append(header, b)
-			tcpFrame.header.MaskingKey = append(tcpFrame.header.MaskingKey, b)
+			key = append(key, b)
 		}
+		h.MaskingKey = key
 	}
 
-	tcpFrame.header.data = bytes.NewBuffer(header)
-	tcpFrame.length = len(header) + int(tcpFrame.header.Length)
-	tcpFrame.reader = io.LimitReader(buf.Reader, tcpFrame.header.Length)
-	return tcpFrame, nil
+	return header, nil
+}
+
+// framePreambule returns preambule expected at the start of each frame
+func (buf tcpFrameReaderFactory) framePreambule() []byte {
+	if buf.preambule == nil {
+		return preambule
+	}
+
+	return buf.preambule
+}
+
+// byteOrder returns byte order of extended length fields of headers
+func (buf tcpFrameReaderFactory) byteOrder() binary.ByteOrder {
+	if buf.lengthOrder == nil || *buf.lengthOrder == nil {
+		return binary.BigEndian
+	}
+
+	return *buf.lengthOrder
+}
+
+// decodeLength decodes extended length field of 2 or 8 bytes in order
+func decodeLength(order binary.ByteOrder, ext []byte) int64 {
+	if len(ext) == 2 {
+		return int64(order.Uint16(ext))
+	}
+
+	return int64(order.Uint64(ext))
+}
+
+// protocolVersion returns protocol version expected after the preambule,
+// 0 means that frames have no version
+func (buf tcpFrameReaderFactory) protocolVersion() byte {
+	if buf.version == nil {
+		return 0
+	}
+
+	return *buf.version
+}
+
+// prefixLen returns len of preambule with protocol version
+func (buf tcpFrameReaderFactory) prefixLen() int {
+	if buf.protocolVersion() != 0 {
+		return len(buf.framePreambule()) + 1
+	}
+
+	return len(buf.framePreambule())
+}
+
+// payloadReader returns reader of payloads of frames
+func (buf tcpFrameReaderFactory) payloadReader() io.Reader {
+	if buf.limiter != nil {
+		return throttledReader{reader: buf.Reader, limiter: buf.limiter}
+	}
+
+	return buf.Reader
+}
+
+// syncPreambule discards bytes until the preambule, followed by
+// a plausible header if checkHeader is true, and consumes the preambule
+func (buf tcpFrameReaderFactory) syncPreambule(checkHeader bool) error {
+	preambule := buf.framePreambule()
+	for {
+		p, err := buf.Peek(len(preambule))
+		if err != nil {
+			return err
+		}
+
+		if bytes.Equal(p, preambule) {
+			ok := true
+			if checkHeader {
+				ok, err = buf.plausibleHeader()
+				if err != nil {
+					return err
+				}
+			}
+
+			if ok {
+				_, err = buf.Discard(len(preambule))
+				return err
+			}
+		}
+
+		if _, err := buf.Discard(1); err != nil {
+			return err
+		}
+	}
+}
+
+// plausibleHeader checks without consuming that the header after the preambule
+// has valid opcode and minimally encoded length of the payload
+func (buf tcpFrameReaderFactory) plausibleHeader() (bool, error) {
+	// layout of headers of custom codec is unknown
+	if buf.codec != nil {
+		return true, nil
+	}
+
+	prefixLen := buf.prefixLen()
+	p, err := buf.Peek(prefixLen + 2)
+	if err != nil {
+		return false, err
+	}
+
+	if version := buf.protocolVersion(); version != 0 && p[prefixLen-1] != version {
+		return false, nil
+	}
+
+	opCode := p[prefixLen] & 0x0f
+	if opCode > maxReservedDataFrame && opCode != CloseFrame && opCode != PingFrame && opCode != PongFrame {
+		return false, nil
+	}
+
+	// control frames are not fragmented and have short payload
+	if opCode >= CloseFrame && (p[prefixLen]&0x80 == 0 || p[prefixLen+1]&0x7f > maxControlPayloadBytes) {
+		return false, nil
+	}
+
+	lengthFields := 0
+	switch p[prefixLen+1] & 0x7f {
+	case 126:
+		lengthFields = 2
+	case 127:
+		lengthFields = 8
+	}
+
+	if lengthFields == 0 {
+		return true, nil
+	}
+
+	p, err = buf.Peek(prefixLen + 2 + lengthFields)
+	if err != nil {
+		return false, err
+	}
+
+	ext := p[prefixLen+2:]
+	if lengthFields == 2 {
+		return buf.byteOrder().Uint16(ext) > 125, nil
+	}
+
+	length := buf.byteOrder().Uint64(ext)
+	return length>>63 == 0 && length > 65535, nil
 }
 
 type tcpFrameWriter struct {
 	writer *bufio.Writer
 
 	header *tcpFrameHeader
+
+	// limiter throttles writing of the frame, if nil there is no limit
+	limiter *rateLimiter
+
+	// noFlush leaves the frame in the buffer of writer without flushing
+	noFlush bool
+
+	// preambule written at the start of the frame, if nil default is used
+	preambule []byte
+
+	// version, if not 0, is protocol version written after the preambule
+	version byte
+
+	// codec, if set, encodes the header instead of the default layout
+	codec HeaderCodec
+
+	// seq, if sequenced is true, is sequence number written after the header
+	seq       uint32
+	sequenced bool
+
+	// overhead is len of preambule and header of the written frame
+	overhead int
+
+	// lengthOrder, if not nil, is byte order of extended length fields
+	lengthOrder binary.ByteOrder
+
+	// packet limits the frame to one datagram of maxPacketBytes
+	packet bool
 }
 
 // For io.WriterCloser interface
 func (frame *tcpFrameWriter) Close() error { return nil }
 
-// Writer msg to connection and return amount of bytes was
-// written + len(preambule) + len(header) and error
+// Write writes msg as a frame to connection and returns amount of bytes
+// of msg was written, preambule and header are not counted
 func (frame *tcpFrameWriter) Write(msg []byte) (int, error) {
-	var (
-		b      byte
-		header []byte
-		err    error
-	)
+	n, err := frame.writev(msg)
+	return frame.payloadWritten(n), err
+}
 
+// payloadWritten returns amount of payload bytes of the frame in n bytes
+// of the frame written to the connection
+func (frame *tcpFrameWriter) payloadWritten(n int) int {
+	return max(0, n-frame.overhead)
+}
+
+// writev writes payloads as one frame with payload of all of them,
+// masking continues across boundaries of payloads
+func (frame *tcpFrameWriter) writev(payloads ...[]byte) (int, error) {
+	var headerBuf [maxHeaderLength + sequenceLength]byte
+
+	preambule := frame.framePreambule()
+	prefixLen := len(preambule)
+	if frame.version != 0 {
+		prefixLen++
+	}
+
+	length := 0
+	for _, payload := range payloads {
+		length += len(payload)
+	}
+
+	if frame.header.MaskingKey != nil && len(frame.header.MaskingKey) != 4 {
+		return 0, ErrBadMaskingKey
+	}
+
+	// a frame split across datagrams is rejected by the peer
+	if frame.packet && length > maxPacketPayloadBytes {
+		return 0, ErrFrameTooLarge
+	}
+
+	var header []byte
+	if frame.codec != nil {
+		header = frame.codec.Encode(FrameHeader{
+			Fin:        frame.header.Fin,
+			Rsv:        frame.header.Rsv,
+			OpCode:     frame.header.OpCode,
+			Length:     int64(length),
+			MaskingKey: frame.header.MaskingKey,
+		})
+	} else {
+		// header is built in the array to avoid allocations
+		header = frame.appendHeader(headerBuf[:0], length)
+	}
+
+	if frame.sequenced {
+		header = binary.BigEndian.AppendUint32(header, frame.seq)
+	}
+	frame.overhead = prefixLen + len(header)
+
+	if frame.header.MaskingKey != nil {
+		frame.limiter.wait(prefixLen + len(header) + length)
+		frame.writePrefix(preambule)
+		frame.writeHeader(header)
+
+		pos := 0
+		for _, payload := range payloads {
+			data := make([]byte, len(payload))
+			for i := range data {
+				data[i] = payload[i] ^ frame.header.MaskingKey[(pos+i)%4]
+			}
+			pos += len(payload)
+			_, _ = frame.writer.Write(data)
+		}
+		return frame.flush(prefixLen + len(header) + length)
+	}
+
+	frame.limiter.wait(prefixLen + len(header) + length)
+	frame.writePrefix(preambule)
+	frame.writeHeader(header)
+	for _, payload := range payloads {
+		_, _ = frame.writer.Write(payload)
+	}
+	return frame.flush(prefixLen + len(header) + length)
+}
+
+// appendHeader appends header of the default layout
+// with payload of length to buf
+func (frame *tcpFrameWriter) appendHeader(buf []byte, length int) []byte {
+	var b byte
 	if frame.header.Fin {
 		b |= 0x80
 	}
@@ -203,7 +820,7 @@ func (frame *tcpFrameWriter) Write(msg []byte) (int, error) {
 		}
 	}
 	b |= frame.header.OpCode
-	header = append(header, b)
+	buf = append(buf, b)
 
 	b = 0x00
 	if frame.header.MaskingKey != nil {
@@ -212,7 +829,6 @@ func (frame *tcpFrameWriter) Write(msg []byte) (int, error) {
 
 	// write payload len
 	lengthFields := 0
-	length := len(msg)
 	switch {
 	case length <= 125:
 		b |= byte(length)
@@ -223,38 +839,87 @@ func (frame *tcpFrameWriter) Write(msg []byte) (int, error) {
 		b |= 127
 		lengthFields = 8
 	}
-	header = append(header, b)
+	buf = append(buf, b)
+
+	if order := frame.lengthOrder; lengthFields > 0 && order != nil && order != binary.BigEndian {
+		buf = appendLength(buf, order, length, lengthFields)
+		return append(buf, frame.header.MaskingKey...)
+	}
 
 	if lengthFields == 2 {
-		header = binary.BigEndian.AppendUint16(header, uint16(length))
+		buf = binary.BigEndian.AppendUint16(buf, uint16(length))
 	}
 
 	if lengthFields == 8 {
-		header = binary.BigEndian.AppendUint64(header, uint64(length))
+		buf = binary.BigEndian.AppendUint64(buf, uint64(length))
 	}
 
-	if frame.header.MaskingKey != nil {
-		if len(frame.header.MaskingKey) != 4 {
-			return 0, ErrBadMaskingKey
-		}
+	return append(buf, frame.header.MaskingKey...)
+}
 
-		header = append(header, frame.header.MaskingKey...)
-		data := make([]byte, len(msg))
-		for i := range data {
-			data[i] = msg[i] ^ frame.header.MaskingKey[i%4]
-		}
-		_, _ = frame.writer.Write(preambule)
-		_, _ = frame.writer.Write(header)
-		_, _ = frame.writer.Write(data)
-		err = frame.writer.Flush()
-		return len(preambule) + len(header) + len(msg), err
+// appendLength appends extended length field of lengthFields bytes
+// in order to buf, the field is encoded out of buf, so the header built
+// in an array of the caller does not escape
+func appendLength(buf []byte, order binary.ByteOrder, length, lengthFields int) []byte {
+	var ext [8]byte
+	if lengthFields == 2 {
+		order.PutUint16(ext[:2], uint16(length))
+	} else {
+		order.PutUint64(ext[:], uint64(length))
 	}
 
+	return append(buf, ext[:lengthFields]...)
+}
+
+// BuildFrame returns complete not masked frame with payload of the opcode
+// and the default preambule, it is written by Conn.WritePrebuilt
+func BuildFrame(opcode byte, payload []byte) []byte {
+	frame := &tcpFrameWriter{header: &tcpFrameHeader{Fin: true, OpCode: opcode}}
+
+	buf := make([]byte, 0, len(preambule)+maxHeaderLength+len(payload))
+	buf = append(buf, preambule...)
+	buf = frame.appendHeader(buf, len(payload))
+	return append(buf, payload...)
+}
+
+// writePrefix writes preambule with protocol version if it is set
+func (frame *tcpFrameWriter) writePrefix(preambule []byte) {
 	_, _ = frame.writer.Write(preambule)
-	_, _ = frame.writer.Write(header)
-	_, _ = frame.writer.Write(msg)
-	err = frame.writer.Flush()
-	return len(preambule) + len(header) + len(msg), err
+	if frame.version != 0 {
+		_ = frame.writer.WriteByte(frame.version)
+	}
+}
+
+// writeHeader writes header byte by byte, so the header does not escape
+// from the stack of the writer
+func (frame *tcpFrameWriter) writeHeader(header []byte) {
+	for _, b := range header {
+		_ = frame.writer.WriteByte(b)
+	}
+}
+
+// framePreambule returns preambule written at the start of the frame
+func (frame *tcpFrameWriter) framePreambule() []byte {
+	if frame.preambule == nil {
+		return preambule
+	}
+
+	return frame.preambule
+}
+
+// flush flushes the frame of total bytes and returns amount of bytes
+// of the frame was written to the connection
+func (frame *tcpFrameWriter) flush(total int) (int, error) {
+	if frame.noFlush {
+		return total, nil
+	}
+
+	if err := frame.writer.Flush(); err != nil {
+		// not written bytes of the frame are at the end of the buffer
+		return total - min(total, frame.writer.Buffered()), err
+	}
+
+	return total, nil
 }
 
 // tcpFrameWriterFactory creates writer for a frame
@@ -262,6 +927,30 @@ func (frame *tcpFrameWriter) Write(msg []byte) (int, error) {
 type tcpFrameWriterFactory struct {
 	*bufio.Writer
 	needMaskingKey bool
+
+	// limiter throttles writing of frames, if nil there is no limit
+	limiter *rateLimiter
+
+	// preambule written at the start of each frame, if nil default is used
+	preambule []byte
+
+	// version, if set and not 0, points to protocol version written
+	// after the preambule of each frame
+	version *byte
+
+	// lengthOrder, if set and not nil, points to byte order of extended
+	// length fields of headers, BigEndian is used by default
+	lengthOrder *binary.ByteOrder
+
+	// codec, if set, encodes headers of frames instead of the default layout
+	codec HeaderCodec
+
+	// sequence, if set, points to sequence number of the next frame,
+	// it is incremented by each created writer
+	sequence *uint32
+
+	// packet limits each frame to one datagram of maxPacketBytes
+	packet bool
 }
 
 func (buf tcpFrameWriterFactory) NewFrameWriter(payloadType byte) (frameWriter, error) {
@@ -274,26 +963,153 @@ func (buf tcpFrameWriterFactory) NewFrameWriter(payloadType byte) (frameWriter,
 		}
 	}
 
-	return &tcpFrameWriter{writer: buf.Writer, header: frameHeader}, nil
+	var version byte
+	if buf.version != nil {
+		version = *buf.version
+	}
+
+	var lengthOrder binary.ByteOrder
+	if buf.lengthOrder != nil {
+		lengthOrder = *buf.lengthOrder
+	}
+
+	w := &tcpFrameWriter{
+		writer:      buf.Writer,
+		header:      frameHeader,
+		limiter:     buf.limiter,
+		preambule:   buf.preambule,
+		version:     version,
+		codec:       buf.codec,
+		lengthOrder: lengthOrder,
+		packet:      buf.packet,
+	}
+
+	if buf.sequence != nil {
+		w.seq = *buf.sequence
+		w.sequenced = true
+		*buf.sequence++
+	}
+
+	return w, nil
 }
 
+// tcpFrameHandler tracks fragmentation of messages. Frames are returned
+// in order they are read, a continuation frame always belongs to the message
+// started by the last data frame without Fin bit. A data frame interleaving
+// a not finished message is rejected with ErrUnexpectedFragment and does not
+// change the state, so the message may be continued. Control frames do not
+// change the state and must not be fragmented. Frames with reserved opcodes
+// are rejected with ErrBadOpCode, other unknown opcodes are passed as is
 type tcpFrameHandler struct {
 	payloadType byte
+
+	// fragmented is true while a message is not finished by a frame with Fin bit
+	fragmented bool
+
+	// control, if set, is called with payload of ping and pong frames,
+	// its error is returned by HandleFrame
+	control func(payloadType byte, payload []byte) error
 }
 
 func (handler *tcpFrameHandler) HandleFrame(frame frameReader) (frameReader, error) {
 	switch frame.PayloadType() {
 	case ContinuationFrame:
+		if !handler.fragmented {
+			return nil, ErrUnexpectedFragment
+		}
+
 		frame.(*tcpFrameReader).header.OpCode = handler.payloadType
+		handler.fragmented = !frame.(*tcpFrameReader).header.Fin
 	case TextFrame, BinaryFrame:
+		if handler.fragmented {
+			return nil, ErrUnexpectedFragment
+		}
+
 		handler.payloadType = frame.PayloadType()
+		handler.fragmented = !frame.(*tcpFrameReader).header.Fin
 	case CloseFrame:
+		if !frame.Fin() {
+			return nil, ErrUnexpectedFragment
+		}
+
 		return nil, io.EOF
+	case PingFrame, PongFrame:
+		if !frame.Fin() {
+			return nil, ErrUnexpectedFragment
+		}
+
+		if frame.(*tcpFrameReader).header.Length > maxControlPayloadBytes {
+			return nil, ErrControlFrameTooLarge
+		}
+
+		if handler.control == nil {
+			// ping and pong frames are skipped
+			_, err := io.Copy(io.Discard, frame)
+			return nil, err
+		}
+
+		payload, err := io.ReadAll(frame)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, handler.control(frame.PayloadType(), payload)
+	default:
+		if isReservedOpCode(frame.PayloadType()) {
+			return nil, ErrBadOpCode
+		}
 	}
 
 	return frame, nil
 }
 
+// isReservedOpCode reports whether opCode is reserved by the protocol,
+// opcodes above 15 are not reserved, e.g. set by NewTranslatingHandler
+func isReservedOpCode(opCode byte) bool {
+	return opCode >= minReservedDataFrame && opCode <= maxReservedDataFrame ||
+		opCode >= minReservedControlFrame && opCode <= maxReservedControlFrame
+}
+
+// Reset drops fragmentation state of the handler,
+// the next frame is expected to start a new message
+func (handler *tcpFrameHandler) Reset() {
+	handler.payloadType = 0
+	handler.fragmented = false
+}
+
+// translatingHandler is tcpFrameHandler which translates opcodes
+// of read frames by mapping before handling them
+type translatingHandler struct {
+	*tcpFrameHandler
+
+	mapping map[byte]byte
+}
+
+// NewTranslatingHandler creates frame handler for NewFrameConnection which
+// translates opcodes of read frames by mapping, e.g. custom opcode 3 to
+// BinaryFrame, and handles translated frames like the default handler
+func NewTranslatingHandler(mapping map[byte]byte) frameHandler {
+	m := make(map[byte]byte, len(mapping))
+	for from, to := range mapping {
+		m[from] = to
+	}
+
+	return &translatingHandler{
+		tcpFrameHandler: &tcpFrameHandler{},
+		mapping:         m,
+	}
+}
+
+func (handler *translatingHandler) HandleFrame(frame frameReader) (frameReader, error) {
+	if r, ok := frame.(*tcpFrameReader); ok {
+		if opCode, ok := handler.mapping[r.header.OpCode]; ok {
+			r.header.OpCode = opCode
+		}
+	}
+
+	return handler.tcpFrameHandler.HandleFrame(frame)
+}
+
 func (handler *tcpFrameHandler) WriteClose(writerFactory frameWriterFactory, status int) error {
 	writer, err := writerFactory.NewFrameWriter(CloseFrame)
 	if err != nil {
@@ -304,12 +1120,34 @@ func (handler *tcpFrameHandler) WriteClose(writerFactory frameWriterFactory, sta
 	return err
 }
 
+// parseClosePayload returns status and reason of a close frame payload,
+// empty payload means no status received
+func parseClosePayload(payload []byte) (int, string) {
+	switch len(payload) {
+	case 0:
+		return closeStatusNoStatusRcvd, ""
+	case 1:
+		return closeStatusProtocolError, ""
+	}
+
+	return int(binary.BigEndian.Uint16(payload)), string(payload[2:])
+}
+
+// closePayload returns payload of a close frame with status and reason
+func closePayload(status int, reason string) []byte {
+	payload := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(reason)), uint16(status))
+	return append(payload, reason...)
+}
+
 // create new tcp frame connection from rwc interface
 // rwc - readWriteCloser interface
 // if buf - nil create new bufio readWriter from rwc
 // handler - handles frame header and close connection, if nil will use tcpFrameHandler
+// passing ping and pong frames to SetPingHandler and SetPongHandler
 // maxPayloadBytes - max size of the message, if 0 will use DefaultMaxPayloadBytes
 // needMaskingKey - specifies mask of the payload
+// It is the same as NewConn with WithBuffers, WithHandler,
+// WithMaxPayloadBytes and WithMasking options
 func NewFrameConnection(
 	rwc io.ReadWriteCloser,
 	buf *bufio.ReadWriter,
@@ -317,29 +1155,109 @@ func NewFrameConnection(
 	maxPayloadBytes int,
 	needMaskingKey bool,
 ) *Conn {
+	return NewConn(
+		rwc,
+		WithBuffers(buf),
+		WithHandler(handler),
+		WithMaxPayloadBytes(maxPayloadBytes),
+		WithMasking(needMaskingKey),
+	)
+}
+
+// NewConn creates new tcp frame connection from rwc configured by opts,
+// without options it creates buffers of rwc, uses tcpFrameHandler passing
+// ping and pong frames to SetPingHandler and SetPongHandler, limits
+// messages by DefaultMaxPayloadBytes and writes unmasked frames.
+// Write of the created connection writes text frames, PayloadType
+// of the connection sets other payload type
+func NewConn(rwc io.ReadWriteCloser, opts ...Option) *Conn {
+	var o connOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	buf, handler := o.buf, o.handler
+
+	// writes of the created buffer are retried with WriteRetries
+	var retry *retryWriter
 	if buf == nil {
+		retry = &retryWriter{w: rwc}
 		br := bufio.NewReader(rwc)
-		bw := bufio.NewWriter(rwc)
+		bw := bufio.NewWriter(retry)
 		buf = bufio.NewReadWriter(br, bw)
 	}
 
+	// ping and pong frames are passed to handlers of the connection
+	// by the default handler
+	var defaultHandler *tcpFrameHandler
 	if handler == nil {
-		handler = &tcpFrameHandler{}
+		defaultHandler = &tcpFrameHandler{}
+		handler = defaultHandler
 	}
 
+	clk := realClock{}
+	readLimiter := newRateLimiter(clk)
+	writeLimiter := newRateLimiter(clk)
+
 	conn := &Conn{
-		buf:                buf,
-		rwc:                rwc,
-		frameReaderFactory: &tcpFrameReaderFactory{Reader: buf.Reader},
+		buf: buf,
+		rwc: rwc,
+		frameReaderFactory: &tcpFrameReaderFactory{
+			Reader:  buf.Reader,
+			limiter: readLimiter,
+		},
 		frameWriterFactory: &tcpFrameWriterFactory{
 			Writer:         buf.Writer,
-			needMaskingKey: needMaskingKey,
+			needMaskingKey: o.masking,
+			limiter:        writeLimiter,
 		},
+		clock:              clk,
+		readLimiter:        readLimiter,
+		writeLimiter:       writeLimiter,
+		credit:             newWriteCredit(),
+		metrics:            noopMetrics{},
 		frameHandler:       handler,
 		defaultCloseStatus: closeStatusNormal,
 		PayloadType:        TextFrame,
-		MaxPayloadBytes:    maxPayloadBytes,
+		LengthByteOrder:    binary.BigEndian,
+		MaxPayloadBytes:    o.maxPayloadBytes,
+		controlFrames:      make(chan ControlFrame, controlFramesBuffer),
+		done:               make(chan struct{}),
 	}
+
+	conn.resumed = sync.NewCond(&conn.pauseMu)
+
+	if retry != nil {
+		retry.conn = conn
+	}
+
+	if defaultHandler != nil {
+		defaultHandler.control = conn.handleControl
+	}
+
+	// factories follow ProtocolVersion of the connection
+	conn.frameReaderFactory.(*tcpFrameReaderFactory).version = &conn.ProtocolVersion
+	conn.frameWriterFactory.(*tcpFrameWriterFactory).version = &conn.ProtocolVersion
+	conn.frameReaderFactory.(*tcpFrameReaderFactory).lengthOrder = &conn.LengthByteOrder
+	conn.frameWriterFactory.(*tcpFrameWriterFactory).lengthOrder = &conn.LengthByteOrder
+	return conn
+}
+
+// NewPacketFrameConnection creates new connection over packet-oriented
+// transport pc, where each read of pc yields one datagram with exactly one
+// whole frame and each frame is written by one write. Frames must not be
+// greater than maxPacketBytes, so payloads are limited to
+// maxPacketPayloadBytes and writes of greater payloads return
+// ErrFrameTooLarge. A bad frame drops only its datagram
+func NewPacketFrameConnection(pc net.Conn) *Conn {
+	// the buffers hold a whole datagram, so a read of pc is not split
+	buf := bufio.NewReadWriter(
+		bufio.NewReaderSize(pc, maxPacketBytes),
+		bufio.NewWriterSize(pc, maxPacketBytes),
+	)
+
+	conn := NewConn(pc, WithBuffers(buf), WithMaxPayloadBytes(maxPacketPayloadBytes))
+	conn.frameReaderFactory.(*tcpFrameReaderFactory).packet = true
+	conn.frameWriterFactory.(*tcpFrameWriterFactory).packet = true
 	return conn
 }
 
@@ -312,3 +312,207 @@ func Test_generateMaskingKey(t *testing.T) {
 
 	assert.Equal(t, 4, len(maskingKey), "masking key should be length of 4")
 }
+
+func TestTcpFrameReaderFactoryResync(t *testing.T) {
+	// payload looks like preambule with a plausible header of a frame
+	trap := append(append([]byte{}, preambule...), 0x81, 0x05, 't', 'r', 'a', 'p', '!')
+
+	newFactory := func(buf *bytes.Buffer) tcpFrameReaderFactory {
+		return tcpFrameReaderFactory{
+			Reader: bufio.NewReader(buf),
+			resync: true,
+		}
+	}
+
+	writeFrame := func(buf *bytes.Buffer, needMaskingKey bool, msg []byte) {
+		writerFactory := tcpFrameWriterFactory{
+			Writer:         bufio.NewWriter(buf),
+			needMaskingKey: needMaskingKey,
+		}
+
+		writer, _ := writerFactory.NewFrameWriter(BinaryFrame)
+		_, err := writer.Write(msg)
+		assert.Equal(t, nil, err, "should not be error to write frame")
+	}
+
+	t.Run("check no false resync on payload with preambule", func(t *testing.T) {
+		buf := bytes.NewBuffer(nil)
+		msgs := [][]byte{
+			trap,
+			append(append([]byte("head"), trap...), trap...),
+			bytes.Repeat(trap, 100),
+		}
+		for _, msg := range msgs {
+			writeFrame(buf, false, msg)
+			writeFrame(buf, true, msg)
+		}
+
+		readerFactory := newFactory(buf)
+		for _, want := range msgs {
+			for i := 0; i < 2; i++ {
+				reader, err := readerFactory.NewFrameReader()
+				if !assert.Equal(t, nil, err, "should not be error to read frame") {
+					return
+				}
+
+				got, err := io.ReadAll(reader)
+				assert.Equal(t, nil, err, "should not be error to read payload")
+				assert.Equal(t, want, got, "should be equal messages")
+			}
+		}
+	})
+
+	t.Run("check resync after garbage", func(t *testing.T) {
+		buf := bytes.NewBuffer(nil)
+
+		// garbage with preambule followed by implausible headers
+		buf.Write([]byte{0x01, 0x5A, 0xA5})
+		buf.Write(append(append([]byte{}, preambule...), 0x8F, 0x01))
+		buf.Write(append(append([]byte{}, preambule...), 0x82, 0x7E, 0x00, 0x10))
+		buf.Write(preambule[:3])
+
+		want := []byte("after garbage")
+		writeFrame(buf, false, want)
+
+		readerFactory := newFactory(buf)
+		reader, err := readerFactory.NewFrameReader()
+		if !assert.Equal(t, nil, err, "should not be error to resync frame") {
+			return
+		}
+
+		got, err := io.ReadAll(reader)
+		assert.Equal(t, nil, err, "should not be error to read payload")
+		assert.Equal(t, want, got, "should be equal messages")
+	})
+
+	t.Run("check bad preambule without resync", func(t *testing.T) {
+		buf := bytes.NewBuffer([]byte{0x01, 0x5A, 0xA5, 0x5A, 0xA5})
+
+		readerFactory := tcpFrameReaderFactory{Reader: bufio.NewReader(buf)}
+		_, err := readerFactory.NewFrameReader()
+		assert.Equal(t, ErrBadPreambule, err, "should be ErrBadPreambule error")
+	})
+}
+
+func TestTcpFrameReaderConsumed(t *testing.T) {
+	frame := []byte{
+		0x5A, 0xA5, 0x5A, 0xA5,
+		0x82, 0x08, 'c', 'o', 'n', 's', 'u', 'm', 'e', 'd',
+	}
+
+	t.Run("check complete frame", func(t *testing.T) {
+		readerFactory := tcpFrameReaderFactory{
+			Reader: bufio.NewReader(bytes.NewReader(frame)),
+		}
+
+		reader, err := readerFactory.NewFrameReader()
+		assert.Equal(t, nil, err, "should not be error creating reader")
+		assert.Equal(t, int64(2), reader.Consumed(), "should consume header before payload")
+
+		_, err = io.ReadAll(reader)
+		assert.Equal(t, nil, err, "should not be error to read payload")
+		assert.Equal(t, int64(reader.Len()), reader.Consumed(), "should consume whole frame")
+	})
+
+	t.Run("check truncated frame", func(t *testing.T) {
+		readerFactory := tcpFrameReaderFactory{
+			Reader: bufio.NewReader(bytes.NewReader(frame[:len(frame)-3])),
+		}
+
+		reader, err := readerFactory.NewFrameReader()
+		assert.Equal(t, nil, err, "should not be error creating reader")
+
+		_, err = io.ReadAll(reader)
+		assert.Equal(t, nil, err, "should not be error to read payload")
+		assert.Equal(t, int64(reader.Len()-3), reader.Consumed(), "should consume truncated frame")
+		assert.Less(t, reader.Consumed(), int64(reader.Len()), "should detect truncation")
+	})
+}
+
+func BenchmarkTcpFrameWriterWrite(b *testing.B) {
+	for _, length := range []int{16, 1024, 70000} {
+		b.Run(fmt.Sprintf("length %d", length), func(b *testing.B) {
+			writerFactory := tcpFrameWriterFactory{
+				Writer: bufio.NewWriter(io.Discard),
+			}
+
+			writer, _ := writerFactory.NewFrameWriter(BinaryFrame)
+			msg := make([]byte, length)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := writer.Write(msg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func FuzzParseFrame(f *testing.F) {
+	f.Add([]byte{0x5A, 0xA5, 0x5A, 0xA5, 0x81, 0x84, 0x0f, 0xff, 0xff, 0x0f, 't', 'e', 's', 't'})
+	f.Add([]byte{0x5A, 0xA5, 0x5A, 0xA5, 0x88, 0x00})
+	f.Add([]byte{0x5A, 0xA5, 0x5A, 0xA5, 0x02, 0x7E, 0x00, 0x80})
+	f.Add([]byte{0x5A, 0xA5, 0x5A, 0xA5, 0x82, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+	f.Add([]byte{0x01, 0x5A, 0xA5, 0x5A, 0xA5, 0x80, 0x7F, 0x00})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, resync := range []bool{false, true} {
+			readerFactory := tcpFrameReaderFactory{
+				Reader: bufio.NewReader(bytes.NewReader(data)),
+				resync: resync,
+			}
+
+			for {
+				reader, err := readerFactory.NewFrameReader()
+				if err != nil {
+					break
+				}
+
+				if reader.(*tcpFrameReader).header.Length < 0 {
+					t.Fatalf("negative payload length %d", reader.(*tcpFrameReader).header.Length)
+				}
+
+				_, _ = io.ReadAll(reader)
+			}
+		}
+
+		conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(data)}, nil, nil, 1024, false)
+		conn.SetResync(true)
+		for {
+			if _, err := conn.ReadFrame(); err != nil {
+				break
+			}
+		}
+	})
+}
+
+func TestTranslatingHandler(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+	conn := NewFrameConnection(connBuffer, nil, NewTranslatingHandler(map[byte]byte{3: BinaryFrame}), 0, false)
+
+	bw := bufio.NewWriter(connBuffer)
+	writeTestFrame(t, bw, 3, true, []byte("custom"))
+	writeTestFrame(t, bw, 4, true, []byte("unknown"))
+	writeTestFrame(t, bw, TextFrame, true, []byte("text"))
+
+	t.Run("check translated opcode", func(t *testing.T) {
+		payloadType, _, got, err := conn.ReadFrameRaw()
+		assert.Equal(t, nil, err, "should not be error to read translated frame")
+		assert.Equal(t, byte(BinaryFrame), payloadType, "should translate opcode 3 to binary")
+		assert.Equal(t, []byte("custom"), got, "should be equal payloads")
+	})
+
+	t.Run("check not mapped opcodes", func(t *testing.T) {
+		_, _, _, err := conn.ReadFrameRaw()
+		assert.Equal(t, ErrBadOpCode, err, "should be ErrBadOpCode error for not mapped opcode")
+
+		payloadType, _, got, err := conn.ReadFrameRaw()
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, byte(TextFrame), payloadType, "should keep not mapped opcode")
+		assert.Equal(t, []byte("text"), got, "should be equal payloads")
+	})
+}
@@ -0,0 +1,146 @@
+package gotcpws
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// FrameIn is direction of read frames passed to the frame logger
+	FrameIn = "←"
+
+	// FrameOut is direction of written frames passed to the frame logger
+	FrameOut = "→"
+
+	defaultFramePreviewLength = 32
+)
+
+// frameLoggerFunc is callback of SetFrameLogger
+type frameLoggerFunc func(dir string, h FrameHeader, payloadPreview []byte)
+
+// SetFrameLogger sets fn called with direction, header and payload preview
+// of each read and written frame, the preview is at most FramePreviewLength
+// bytes of unmasked payload. The preview of read frames is taken from
+// the read buffer, so it may be shorter if the payload does not fit it.
+// If fn is nil frames are not logged. DebugFrameString formats arguments
+// of fn for humans
+func (conn *Conn) SetFrameLogger(fn func(dir string, h FrameHeader, payloadPreview []byte)) {
+	if fn == nil {
+		conn.frameLogger.Store(nil)
+		return
+	}
+
+	f := frameLoggerFunc(fn)
+	conn.frameLogger.Store(&f)
+}
+
+// DebugFrameString returns human readable description of a frame, e.g.
+// "→ TEXT fin=true len=42 masked "hello"", preview is omitted if empty
+func DebugFrameString(dir string, h FrameHeader, payloadPreview []byte) string {
+	s := dir + " " + h.String()
+	if len(payloadPreview) > 0 {
+		s += fmt.Sprintf(" %q", payloadPreview)
+	}
+
+	return s
+}
+
+// String returns human readable header, e.g. "TEXT fin=true len=42 masked"
+func (h FrameHeader) String() string {
+	var sb strings.Builder
+	sb.WriteString(opCodeName(h.OpCode))
+	fmt.Fprintf(&sb, " fin=%t", h.Fin)
+	for i, rsv := range h.Rsv {
+		if rsv {
+			fmt.Fprintf(&sb, " rsv%d", i+1)
+		}
+	}
+	fmt.Fprintf(&sb, " len=%d", h.Length)
+	if h.MaskingKey != nil {
+		sb.WriteString(" masked")
+	}
+
+	return sb.String()
+}
+
+// opCodeName returns name of the opcode for frame descriptions
+func opCodeName(opcode byte) string {
+	switch opcode {
+	case ContinuationFrame:
+		return "CONT"
+	case TextFrame:
+		return "TEXT"
+	case BinaryFrame:
+		return "BINARY"
+	case CloseFrame:
+		return "CLOSE"
+	case PingFrame:
+		return "PING"
+	case PongFrame:
+		return "PONG"
+	default:
+		return fmt.Sprintf("OPCODE(%d)", opcode)
+	}
+}
+
+// previewLength returns maximum length of payload preview
+func (conn *Conn) previewLength() int {
+	if conn.FramePreviewLength > 0 {
+		return conn.FramePreviewLength
+	}
+
+	return defaultFramePreviewLength
+}
+
+// logFrameReadTo passes read frame to fn, payload of the frame is peeked
+// from the read buffer without consuming, rio must be held by the caller
+func (conn *Conn) logFrameReadTo(fn frameLoggerFunc, frame frameReader) {
+	r, ok := frame.(*tcpFrameReader)
+	if !ok {
+		fn(FrameIn, FrameHeader{Fin: frame.Fin(), Rsv: frame.Rsv(), OpCode: frame.PayloadType()}, nil)
+		return
+	}
+
+	h := FrameHeader{
+		Fin:        r.header.Fin,
+		Rsv:        r.header.Rsv,
+		OpCode:     r.header.OpCode,
+		Length:     r.header.Length,
+		MaskingKey: r.header.MaskingKey,
+	}
+
+	n := int64(min(conn.previewLength(), conn.buf.Reader.Size()))
+	if r.header.Length < n {
+		n = r.header.Length
+	}
+
+	// peek returns what is buffered on error, e.g. truncated payload
+	p, _ := conn.buf.Reader.Peek(int(n))
+	preview := make([]byte, len(p))
+	for i := range p {
+		preview[i] = p[i]
+		if r.header.MaskingKey != nil {
+			preview[i] ^= maskByte(r.header.MaskingKey, i)
+		}
+	}
+
+	fn(FrameIn, h, preview)
+}
+
+// logFrameWrittenTo passes written frame with payloads to fn
+func (conn *Conn) logFrameWrittenTo(fn frameLoggerFunc, header tcpFrameHeader, w frameWriter, length int, payloads [][]byte) {
+	h := FrameHeader{Fin: header.Fin, Rsv: header.Rsv, OpCode: header.OpCode, Length: int64(length)}
+	if fw, ok := w.(*tcpFrameWriter); ok {
+		h.MaskingKey = fw.header.MaskingKey
+	}
+
+	preview := make([]byte, 0, min(conn.previewLength(), length))
+	for _, payload := range payloads {
+		if len(preview) == cap(preview) {
+			break
+		}
+		preview = append(preview, payload[:min(len(payload), cap(preview)-len(preview))]...)
+	}
+
+	fn(FrameOut, h, preview)
+}
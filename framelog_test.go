@@ -0,0 +1,78 @@
+package gotcpws
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrameHeaderString(t *testing.T) {
+	testCases := []struct {
+		name   string
+		header FrameHeader
+		want   string
+	}{
+		{
+			name:   "text frame",
+			header: FrameHeader{Fin: true, OpCode: TextFrame, Length: 42, MaskingKey: []byte{1, 2, 3, 4}},
+			want:   "TEXT fin=true len=42 masked",
+		},
+		{
+			name:   "continuation frame with rsv",
+			header: FrameHeader{Rsv: [3]bool{true, false, true}, OpCode: ContinuationFrame, Length: 3},
+			want:   "CONT fin=false rsv1 rsv3 len=3",
+		},
+		{
+			name:   "unknown opcode",
+			header: FrameHeader{Fin: true, OpCode: 5},
+			want:   "OPCODE(5) fin=true len=0",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.header.String(), "should be equal descriptions")
+		})
+	}
+}
+
+func TestConnSetFrameLogger(t *testing.T) {
+	for _, masked := range []bool{false, true} {
+		connBuffer := bytes.NewBuffer(nil)
+		conn := NewFrameConnection(testConn{connBuffer}, nil, nil, 0, masked)
+		conn.FramePreviewLength = 5
+
+		var lines []string
+		conn.SetFrameLogger(func(dir string, h FrameHeader, payloadPreview []byte) {
+			lines = append(lines, DebugFrameString(dir, h, payloadPreview))
+		})
+
+		suffix := ""
+		if masked {
+			suffix = " masked"
+		}
+
+		t.Run("check written frame", func(t *testing.T) {
+			_, err := conn.Write([]byte("hello world"))
+			assert.Equal(t, nil, err, "should not be error to write")
+			assert.Equal(t, []string{"→ TEXT fin=true len=11" + suffix + ` "hello"`}, lines, "should log written frame")
+		})
+
+		t.Run("check read frame", func(t *testing.T) {
+			lines = nil
+			msg, err := conn.ReadFrame()
+			assert.Equal(t, nil, err, "should not be error to read")
+			assert.Equal(t, []byte("hello world"), msg, "should not consume payload by preview")
+			assert.Equal(t, []string{"← TEXT fin=true len=11" + suffix + ` "hello"`}, lines, "should log read frame")
+		})
+
+		t.Run("check no logger", func(t *testing.T) {
+			lines = nil
+			conn.SetFrameLogger(nil)
+			_, err := conn.Write(nil)
+			assert.Equal(t, nil, err, "should not be error to write")
+			assert.Equal(t, []string(nil), lines, "should not log frames")
+		})
+	}
+}
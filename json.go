@@ -0,0 +1,61 @@
+package gotcpws
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// WriteJSONStream marshals v as JSON and writes it with appended newline
+// as a text frame, so the peer can read it with ReadJSONStream
+func (conn *Conn) WriteJSONStream(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	conn.wio.Lock()
+	defer conn.wio.Unlock()
+
+	_, err = conn.writeFrame(TextFrame, data)
+	return err
+}
+
+// ReadJSONStream reads next newline-delimited JSON object of the stream
+// and unmarshals it into v. A frame may contain several objects and an object
+// may be split across several frames, the rest of read frames is kept for
+// the next call. If pending data of the stream without newline is greater
+// than MaxPayloadBytes return ErrFrameTooLarge
+func (conn *Conn) ReadJSONStream(v any) error {
+	if err := conn.checkPrefetch(); err != nil {
+		return err
+	}
+
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	for {
+		if i := bytes.IndexByte(conn.jsonBuf.Bytes(), '\n'); i >= 0 {
+			line := conn.jsonBuf.Next(i + 1)
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+
+			return json.Unmarshal(line, v)
+		}
+
+		frame, err := conn.nextFrame()
+		if err != nil {
+			return err
+		}
+
+		if _, err := conn.jsonBuf.ReadFrom(frame); err != nil {
+			return err
+		}
+
+		if conn.jsonBuf.Len() > conn.maxPayloadBytes(TextFrame) {
+			conn.jsonBuf.Reset()
+			return ErrFrameTooLarge
+		}
+	}
+}
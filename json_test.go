@@ -0,0 +1,70 @@
+package gotcpws
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testLogRecord struct {
+	Level string `json:"level"`
+	Msg   string `json:"msg"`
+}
+
+func TestConnJSONStream(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+	bw := bufio.NewWriter(connBuffer)
+
+	t.Run("check write and read json stream", func(t *testing.T) {
+		want := []testLogRecord{{"info", "started"}, {"error", "failed"}}
+		for _, record := range want {
+			assert.Equal(t, nil, conn.WriteJSONStream(record), "should not be error to write")
+		}
+
+		for _, record := range want {
+			var got testLogRecord
+			assert.Equal(t, nil, conn.ReadJSONStream(&got), "should not be error to read")
+			assert.Equal(t, record, got, "should be equal records")
+		}
+	})
+
+	t.Run("check multi-object frame", func(t *testing.T) {
+		writeTestFrame(t, bw, TextFrame, true, []byte(
+			"{\"level\":\"info\",\"msg\":\"a\"}\n\n{\"level\":\"info\",\"msg\":\"b\"}\n",
+		))
+
+		for _, msg := range []string{"a", "b"} {
+			var got testLogRecord
+			assert.Equal(t, nil, conn.ReadJSONStream(&got), "should not be error to read")
+			assert.Equal(t, testLogRecord{"info", msg}, got, "should be equal records")
+		}
+	})
+
+	t.Run("check split objects", func(t *testing.T) {
+		writeTestFrame(t, bw, TextFrame, true, []byte("{\"level\":\"debug\",\"msg\":\"c\"}\n{\"level\":"))
+		writeTestFrame(t, bw, TextFrame, true, []byte("\"warn\","))
+		writeTestFrame(t, bw, TextFrame, true, []byte("\"msg\":\"d\"}\n"))
+
+		var got testLogRecord
+		assert.Equal(t, nil, conn.ReadJSONStream(&got), "should not be error to read")
+		assert.Equal(t, testLogRecord{"debug", "c"}, got, "should be equal records")
+
+		assert.Equal(t, nil, conn.ReadJSONStream(&got), "should not be error to read split object")
+		assert.Equal(t, testLogRecord{"warn", "d"}, got, "should be equal split records")
+	})
+
+	t.Run("check pending data too large", func(t *testing.T) {
+		conn.MaxPayloadBytes = 10
+		writeTestFrame(t, bw, TextFrame, true, []byte("{\"level\":"))
+		writeTestFrame(t, bw, TextFrame, true, []byte("\"info\""))
+
+		var got testLogRecord
+		assert.Equal(t, ErrFrameTooLarge, conn.ReadJSONStream(&got), "should be ErrFrameTooLarge error")
+	})
+}
@@ -0,0 +1,49 @@
+package gotcpws
+
+import (
+	"context"
+	"log/slog"
+)
+
+// discardHandler is slog handler of the default logger which drops records
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// discardLogger is default logger of the connection
+var discardLogger = slog.New(discardHandler{})
+
+// SetLogger sets logger used for debug logging of read and written frames,
+// close frames and errors not returned to the caller, if l is nil
+// nothing is logged
+func (conn *Conn) SetLogger(l *slog.Logger) {
+	conn.logger.Store(l)
+}
+
+// log returns logger of the connection
+func (conn *Conn) log() *slog.Logger {
+	if l := conn.logger.Load(); l != nil {
+		return l
+	}
+
+	return discardLogger
+}
+
+// debugEnabled reports whether the logger logs debug records, it guards
+// logging of each frame, so arguments are not built for discarded records
+func (conn *Conn) debugEnabled() bool {
+	return conn.log().Enabled(context.Background(), slog.LevelDebug)
+}
+
+// logFrameRead logs read frame with length of its payload
+func (conn *Conn) logFrameRead(frame frameReader) {
+	length := int64(frame.Len())
+	if r, ok := frame.(*tcpFrameReader); ok {
+		length = r.header.Length
+	}
+
+	conn.log().Debug("frame read", "opcode", frame.PayloadType(), "fin", frame.Fin(), "length", length)
+}
@@ -0,0 +1,86 @@
+package gotcpws
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// captureHandler is slog handler keeping messages of records
+type captureHandler struct {
+	mu       sync.Mutex
+	messages []string
+	attrs    []map[string]any
+}
+
+func (h *captureHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *captureHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := make(map[string]any)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs[a.Key] = a.Value.Any()
+		return true
+	})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.messages = append(h.messages, r.Message)
+	h.attrs = append(h.attrs, attrs)
+	return nil
+}
+
+func (h *captureHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *captureHandler) WithGroup(string) slog.Handler      { return h }
+
+func TestConnLogger(t *testing.T) {
+	t.Run("check session is logged", func(t *testing.T) {
+		handler := &captureHandler{}
+		conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, false)
+		conn.SetLogger(slog.New(handler))
+
+		_, err := conn.Write([]byte("logged"))
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		_, err = conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+
+		assert.Equal(t, nil, conn.CloseWithStatus(closeStatusGoingAway, "bye"), "should not be error to close")
+
+		assert.Equal(t, []string{"frame written", "frame read", "frame written", "close frame sent"}, handler.messages, "should log session")
+		assert.Equal(t, int64(len("logged")), handler.attrs[0]["length"], "should log length of written frame")
+		assert.Equal(t, int64(len("logged")), handler.attrs[1]["length"], "should log length of read frame")
+		assert.Equal(t, int64(closeStatusGoingAway), handler.attrs[3]["status"], "should log close status")
+		assert.Equal(t, "bye", handler.attrs[3]["reason"], "should log close reason")
+	})
+
+	t.Run("check received close and protocol error", func(t *testing.T) {
+		handler := &captureHandler{}
+		connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+
+		_, err := conn.writeFrame(CloseFrame, closePayload(closeStatusNormal, "done"))
+		assert.Equal(t, nil, err, "should not be error to write close frame")
+		connBuffer.Write([]byte{0x5A, 0xA5, 0x5A, 0xA5, 0x8F, 0x00})
+
+		conn.SetLogger(slog.New(handler))
+		_, _ = conn.ReadFrame()
+		_, err = conn.ReadFrame()
+		assert.Equal(t, ErrBadOpCode, err, "should be ErrBadOpCode error")
+
+		assert.Contains(t, handler.messages, "close frame received", "should log received close frame")
+		assert.Contains(t, handler.messages, "protocol error", "should log protocol error")
+	})
+
+	t.Run("check default logger", func(t *testing.T) {
+		conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, false)
+		assert.Equal(t, false, conn.debugEnabled(), "should discard records by default")
+
+		conn.SetLogger(nil)
+		assert.Equal(t, discardLogger, conn.log(), "should discard records without logger")
+	})
+}
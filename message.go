@@ -0,0 +1,226 @@
+package gotcpws
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrWriterClosed returns by Write and Close of closed message writer
+var ErrWriterClosed = errors.New("error use of closed message writer")
+
+// MessageReader returns payload type and reader of the next message of the
+// connection. The reader reads payloads of all fragments of the message and
+// returns io.EOF after the final one. Control frames interleaved with
+// the fragments are not exposed to the reader: ping frames are answered
+// with pong frames and close frame is answered with close frame, then
+// the reader returns io.ErrUnexpectedEOF.
+// The reader is valid until the next read of the connection
+func (conn *Conn) MessageReader() (byte, io.Reader, error) {
+	if err := conn.checkPrefetch(); err != nil {
+		return 0, nil, err
+	}
+
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	frame, err := conn.nextMessageFrame(conn.replyPing)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	conn.frameReader = frame
+	return frame.PayloadType(), &messageReader{conn: conn, frame: frame}, nil
+}
+
+// NextReader returns payload type and reader of the next message like
+// MessageReader, so huge messages are processed without reading them
+// into memory. The reader spans fragments and skips control frames
+func (conn *Conn) NextReader() (byte, io.Reader, error) {
+	return conn.MessageReader()
+}
+
+// messageReader reads payloads of fragments of a message
+type messageReader struct {
+	conn *Conn
+
+	// frame is the current fragment, nil after the final one is read
+	frame frameReader
+}
+
+func (r *messageReader) Read(p []byte) (int, error) {
+	conn := r.conn
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for r.frame != nil {
+		// the fragment is finished by other read of the connection
+		if conn.frameReader != r.frame {
+			r.frame = nil
+			return 0, io.ErrUnexpectedEOF
+		}
+
+		n, err := r.frame.Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+
+		fin := r.frame.Fin()
+		conn.frameReader = nil
+		r.frame = nil
+		if fin {
+			return n, io.EOF
+		}
+
+		frame, err := conn.nextMessageFrame(conn.replyPing)
+		if err == io.EOF {
+			return n, io.ErrUnexpectedEOF
+		}
+
+		if err != nil {
+			return n, err
+		}
+
+		conn.frameReader = frame
+		r.frame = frame
+		if n > 0 {
+			return n, nil
+		}
+	}
+
+	return 0, io.EOF
+}
+
+// NextWriter returns writer of a message with payloadType, each Write
+// writes its payload as the next fragment of the message and Close writes
+// the final fragment, so the message is streamed without buffering.
+// The writer holds the write lock of the connection until Close, so other
+// writes wait for the end of the message, ping and pong frames written by
+// WriteControl are written between fragments
+func (conn *Conn) NextWriter(payloadType byte) (io.WriteCloser, error) {
+	if payloadType != TextFrame && payloadType != BinaryFrame {
+		return nil, ErrBadOpCode
+	}
+
+	conn.wio.Lock()
+	if conn.closeSent.Load() {
+		conn.wio.Unlock()
+		return nil, ErrConnClosed
+	}
+
+	return &messageWriter{conn: conn, opCode: payloadType}, nil
+}
+
+// messageWriter writes fragments of a message, wio is held until Close
+type messageWriter struct {
+	conn *Conn
+
+	// opCode is opcode of the next fragment
+	opCode byte
+
+	// err is error of the last write, closed writer has ErrWriterClosed
+	err error
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if err := w.writeFragment(false, p); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close writes the final fragment of the message and releases
+// the write lock of the connection
+func (w *messageWriter) Close() error {
+	if w.err == ErrWriterClosed {
+		return ErrWriterClosed
+	}
+	defer w.conn.wio.Unlock()
+
+	err := w.err
+	if err == nil {
+		err = w.writeFragment(true, nil)
+	}
+
+	w.err = ErrWriterClosed
+	return err
+}
+
+// writeFragment writes payload as the next fragment of the message
+func (w *messageWriter) writeFragment(fin bool, payload []byte) error {
+	conn := w.conn
+	if _, err := conn.writeFrameHeader(tcpFrameHeader{Fin: fin, OpCode: w.opCode}, payload); err != nil {
+		w.err = err
+		return err
+	}
+	w.opCode = ContinuationFrame
+
+	// control frames are not delayed until the end of the message
+	if !fin {
+		conn.writePendingControl()
+	}
+
+	return nil
+}
+
+// nextMessageFrame returns next frame of a message, ping and pong frames
+// are passed to hook and close frame is answered with close frame,
+// rio must be held by the caller
+func (conn *Conn) nextMessageFrame(hook func(payloadType byte, payload []byte) error) (frameReader, error) {
+	conn.controlHook = hook
+	defer func() { conn.controlHook = nil }()
+
+	frame, err := conn.nextFrame()
+	if err == io.EOF && conn.closeReceived {
+		err1 := conn.writeClose(closeStatusNormal, "")
+		if err1 != nil && err1 != ErrConnClosed {
+			conn.reportError(err1)
+		}
+	}
+
+	return frame, err
+}
+
+// readControl reads payload of ping or pong frame and passes it
+// to controlHook, rio must be held by the caller
+func (conn *Conn) readControl(frame frameReader) error {
+	if !frame.Fin() {
+		_, _ = io.Copy(io.Discard, frame)
+		return ErrUnexpectedFragment
+	}
+
+	if r, ok := frame.(*tcpFrameReader); ok && r.header.Length > maxControlPayloadBytes {
+		_, _ = io.Copy(io.Discard, frame)
+		return ErrControlFrameTooLarge
+	}
+
+	payload, err := io.ReadAll(frame)
+	if err != nil {
+		return err
+	}
+
+	return conn.controlHook(frame.PayloadType(), payload)
+}
+
+// replyPing queues pong frame with payload of ping frame,
+// pong frames are skipped
+func (conn *Conn) replyPing(payloadType byte, payload []byte) error {
+	if payloadType != PingFrame {
+		return nil
+	}
+
+	conn.writePong(payload)
+	return nil
+}
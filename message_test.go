@@ -0,0 +1,242 @@
+package gotcpws
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnMessageReader(t *testing.T) {
+	t.Run("check ping between fragments", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer server.Close()
+		defer client.Close()
+
+		conn := NewFrameConnection(server, nil, nil, 0, false)
+		peer := NewFrameConnection(client, nil, nil, 0, false)
+		control := peer.ControlFrames()
+
+		go func() {
+			_, _ = peer.WriteFrame(TextFrame, false, []byte("hello "))
+			_, _ = peer.WriteControl(PingFrame, []byte("are you there"))
+			_, _ = peer.WriteFrame(ContinuationFrame, true, []byte("world"))
+		}()
+
+		// pong frames are delivered to control frames by reads of the peer
+		go func() {
+			for {
+				if _, err := peer.ReadFrame(); err != nil {
+					return
+				}
+			}
+		}()
+
+		payloadType, r, err := conn.MessageReader()
+		assert.Equal(t, nil, err, "should not be error to get message reader")
+		assert.Equal(t, byte(TextFrame), payloadType, "should be equal payload types")
+
+		var buf bytes.Buffer
+		_, err = io.Copy(&buf, r)
+		assert.Equal(t, nil, err, "should not be error to copy message")
+		assert.Equal(t, "hello world", buf.String(), "should not expose ping to the reader")
+
+		select {
+		case frame := <-control:
+			assert.Equal(t, byte(PongFrame), frame.PayloadType, "should answer ping with pong")
+			assert.Equal(t, []byte("are you there"), frame.Payload, "should answer with payload of ping")
+		case <-time.After(time.Second):
+			t.Error("should answer ping with pong")
+		}
+	})
+
+	t.Run("check pings are answered by one pong", func(t *testing.T) {
+		connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+		writer := NewFrameConnection(connBuffer, nil, nil, 0, false)
+		for _, payload := range []string{"1", "2", "3"} {
+			_, _ = writer.WriteControl(PingFrame, []byte(payload))
+		}
+		_, _ = writer.Write([]byte("data"))
+
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+
+		// pong frames wait for wio held by a write
+		conn.wio.Lock()
+		_, r, err := conn.MessageReader()
+		assert.Equal(t, nil, err, "should not be error to get message reader")
+		got, err := io.ReadAll(r)
+		assert.Equal(t, nil, err, "should not be error to read message")
+		assert.Equal(t, []byte("data"), got, "should not expose pings to the reader")
+
+		conn.writePendingControl()
+		conn.wio.Unlock()
+
+		readerFactory := tcpFrameReaderFactory{Reader: bufio.NewReader(connBuffer)}
+		frame, err := readerFactory.NewFrameReader()
+		assert.Equal(t, nil, err, "should not be error to read pong")
+		assert.Equal(t, byte(PongFrame), frame.PayloadType(), "should answer pings with pong")
+
+		payload, _ := io.ReadAll(frame)
+		assert.Equal(t, []byte("3"), payload, "should answer with payload of the last ping")
+
+		_, err = readerFactory.NewFrameReader()
+		assert.Equal(t, io.EOF, err, "should write one pong")
+	})
+
+	t.Run("check close between fragments", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer server.Close()
+		defer client.Close()
+
+		conn := NewFrameConnection(server, nil, nil, 0, false)
+		peer := NewFrameConnection(client, nil, nil, 0, false)
+
+		type closeResult struct {
+			status int
+			err    error
+		}
+		closed := make(chan closeResult, 1)
+		go func() {
+			_, _ = peer.WriteFrame(BinaryFrame, false, []byte("partial"))
+			status, _, err := peer.WriteCloseAndWait(closeStatusGoingAway, "bye", time.Second)
+			closed <- closeResult{status: status, err: err}
+		}()
+
+		payloadType, r, err := conn.MessageReader()
+		assert.Equal(t, nil, err, "should not be error to get message reader")
+		assert.Equal(t, byte(BinaryFrame), payloadType, "should be equal payload types")
+
+		got, err := io.ReadAll(r)
+		assert.Equal(t, io.ErrUnexpectedEOF, err, "should be unexpected EOF on close")
+		assert.Equal(t, []byte("partial"), got, "should read fragments before close")
+
+		result := <-closed
+		assert.Equal(t, nil, result.err, "should not be error to wait for close")
+		assert.Equal(t, closeStatusNormal, result.status, "should answer close with close")
+	})
+
+	t.Run("check message after message", func(t *testing.T) {
+		connBuffer := testConn{
+			Buffer: bytes.NewBuffer(nil),
+		}
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+
+		_, err := conn.WriteFragmented(BinaryFrame, []byte("first message"), 4)
+		assert.Equal(t, nil, err, "should not be error to write")
+		_, err = conn.Write([]byte("second"))
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		_, r, err := conn.MessageReader()
+		assert.Equal(t, nil, err, "should not be error to get message reader")
+		got, err := io.ReadAll(r)
+		assert.Equal(t, nil, err, "should not be error to read message")
+		assert.Equal(t, []byte("first message"), got, "should be equal messages")
+
+		_, r, err = conn.MessageReader()
+		assert.Equal(t, nil, err, "should not be error to get message reader")
+		got, err = io.ReadAll(r)
+		assert.Equal(t, nil, err, "should not be error to read message")
+		assert.Equal(t, []byte("second"), got, "should be equal messages")
+	})
+}
+
+func TestConnNextReader(t *testing.T) {
+	conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, false)
+
+	_, err := conn.WriteFrame(BinaryFrame, false, []byte("huge "))
+	assert.Equal(t, nil, err, "should not be error to write first fragment")
+	assert.Equal(t, nil, conn.Pong([]byte("interleaved")), "should not be error to write pong")
+	_, err = conn.WriteFrame(ContinuationFrame, true, []byte("message"))
+	assert.Equal(t, nil, err, "should not be error to write final fragment")
+	_, err = conn.Write([]byte("next"))
+	assert.Equal(t, nil, err, "should not be error to write")
+
+	payloadType, r, err := conn.NextReader()
+	assert.Equal(t, nil, err, "should not be error to get next reader")
+	assert.Equal(t, byte(BinaryFrame), payloadType, "should be payload type of the message")
+
+	got, err := io.ReadAll(r)
+	assert.Equal(t, nil, err, "should not be error to read message")
+	assert.Equal(t, []byte("huge message"), got, "should span fragments and skip control frames")
+
+	msg, err := conn.ReadFrame()
+	assert.Equal(t, nil, err, "should not be error to read next message")
+	assert.Equal(t, []byte("next"), msg, "should be equal messages")
+}
+
+func TestConnNextWriter(t *testing.T) {
+	t.Run("check streamed message", func(t *testing.T) {
+		conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, false)
+
+		w, err := conn.NextWriter(TextFrame)
+		assert.Equal(t, nil, err, "should not be error to get next writer")
+		for _, part := range []string{"str", "eam", "ed"} {
+			n, err := w.Write([]byte(part))
+			assert.Equal(t, nil, err, "should not be error to write fragment")
+			assert.Equal(t, len(part), n, "should write all payload")
+		}
+		assert.Equal(t, nil, w.Close(), "should not be error to close writer")
+
+		for i, part := range []string{"str", "eam", "ed", ""} {
+			payloadType, fin, got, err := conn.ReadFrameRaw()
+			assert.Equal(t, nil, err, "should not be error to read fragment")
+			assert.Equal(t, byte(TextFrame), payloadType, "should be payload type of the message")
+			assert.Equal(t, i == 3, fin, "should set fin bit only on close")
+			assert.Equal(t, part, string(got), "should be equal fragments")
+		}
+	})
+
+	t.Run("check writes wait for the end of the message", func(t *testing.T) {
+		conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, false)
+
+		w, err := conn.NextWriter(BinaryFrame)
+		assert.Equal(t, nil, err, "should not be error to get next writer")
+		_, err = w.Write([]byte("first "))
+		assert.Equal(t, nil, err, "should not be error to write fragment")
+
+		written := make(chan error, 1)
+		go func() {
+			_, err := conn.Write([]byte("second"))
+			written <- err
+		}()
+
+		select {
+		case <-written:
+			t.Fatal("should not write in the middle of the message")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		_, err = w.Write([]byte("message"))
+		assert.Equal(t, nil, err, "should not be error to write fragment")
+		assert.Equal(t, nil, w.Close(), "should not be error to close writer")
+		assert.Equal(t, nil, <-written, "should not be error to write after the message")
+
+		for _, want := range []string{"first message", "second"} {
+			got, err := conn.ReadFrame()
+			assert.Equal(t, nil, err, "should not be error to read")
+			assert.Equal(t, want, string(got), "should keep the message contiguous")
+		}
+	})
+
+	t.Run("check bad writers", func(t *testing.T) {
+		conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, false)
+
+		_, err := conn.NextWriter(CloseFrame)
+		assert.Equal(t, ErrBadOpCode, err, "should be ErrBadOpCode error")
+
+		w, err := conn.NextWriter(TextFrame)
+		assert.Equal(t, nil, err, "should not be error to get next writer")
+		assert.Equal(t, nil, w.Close(), "should not be error to close empty message")
+		_, err = w.Write([]byte("late"))
+		assert.Equal(t, ErrWriterClosed, err, "should be ErrWriterClosed error")
+		assert.Equal(t, ErrWriterClosed, w.Close(), "should be ErrWriterClosed error")
+
+		assert.Equal(t, nil, conn.Close(), "should not be error to close connection")
+		_, err = conn.NextWriter(TextFrame)
+		assert.Equal(t, ErrConnClosed, err, "should be ErrConnClosed error")
+	})
+}
@@ -0,0 +1,40 @@
+package gotcpws
+
+// MetricsCollector collects metrics of the connection, e.g. to export them
+// to Prometheus. Methods are called by reading and writing goroutines
+type MetricsCollector interface {
+	IncFramesRead()
+	IncBytesRead(n int64)
+	IncFramesWritten()
+	IncBytesWritten(n int64)
+
+	// IncOversized is called on frame rejected with ErrFrameTooLarge
+	IncOversized()
+
+	// IncProtocolErrors is called on read frame violating the protocol
+	IncProtocolErrors()
+}
+
+// noopMetrics is default MetricsCollector which does nothing
+type noopMetrics struct{}
+
+func (noopMetrics) IncFramesRead()        {}
+func (noopMetrics) IncBytesRead(int64)    {}
+func (noopMetrics) IncFramesWritten()     {}
+func (noopMetrics) IncBytesWritten(int64) {}
+func (noopMetrics) IncOversized()         {}
+func (noopMetrics) IncProtocolErrors()    {}
+
+// SetMetricsCollector sets collector of metrics of the connection,
+// if collector is nil metrics are not collected
+func (conn *Conn) SetMetricsCollector(collector MetricsCollector) {
+	if collector == nil {
+		collector = noopMetrics{}
+	}
+
+	conn.rio.Lock()
+	conn.wio.Lock()
+	conn.metrics = collector
+	conn.wio.Unlock()
+	conn.rio.Unlock()
+}
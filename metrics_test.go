@@ -0,0 +1,67 @@
+package gotcpws
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingMetrics is MetricsCollector counting calls of its methods
+type countingMetrics struct {
+	framesRead, bytesRead       int64
+	framesWritten, bytesWritten int64
+	oversized, protocolErrors   int64
+}
+
+func (m *countingMetrics) IncFramesRead()          { m.framesRead++ }
+func (m *countingMetrics) IncBytesRead(n int64)    { m.bytesRead += n }
+func (m *countingMetrics) IncFramesWritten()       { m.framesWritten++ }
+func (m *countingMetrics) IncBytesWritten(n int64) { m.bytesWritten += n }
+func (m *countingMetrics) IncOversized()           { m.oversized++ }
+func (m *countingMetrics) IncProtocolErrors()      { m.protocolErrors++ }
+
+func TestConnMetricsCollector(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+	metrics := &countingMetrics{}
+	conn.SetMetricsCollector(metrics)
+
+	_, err := conn.Write([]byte("first"))
+	assert.Equal(t, nil, err, "should not be error to write")
+	_, err = conn.Write([]byte("second"))
+	assert.Equal(t, nil, err, "should not be error to write")
+
+	t.Run("check written frames", func(t *testing.T) {
+		assert.Equal(t, int64(2), metrics.framesWritten, "should count written frames")
+		assert.Equal(t, int64(len("firstsecond")), metrics.bytesWritten, "should count written payload bytes")
+	})
+
+	conn.MaxPayloadBytes = 5
+	bw := bufio.NewWriter(connBuffer)
+	writeTestFrame(t, bw, 5, true, []byte("bad"))
+
+	t.Run("check read frames", func(t *testing.T) {
+		_, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		_, err = conn.ReadFrame()
+		assert.Equal(t, ErrFrameTooLarge, err, "should be ErrFrameTooLarge error")
+		_, err = conn.ReadFrame()
+		assert.Equal(t, ErrBadOpCode, err, "should be ErrBadOpCode error")
+
+		assert.Equal(t, int64(3), metrics.framesRead, "should count read frames")
+		assert.Equal(t, int64(len("firstsecondbad")), metrics.bytesRead, "should count read payload bytes")
+		assert.Equal(t, int64(1), metrics.oversized, "should count oversized frames")
+		assert.Equal(t, int64(1), metrics.protocolErrors, "should count protocol errors")
+	})
+
+	t.Run("nil collector", func(t *testing.T) {
+		conn.SetMetricsCollector(nil)
+		_, err := conn.Write([]byte("third"))
+		assert.Equal(t, nil, err, "should not be error to write without collector")
+		assert.Equal(t, int64(2), metrics.framesWritten, "should not count frames after collector is removed")
+	})
+}
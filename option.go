@@ -0,0 +1,45 @@
+package gotcpws
+
+import "bufio"
+
+// Option configures connection created by NewConn
+type Option func(o *connOptions)
+
+// connOptions are settings of NewConn
+type connOptions struct {
+	buf             *bufio.ReadWriter
+	handler         frameHandler
+	maxPayloadBytes int
+	masking         bool
+}
+
+// WithBuffers sets buffers of the connection, if buf is nil
+// the connection creates buffers of rwc
+func WithBuffers(buf *bufio.ReadWriter) Option {
+	return func(o *connOptions) {
+		o.buf = buf
+	}
+}
+
+// WithHandler sets handler of frame headers and close of the connection,
+// if handler is nil tcpFrameHandler is used
+func WithHandler(handler frameHandler) Option {
+	return func(o *connOptions) {
+		o.handler = handler
+	}
+}
+
+// WithMaxPayloadBytes sets max size of the message,
+// if 0 DefaultMaxPayloadBytes is used
+func WithMaxPayloadBytes(maxPayloadBytes int) Option {
+	return func(o *connOptions) {
+		o.maxPayloadBytes = maxPayloadBytes
+	}
+}
+
+// WithMasking specifies whether payloads of written frames are masked
+func WithMasking(masking bool) Option {
+	return func(o *connOptions) {
+		o.masking = masking
+	}
+}
@@ -0,0 +1,53 @@
+package gotcpws
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewConn(t *testing.T) {
+	t.Run("check defaults", func(t *testing.T) {
+		connBuffer := bytes.NewBuffer(nil)
+		conn := NewConn(testConn{connBuffer})
+
+		_, ok := conn.frameHandler.(*tcpFrameHandler)
+		assert.Equal(t, true, ok, "should use tcpFrameHandler")
+		assert.Equal(t, 0, conn.MaxPayloadBytes, "should use DefaultMaxPayloadBytes")
+		assert.Equal(t, false, conn.frameWriterFactory.(*tcpFrameWriterFactory).needMaskingKey, "should not mask payload")
+
+		_, err := conn.Write([]byte("hello"))
+		assert.Equal(t, nil, err, "should not be error to write")
+		msg, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, []byte("hello"), msg, "should be equal messages")
+	})
+
+	t.Run("check options", func(t *testing.T) {
+		connBuffer := bytes.NewBuffer(nil)
+		buf := bufio.NewReadWriter(bufio.NewReader(connBuffer), bufio.NewWriter(connBuffer))
+		handler := &tcpFrameHandler{}
+
+		conn := NewConn(
+			testConn{connBuffer},
+			WithBuffers(buf),
+			WithHandler(handler),
+			WithMaxPayloadBytes(16),
+			WithMasking(true),
+		)
+
+		assert.Equal(t, buf, conn.buf, "should use the buffers")
+		assert.Equal(t, frameHandler(handler), conn.frameHandler, "should use the handler")
+		assert.Equal(t, 16, conn.MaxPayloadBytes, "should set max payload bytes")
+		assert.Equal(t, true, conn.frameWriterFactory.(*tcpFrameWriterFactory).needMaskingKey, "should mask payload")
+
+		_, err := conn.Write([]byte("hello"))
+		assert.Equal(t, nil, err, "should not be error to write")
+		msg, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, []byte("hello"), msg, "should be equal messages")
+		assert.NotEqual(t, []byte(nil), conn.lastMaskingKey, "should read masked frame")
+	})
+}
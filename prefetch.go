@@ -0,0 +1,134 @@
+package gotcpws
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrPrefetchEnabled returns by reads of the connection other than
+// ReadFrame and ReadFrameWithin after EnablePrefetch, because frames
+// are read by the prefetcher
+var ErrPrefetchEnabled = errors.New("error prefetch is enabled")
+
+// prefetched is payload of a frame read ahead or error of the read
+type prefetched struct {
+	data []byte
+	err  error
+}
+
+// prefetcher reads frames of the connection ahead in its own goroutine
+type prefetcher struct {
+	frames chan prefetched
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// err is error which stopped reading, it is set before frames is closed
+	err error
+}
+
+// EnablePrefetch starts reading of frames ahead in background, so the next
+// frame is read while the caller processes the current one. ReadFrame pops
+// read frames in order, up to depth frames are kept read ahead. After the
+// call frames must be read only by ReadFrame and ReadFrameWithin, which
+// waits for the read ahead frame without setting the read deadline, other
+// reads return ErrPrefetchEnabled. Reading ahead is stopped
+// on close of the connection or on error which breaks the stream
+func (conn *Conn) EnablePrefetch(depth int) {
+	p := &prefetcher{
+		frames: make(chan prefetched, max(depth, 1)),
+		stop:   make(chan struct{}),
+	}
+
+	if !conn.prefetch.CompareAndSwap(nil, p) {
+		return
+	}
+
+	// the connection may be closed before the prefetcher is set
+	if conn.closed.Load() {
+		p.halt()
+	}
+
+	go conn.runPrefetch(p)
+}
+
+// checkPrefetch returns ErrPrefetchEnabled if frames are read ahead
+func (conn *Conn) checkPrefetch() error {
+	if conn.prefetch.Load() != nil {
+		return ErrPrefetchEnabled
+	}
+
+	return nil
+}
+
+// runPrefetch reads frames into p until error or halt of p
+func (conn *Conn) runPrefetch(p *prefetcher) {
+	defer close(p.frames)
+
+	for {
+		data, err := conn.readFrameData()
+		if err != nil && conn.closed.Load() {
+			// rejected frame which closed the connection is returned
+			// before ErrConnClosed if there is room for it
+			if isRejectedFrame(err) {
+				select {
+				case p.frames <- prefetched{err: err}:
+				default:
+				}
+			}
+
+			p.err = ErrConnClosed
+			return
+		}
+
+		if err != nil && !isRejectedFrame(err) {
+			p.err = err
+		}
+
+		select {
+		case p.frames <- prefetched{data: data, err: err}:
+		case <-p.stop:
+			p.err = ErrConnClosed
+			return
+		}
+
+		if p.err != nil {
+			return
+		}
+	}
+}
+
+// next returns the next read frame
+func (p *prefetcher) next() ([]byte, error) {
+	frame, ok := <-p.frames
+	if !ok {
+		return nil, p.err
+	}
+
+	return frame.data, frame.err
+}
+
+// nextWithin returns the next read frame like next, but waits for it only
+// until deadline, the read deadline of the connection is not touched, so
+// reading ahead goes on after timeout
+func (p *prefetcher) nextWithin(deadline time.Time) ([]byte, error) {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case frame, ok := <-p.frames:
+		if !ok {
+			return nil, p.err
+		}
+		return frame.data, frame.err
+	case <-timer.C:
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+// halt stops reading of frames
+func (p *prefetcher) halt() {
+	p.stopOnce.Do(func() { close(p.stop) })
+}
@@ -0,0 +1,88 @@
+package gotcpws
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnPrefetch(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	conn := NewFrameConnection(server, nil, nil, 0, false)
+	conn.MaxPayloadBytes = 16
+	peer := NewFrameConnection(client, nil, nil, 0, true)
+	conn.EnablePrefetch(2)
+
+	t.Run("check frames in order", func(t *testing.T) {
+		go func() {
+			for i := 0; i < 5; i++ {
+				_, _ = peer.Write([]byte(fmt.Sprintf("frame %d", i)))
+			}
+		}()
+
+		for i := 0; i < 5; i++ {
+			got, err := conn.ReadFrame()
+			assert.Equal(t, nil, err, "should not be error to read prefetched frame")
+			assert.Equal(t, []byte(fmt.Sprintf("frame %d", i)), got, "should deliver frames in order")
+		}
+	})
+
+	t.Run("check rejected frame", func(t *testing.T) {
+		go func() {
+			_, _ = peer.Write([]byte("frame is too large for the connection"))
+			_, _ = peer.Write([]byte("after"))
+		}()
+
+		_, err := conn.ReadFrame()
+		assert.Equal(t, ErrFrameTooLarge, err, "should be ErrFrameTooLarge error")
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should continue prefetching after rejected frame")
+		assert.Equal(t, []byte("after"), got, "should be equal messages")
+	})
+
+	t.Run("check read within deadline", func(t *testing.T) {
+		_, err := conn.ReadFrameWithin(time.Now().Add(50 * time.Millisecond))
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded, "should be deadline error")
+
+		go func() { _, _ = peer.Write([]byte("next")) }()
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should continue prefetching after deadline")
+		assert.Equal(t, []byte("next"), got, "should be equal messages")
+	})
+
+	t.Run("check other reads", func(t *testing.T) {
+		_, err := conn.Read(make([]byte, 16))
+		assert.Equal(t, ErrPrefetchEnabled, err, "should be ErrPrefetchEnabled error to Read")
+
+		_, err = conn.ReadRPC()
+		assert.Equal(t, ErrPrefetchEnabled, err, "should be ErrPrefetchEnabled error to ReadRPC")
+
+		_, _, err = conn.MessageReader()
+		assert.Equal(t, ErrPrefetchEnabled, err, "should be ErrPrefetchEnabled error to MessageReader")
+
+		_, err = conn.NextEvent()
+		assert.Equal(t, ErrPrefetchEnabled, err, "should be ErrPrefetchEnabled error to NextEvent")
+
+		_, _, err = conn.ReadFramePooled()
+		assert.Equal(t, ErrPrefetchEnabled, err, "should be ErrPrefetchEnabled error to ReadFramePooled")
+
+		_, err = conn.PeekType()
+		assert.Equal(t, ErrPrefetchEnabled, err, "should be ErrPrefetchEnabled error to PeekType")
+	})
+
+	t.Run("check close", func(t *testing.T) {
+		go func() { _, _ = peer.ReadFrame() }()
+
+		assert.Equal(t, nil, conn.Close(), "should not be error to close")
+		_, err := conn.ReadFrame()
+		assert.Equal(t, ErrConnClosed, err, "should stop prefetching on close")
+	})
+}
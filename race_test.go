@@ -0,0 +1,7 @@
+//go:build race
+
+package gotcpws
+
+// raceEnabled reports whether tests run with the race detector,
+// which makes sync.Pool drop items, so pooled reads allocate
+const raceEnabled = true
@@ -0,0 +1,52 @@
+package gotcpws
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// defaultWriteRetryBackoff is delay before the first retry of a write,
+	// it is doubled before each next retry up to maxWriteRetryBackoff
+	defaultWriteRetryBackoff = 5 * time.Millisecond
+	maxWriteRetryBackoff     = time.Second
+)
+
+// retryWriter writes flushed frames to w and retries a write failed
+// with temporary error WriteRetries times of the connection. The write
+// is retried only if no bytes of it reached w, so the stream of frames
+// is not corrupted by a partially written frame
+type retryWriter struct {
+	w    io.Writer
+	conn *Conn
+}
+
+func (rw *retryWriter) Write(p []byte) (int, error) {
+	n, err := rw.w.Write(p)
+
+	delay := defaultWriteRetryBackoff
+	for retries := rw.conn.WriteRetries; err != nil && retries > 0; retries-- {
+		if n > 0 || !isTemporaryWrite(err) {
+			break
+		}
+
+		rw.conn.clock.Sleep(delay)
+		delay = min(2*delay, maxWriteRetryBackoff)
+
+		n, err = rw.w.Write(p)
+	}
+
+	return n, err
+}
+
+// isTemporaryWrite reports whether failed write may succeed on retry,
+// exceeded deadline is not temporary as the caller bounded the write
+func isTemporaryWrite(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return false
+	}
+
+	return isTemporary(err)
+}
@@ -0,0 +1,98 @@
+package gotcpws
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyConn is connection failing first writes with err,
+// written is amount of bytes of a failed write reached the buffer
+type flakyConn struct {
+	*bytes.Buffer
+	failures int
+	written  int
+	err      error
+	writes   int
+}
+
+func (c *flakyConn) Write(p []byte) (int, error) {
+	c.writes++
+	if c.failures > 0 {
+		c.failures--
+		n, _ := c.Buffer.Write(p[:c.written])
+		return n, c.err
+	}
+
+	return c.Buffer.Write(p)
+}
+
+func (c *flakyConn) Close() error { return nil }
+
+func TestConnWriteRetries(t *testing.T) {
+	newConn := func(rwc *flakyConn) (*Conn, *fakeClock) {
+		clk := newFakeClock()
+		conn := NewFrameConnection(rwc, nil, nil, 0, false)
+		conn.clock = clk
+		conn.WriteRetries = 3
+		return conn, clk
+	}
+
+	t.Run("check retry of failed flush", func(t *testing.T) {
+		rwc := &flakyConn{Buffer: bytes.NewBuffer(nil), failures: 2, err: temporaryError{}}
+		conn, clk := newConn(rwc)
+		start := clk.Now()
+
+		_, err := conn.Write([]byte("retried"))
+		assert.Equal(t, nil, err, "should not be error to write after retries")
+		assert.Equal(t, 3, rwc.writes, "should retry failed writes")
+		assert.Equal(t, defaultWriteRetryBackoff+2*defaultWriteRetryBackoff, clk.Now().Sub(start), "should double backoff before each retry")
+
+		frame, err := (&tcpFrameReaderFactory{Reader: bufio.NewReader(rwc)}).NewFrameReader()
+		assert.Equal(t, nil, err, "should not be error to read frame")
+		got, _ := io.ReadAll(frame)
+		assert.Equal(t, []byte("retried"), got, "should be equal messages")
+	})
+
+	t.Run("check retries are bounded", func(t *testing.T) {
+		rwc := &flakyConn{Buffer: bytes.NewBuffer(nil), failures: 10, err: temporaryError{}}
+		conn, _ := newConn(rwc)
+
+		_, err := conn.Write([]byte("lost"))
+		assert.Equal(t, temporaryError{}, err, "should be error after all retries")
+		assert.Equal(t, 4, rwc.writes, "should write once and retry WriteRetries times")
+	})
+
+	t.Run("check partial write is not retried", func(t *testing.T) {
+		rwc := &flakyConn{Buffer: bytes.NewBuffer(nil), failures: 1, written: 2, err: temporaryError{}}
+		conn, _ := newConn(rwc)
+
+		_, err := conn.Write([]byte("partial"))
+		assert.Equal(t, temporaryError{}, err, "should be error of partial write")
+		assert.Equal(t, 1, rwc.writes, "should not retry partial write")
+	})
+
+	t.Run("check not temporary error is not retried", func(t *testing.T) {
+		errBroken := errors.New("broken pipe")
+		rwc := &flakyConn{Buffer: bytes.NewBuffer(nil), failures: 1, err: errBroken}
+		conn, _ := newConn(rwc)
+
+		_, err := conn.Write([]byte("broken"))
+		assert.Equal(t, errBroken, err, "should be error of the write")
+		assert.Equal(t, 1, rwc.writes, "should not retry not temporary error")
+	})
+
+	t.Run("check retries are disabled by default", func(t *testing.T) {
+		rwc := &flakyConn{Buffer: bytes.NewBuffer(nil), failures: 1, err: temporaryError{}}
+		conn, _ := newConn(rwc)
+		conn.WriteRetries = 0
+
+		_, err := conn.Write([]byte("once"))
+		assert.Equal(t, temporaryError{}, err, "should be error without retries")
+		assert.Equal(t, 1, rwc.writes, "should not retry write")
+	})
+}
@@ -0,0 +1,124 @@
+package gotcpws
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+)
+
+// rpcHeaderLength is len of id, type and len of method of RPC envelope
+const rpcHeaderLength = 8 + 1 + 2
+
+// ErrBadRPCMessage returns when a read frame is not RPC envelope
+var ErrBadRPCMessage = errors.New("error bad rpc message")
+
+// RPCType is type of RPC message
+type RPCType byte
+
+const (
+	RPCRequest  RPCType = 1
+	RPCResponse RPCType = 2
+)
+
+// RPCMessage is RPC envelope read by ReadRPC. ID correlates a response
+// with its request, responses have no method
+type RPCMessage struct {
+	ID      uint64
+	Type    RPCType
+	Method  string
+	Payload json.RawMessage
+}
+
+// WriteRPC writes RPC request with params marshaled as JSON
+// as a binary frame
+func (conn *Conn) WriteRPC(id uint64, method string, params any) error {
+	return conn.writeRPC(id, RPCRequest, method, params)
+}
+
+// WriteRPCResponse writes RPC response to the request with id and result
+// marshaled as JSON as a binary frame
+func (conn *Conn) WriteRPCResponse(id uint64, result any) error {
+	return conn.writeRPC(id, RPCResponse, "", result)
+}
+
+func (conn *Conn) writeRPC(id uint64, typ RPCType, method string, v any) error {
+	if len(method) > math.MaxUint16 {
+		return ErrBadRPCMessage
+	}
+
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	// envelope: id, type, len of method, method, JSON payload
+	header := make([]byte, 0, rpcHeaderLength+len(method))
+	header = binary.BigEndian.AppendUint64(header, id)
+	header = append(header, byte(typ))
+	header = binary.BigEndian.AppendUint16(header, uint16(len(method)))
+	header = append(header, method...)
+
+	conn.wio.Lock()
+	defer conn.wio.Unlock()
+
+	_, err = conn.writeFrame(BinaryFrame, header, payload)
+	return err
+}
+
+// ReadRPC reads RPC message written by WriteRPC or WriteRPCResponse,
+// if frame is too large return ErrFrameTooLarge, if frame is not RPC
+// envelope return ErrBadRPCMessage, after EnablePrefetch return
+// ErrPrefetchEnabled
+func (conn *Conn) ReadRPC() (RPCMessage, error) {
+	if err := conn.checkPrefetch(); err != nil {
+		return RPCMessage{}, err
+	}
+
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	frame, err := conn.nextFrame()
+	if err != nil {
+		return RPCMessage{}, err
+	}
+
+	if frame.PayloadType() != BinaryFrame {
+		_, _ = io.Copy(io.Discard, frame)
+		return RPCMessage{}, ErrBadRPCMessage
+	}
+
+	data, err := io.ReadAll(frame)
+	if err != nil {
+		return RPCMessage{}, err
+	}
+
+	return parseRPCMessage(data)
+}
+
+// parseRPCMessage parses RPC envelope
+func parseRPCMessage(data []byte) (RPCMessage, error) {
+	if len(data) < rpcHeaderLength {
+		return RPCMessage{}, ErrBadRPCMessage
+	}
+
+	msg := RPCMessage{
+		ID:   binary.BigEndian.Uint64(data),
+		Type: RPCType(data[8]),
+	}
+
+	methodLen := int(binary.BigEndian.Uint16(data[9:]))
+	data = data[rpcHeaderLength:]
+	if len(data) < methodLen || (msg.Type != RPCRequest && msg.Type != RPCResponse) {
+		return RPCMessage{}, ErrBadRPCMessage
+	}
+
+	msg.Method = string(data[:methodLen])
+	msg.Payload = data[methodLen:]
+	if !json.Valid(msg.Payload) {
+		return RPCMessage{}, ErrBadRPCMessage
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,87 @@
+package gotcpws
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testSumParams struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+func TestConnRPC(t *testing.T) {
+	t.Run("check request and response", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer server.Close()
+		defer client.Close()
+
+		conn := NewFrameConnection(server, nil, nil, 0, false)
+		peer := NewFrameConnection(client, nil, nil, 0, true)
+
+		// peer serves sum requests
+		go func() {
+			req, err := peer.ReadRPC()
+			if err != nil {
+				return
+			}
+
+			var params testSumParams
+			if err := json.Unmarshal(req.Payload, &params); err != nil {
+				return
+			}
+			_ = peer.WriteRPCResponse(req.ID, params.A+params.B)
+		}()
+
+		go func() { _ = conn.WriteRPC(42, "sum", testSumParams{A: 2, B: 3}) }()
+
+		resp, err := conn.ReadRPC()
+		assert.Equal(t, nil, err, "should not be error to read response")
+		assert.Equal(t, uint64(42), resp.ID, "should correlate response with request")
+		assert.Equal(t, RPCResponse, resp.Type, "should be response")
+		assert.Equal(t, "", resp.Method, "should be empty method of response")
+		assert.Equal(t, json.RawMessage("5"), resp.Payload, "should be result of request")
+	})
+
+	t.Run("check request", func(t *testing.T) {
+		conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, false)
+		assert.Equal(t, nil, conn.WriteRPC(7, "echo", "hello"), "should not be error to write")
+
+		req, err := conn.ReadRPC()
+		assert.Equal(t, nil, err, "should not be error to read request")
+		assert.Equal(t, RPCMessage{
+			ID:      7,
+			Type:    RPCRequest,
+			Method:  "echo",
+			Payload: json.RawMessage(`"hello"`),
+		}, req, "should be equal requests")
+	})
+
+	t.Run("check oversized message", func(t *testing.T) {
+		conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, false)
+		conn.MaxPayloadBytes = 64
+		assert.Equal(t, nil, conn.WriteRPC(1, "echo", strings.Repeat("a", 64)), "should not be error to write")
+		assert.Equal(t, nil, conn.WriteRPC(2, "echo", "small"), "should not be error to write")
+
+		_, err := conn.ReadRPC()
+		assert.Equal(t, ErrFrameTooLarge, err, "should be ErrFrameTooLarge error")
+
+		req, err := conn.ReadRPC()
+		assert.Equal(t, nil, err, "should not be error to read next request")
+		assert.Equal(t, uint64(2), req.ID, "should be aligned on the next request")
+	})
+
+	t.Run("check not rpc frame", func(t *testing.T) {
+		conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, false)
+		_, err := conn.Write([]byte("text"))
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		_, err = conn.ReadRPC()
+		assert.Equal(t, ErrBadRPCMessage, err, "should be ErrBadRPCMessage error")
+	})
+}
@@ -0,0 +1,139 @@
+package gotcpws
+
+import "io"
+
+// HandleText registers handler of text messages dispatched by ServeLoop
+func (conn *Conn) HandleText(handler func(payload []byte) error) {
+	conn.handle(TextFrame, handler)
+}
+
+// HandleBinary registers handler of binary messages dispatched by ServeLoop
+func (conn *Conn) HandleBinary(handler func(payload []byte) error) {
+	conn.handle(BinaryFrame, handler)
+}
+
+// HandlePing registers handler of ping frames dispatched by ServeLoop,
+// it replaces answering of ping frames with pong frames
+func (conn *Conn) HandlePing(handler func(payload []byte) error) {
+	conn.handle(PingFrame, handler)
+}
+
+// HandlePong registers handler of pong frames dispatched by ServeLoop
+func (conn *Conn) HandlePong(handler func(payload []byte) error) {
+	conn.handle(PongFrame, handler)
+}
+
+// HandleDefault registers handler of messages and control frames without
+// registered handler, if it is not set they are skipped and ping frames
+// are answered with pong frames
+func (conn *Conn) HandleDefault(handler func(payloadType byte, payload []byte) error) {
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	conn.defaultHandler = handler
+}
+
+// handle registers handler of the payload type, nil handler unregisters it
+func (conn *Conn) handle(payloadType byte, handler func(payload []byte) error) {
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	if handler == nil {
+		delete(conn.handlers, payloadType)
+		return
+	}
+
+	if conn.handlers == nil {
+		conn.handlers = make(map[byte]func(payload []byte) error)
+	}
+	conn.handlers[payloadType] = handler
+}
+
+// ServeLoop reads messages of the connection and dispatches them to
+// registered handlers until close frame of the peer, then it returns nil.
+// Fragments of a message are dispatched as one message, ping and pong
+// frames are dispatched as soon as they are read. Error of a handler
+// stops the loop and is returned. Handlers must not read the connection
+func (conn *Conn) ServeLoop() error {
+	for {
+		payloadType, payload, err := conn.readMessage()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := conn.dispatch(payloadType, payload); err != nil {
+			return err
+		}
+	}
+}
+
+// readMessage reads payloads of all fragments of the next message
+// dispatching interleaved ping and pong frames
+func (conn *Conn) readMessage() (byte, []byte, error) {
+	if err := conn.checkPrefetch(); err != nil {
+		return 0, nil, err
+	}
+
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	var (
+		payloadType byte
+		payload     []byte
+	)
+	for {
+		frame, err := conn.nextMessageFrame(conn.dispatchControl)
+		if err != nil {
+			return 0, nil, err
+		}
+		payloadType = frame.PayloadType()
+
+		data, err := io.ReadAll(frame)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		payload = append(payload, data...)
+		if len(payload) > conn.maxPayloadBytes(payloadType) {
+			return 0, nil, ErrFrameTooLarge
+		}
+
+		if frame.Fin() {
+			return payloadType, payload, nil
+		}
+	}
+}
+
+// dispatchControl dispatches ping or pong frame, rio is held by the caller
+func (conn *Conn) dispatchControl(payloadType byte, payload []byte) error {
+	if handler, ok := conn.handlers[payloadType]; ok {
+		return handler(payload)
+	}
+
+	if conn.defaultHandler != nil {
+		return conn.defaultHandler(payloadType, payload)
+	}
+
+	return conn.replyPing(payloadType, payload)
+}
+
+// dispatch dispatches message to its handler
+func (conn *Conn) dispatch(payloadType byte, payload []byte) error {
+	conn.rio.Lock()
+	handler, ok := conn.handlers[payloadType]
+	defaultHandler := conn.defaultHandler
+	conn.rio.Unlock()
+
+	switch {
+	case ok:
+		return handler(payload)
+	case defaultHandler != nil:
+		return defaultHandler(payloadType, payload)
+	}
+
+	return nil
+}
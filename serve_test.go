@@ -0,0 +1,75 @@
+package gotcpws
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnServeLoop(t *testing.T) {
+	t.Run("check dispatch", func(t *testing.T) {
+		conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, false)
+
+		_, err := conn.Write([]byte("text"))
+		assert.Equal(t, nil, err, "should not be error to write")
+		_, err = conn.WriteFragmented(BinaryFrame, []byte("binary data"), 4)
+		assert.Equal(t, nil, err, "should not be error to write")
+		_, err = conn.WriteControl(PingFrame, []byte("ping"))
+		assert.Equal(t, nil, err, "should not be error to write")
+		_, err = conn.WriteControl(PongFrame, []byte("pong"))
+		assert.Equal(t, nil, err, "should not be error to write")
+		assert.Equal(t, nil, conn.writeClose(closeStatusNormal, ""), "should not be error to write close")
+
+		var got []string
+		conn.HandleText(func(payload []byte) error {
+			got = append(got, "text:"+string(payload))
+			return nil
+		})
+		conn.HandleBinary(func(payload []byte) error {
+			got = append(got, "binary:"+string(payload))
+			return nil
+		})
+		conn.HandlePing(func(payload []byte) error {
+			got = append(got, "ping:"+string(payload))
+			return nil
+		})
+		conn.HandleDefault(func(payloadType byte, payload []byte) error {
+			got = append(got, "default:"+string(payload))
+			return nil
+		})
+
+		assert.Equal(t, nil, conn.ServeLoop(), "should not be error to serve until close")
+		assert.Equal(t, []string{
+			"text:text",
+			"binary:binary data",
+			"ping:ping",
+			"default:pong",
+		}, got, "should dispatch messages to registered handlers")
+	})
+
+	t.Run("check handler error", func(t *testing.T) {
+		conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, false)
+
+		_, err := conn.WriteFrame(BinaryFrame, true, []byte("first"))
+		assert.Equal(t, nil, err, "should not be error to write")
+		_, err = conn.WriteFrame(TextFrame, true, []byte("skipped"))
+		assert.Equal(t, nil, err, "should not be error to write")
+		_, err = conn.WriteFrame(BinaryFrame, true, []byte("second"))
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		errHandler := errors.New("handler error")
+		calls := 0
+		conn.HandleBinary(func(payload []byte) error {
+			calls++
+			if calls == 2 {
+				return errHandler
+			}
+			return nil
+		})
+
+		assert.Equal(t, errHandler, conn.ServeLoop(), "should stop on handler error")
+		assert.Equal(t, 2, calls, "should skip messages without handler")
+	})
+}
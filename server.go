@@ -0,0 +1,240 @@
+package gotcpws
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultAcceptBackoff    = 5 * time.Millisecond
+	defaultMaxAcceptBackoff = time.Second
+)
+
+// ErrServerClosed returns by Server.Serve after Shutdown
+var ErrServerClosed = errors.New("error server closed")
+
+// Server accepts framing connections and serves them by handler,
+// zero value is ready to use
+type Server struct {
+	// AcceptBackoff is delay before accepting again after temporary error
+	// of the listener, it is doubled on each next error in a row up to
+	// MaxAcceptBackoff. Defaults are 5ms and 1s
+	AcceptBackoff    time.Duration
+	MaxAcceptBackoff time.Duration
+
+	// MaxConns is maximum number of connections served at the same time,
+	// new connections over it are closed with try again later status.
+	// If 0 there is no limit
+	MaxConns int
+
+	clock clock
+
+	// active is number of connections served now
+	active atomic.Int64
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*Conn]struct{}
+	shutdown  bool
+
+	// wg waits for handlers of connections
+	wg sync.WaitGroup
+}
+
+// Serve accepts connections on ln and calls handler for each of them
+// in its own goroutine, the connection is closed after handler returns.
+// On temporary accept errors Serve sleeps with backoff and accepts again,
+// other errors are returned.
+// Serve always returns non-nil error, after Shutdown it is ErrServerClosed
+func (srv *Server) Serve(ln net.Listener, handler func(conn *Conn)) error {
+	if !srv.trackListener(ln) {
+		return ErrServerClosed
+	}
+	defer srv.untrackListener(ln)
+
+	var delay time.Duration
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if srv.shuttingDown() {
+				return ErrServerClosed
+			}
+
+			if !isTemporary(err) {
+				return err
+			}
+
+			delay = srv.nextAcceptBackoff(delay)
+			srv.getClock().Sleep(delay)
+			continue
+		}
+		delay = 0
+
+		conn := NewConn(c)
+		if !srv.acquireConn() {
+			_ = conn.CloseWithStatus(closeStatusTryAgainLater, "too many connections")
+			continue
+		}
+
+		if !srv.trackConn(conn) {
+			srv.active.Add(-1)
+			_ = conn.CloseWithStatus(closeStatusGoingAway, "server shutdown")
+			return ErrServerClosed
+		}
+
+		go func() {
+			defer srv.wg.Done()
+			defer srv.active.Add(-1)
+			handler(conn)
+
+			// connection is closed by Shutdown if it is not tracked
+			if srv.untrackConn(conn) {
+				_ = conn.Close()
+			}
+		}()
+	}
+}
+
+// Shutdown stops accepting of connections, sends close frames to all
+// connections and waits for handlers to finish or ctx to expire
+func (srv *Server) Shutdown(ctx context.Context) error {
+	srv.mu.Lock()
+	srv.shutdown = true
+
+	var err error
+	for ln := range srv.listeners {
+		err = errors.Join(err, ln.Close())
+	}
+
+	conns := srv.conns
+	srv.conns = nil
+	srv.mu.Unlock()
+
+	for conn := range conns {
+		_ = conn.CloseWithStatus(closeStatusGoingAway, "server shutdown")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		srv.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// nextAcceptBackoff returns delay after accept error following delay
+func (srv *Server) nextAcceptBackoff(delay time.Duration) time.Duration {
+	maxDelay := srv.MaxAcceptBackoff
+	if maxDelay <= 0 {
+		maxDelay = defaultMaxAcceptBackoff
+	}
+
+	if delay == 0 {
+		delay = srv.AcceptBackoff
+		if delay <= 0 {
+			delay = defaultAcceptBackoff
+		}
+	} else {
+		delay *= 2
+	}
+
+	return min(delay, maxDelay)
+}
+
+func (srv *Server) getClock() clock {
+	if srv.clock == nil {
+		return realClock{}
+	}
+
+	return srv.clock
+}
+
+// isTemporary reports whether err of accept is temporary,
+// e.g. the process is out of file descriptors
+func isTemporary(err error) bool {
+	if errors.Is(err, net.ErrClosed) {
+		return false
+	}
+
+	var ne interface{ Temporary() bool }
+	return errors.As(err, &ne) && ne.Temporary()
+}
+
+func (srv *Server) shuttingDown() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	return srv.shutdown
+}
+
+func (srv *Server) trackListener(ln net.Listener) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.shutdown {
+		return false
+	}
+
+	if srv.listeners == nil {
+		srv.listeners = make(map[net.Listener]struct{})
+	}
+	srv.listeners[ln] = struct{}{}
+	return true
+}
+
+func (srv *Server) untrackListener(ln net.Listener) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	delete(srv.listeners, ln)
+}
+
+// acquireConn counts new active connection and reports whether
+// it is within MaxConns
+func (srv *Server) acquireConn() bool {
+	n := srv.active.Add(1)
+	if srv.MaxConns > 0 && n > int64(srv.MaxConns) {
+		srv.active.Add(-1)
+		return false
+	}
+
+	return true
+}
+
+// trackConn adds conn to tracked connections and handlers to wait for
+func (srv *Server) trackConn(conn *Conn) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.shutdown {
+		return false
+	}
+
+	if srv.conns == nil {
+		srv.conns = make(map[*Conn]struct{})
+	}
+	srv.conns[conn] = struct{}{}
+	srv.wg.Add(1)
+	return true
+}
+
+// untrackConn removes conn from tracked connections and reports
+// whether it was tracked
+func (srv *Server) untrackConn(conn *Conn) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	_, ok := srv.conns[conn]
+	delete(srv.conns, conn)
+	return ok
+}
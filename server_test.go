@@ -0,0 +1,223 @@
+package gotcpws
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(t *testing.T, handler func(conn *Conn)) (*Server, net.Listener, chan error) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &Server{}
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(ln, handler)
+	}()
+
+	return srv, ln, served
+}
+
+func TestServerShutdown(t *testing.T) {
+	srv, ln, served := newTestServer(t, func(conn *Conn) {
+		for {
+			msg, err := conn.ReadFrame()
+			if err != nil {
+				return
+			}
+
+			_, _ = conn.Write(msg)
+		}
+	})
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewFrameConnection(c, nil, nil, 0, false)
+	defer client.Close()
+
+	t.Run("check serve connection", func(t *testing.T) {
+		_, err := client.Write([]byte("echo"))
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		got, err := client.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, []byte("echo"), got, "should be equal messages")
+	})
+
+	t.Run("check shutdown", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		assert.Equal(t, nil, srv.Shutdown(ctx), "should not be error to shutdown")
+		assert.Equal(t, ErrServerClosed, <-served, "should be ErrServerClosed error")
+
+		_, err := client.ReadFrame()
+		assert.Equal(t, io.EOF, err, "should receive close frame")
+
+		assert.Equal(t, ErrServerClosed, srv.Serve(ln, nil), "should not serve after shutdown")
+	})
+}
+
+func TestServerShutdownContext(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	srv, ln, served := newTestServer(t, func(conn *Conn) {
+		close(started)
+		<-release
+	})
+	defer close(release)
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err = srv.Shutdown(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err, "should wait for handler until context expires")
+	assert.Equal(t, ErrServerClosed, <-served, "should be ErrServerClosed error")
+}
+
+// temporaryError is temporary net.Error returned by errListener
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "temporary error" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// errListener is listener returning temporary errors on accept
+// and then err
+type errListener struct {
+	net.Listener
+	temporary int
+	err       error
+	accepts   int
+}
+
+func (ln *errListener) Accept() (net.Conn, error) {
+	ln.accepts++
+	if ln.accepts <= ln.temporary {
+		return nil, temporaryError{}
+	}
+
+	return nil, ln.err
+}
+
+func (ln *errListener) Close() error { return nil }
+
+func TestServerAcceptBackoff(t *testing.T) {
+	t.Run("check default backoff", func(t *testing.T) {
+		clock := newFakeClock()
+		srv := &Server{clock: clock}
+		ln := &errListener{temporary: 10, err: net.ErrClosed}
+
+		err := srv.Serve(ln, nil)
+		assert.ErrorIs(t, err, net.ErrClosed, "should exit on closed listener")
+		assert.Equal(t, 11, ln.accepts, "should accept again after temporary errors")
+
+		// 5ms doubled up to 1s
+		elapsed := clock.Now().Sub(time.Unix(0, 0))
+		assert.Equal(t, 3275*time.Millisecond, elapsed, "should sleep with exponential backoff")
+	})
+
+	t.Run("check custom backoff", func(t *testing.T) {
+		clock := newFakeClock()
+		srv := &Server{
+			AcceptBackoff:    time.Millisecond,
+			MaxAcceptBackoff: 4 * time.Millisecond,
+			clock:            clock,
+		}
+		ln := &errListener{temporary: 5, err: net.ErrClosed}
+
+		err := srv.Serve(ln, nil)
+		assert.ErrorIs(t, err, net.ErrClosed, "should exit on closed listener")
+
+		elapsed := clock.Now().Sub(time.Unix(0, 0))
+		assert.Equal(t, 15*time.Millisecond, elapsed, "should cap backoff")
+	})
+
+	t.Run("check fatal error", func(t *testing.T) {
+		clock := newFakeClock()
+		srv := &Server{clock: clock}
+		fatal := errors.New("fatal")
+		ln := &errListener{err: fatal}
+
+		err := srv.Serve(ln, nil)
+		assert.Equal(t, fatal, err, "should return not temporary error")
+		assert.Equal(t, 1, ln.accepts, "should not accept again")
+	})
+}
+
+func TestServerMaxConns(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &Server{MaxConns: 1}
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(ln, func(conn *Conn) {
+			for {
+				msg, err := conn.ReadFrame()
+				if err != nil {
+					return
+				}
+
+				_, _ = conn.Write(msg)
+			}
+		})
+	}()
+	defer func() {
+		_ = srv.Shutdown(context.Background())
+		<-served
+	}()
+
+	dial := func() *Conn {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewFrameConnection(c, nil, nil, 0, false)
+	}
+
+	first := dial()
+	defer first.Close()
+
+	t.Run("check serve connection within limit", func(t *testing.T) {
+		_, err := first.Write([]byte("echo"))
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		got, err := first.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, []byte("echo"), got, "should be equal messages")
+	})
+
+	t.Run("check reject connection over limit", func(t *testing.T) {
+		second := dial()
+		defer second.Close()
+
+		_, err := second.ReadFrame()
+		assert.Equal(t, io.EOF, err, "should receive close frame")
+
+		status, _, ok := second.CloseStatus()
+		assert.Equal(t, true, ok, "should receive close status")
+		assert.Equal(t, closeStatusTryAgainLater, status, "should be try again later status")
+	})
+}
@@ -0,0 +1,52 @@
+package gotcpws
+
+import "sync/atomic"
+
+// Stats is snapshot of counters of the connection
+type Stats struct {
+	// FramesRead and BytesRead are number of read frames
+	// and bytes of their payloads
+	FramesRead int64
+	BytesRead  int64
+
+	// FramesWritten and BytesWritten are number of written frames
+	// and bytes of their payloads
+	FramesWritten int64
+	BytesWritten  int64
+
+	// Oversized is number of frames rejected with ErrFrameTooLarge
+	Oversized int64
+
+	// ProtocolErrors is number of read frames violating the protocol
+	ProtocolErrors int64
+
+	// Buffered is number of bytes written but not flushed yet
+	Buffered int
+}
+
+// connStats is counters of the connection updated atomically
+type connStats struct {
+	framesRead     atomic.Int64
+	bytesRead      atomic.Int64
+	framesWritten  atomic.Int64
+	bytesWritten   atomic.Int64
+	oversized      atomic.Int64
+	protocolErrors atomic.Int64
+}
+
+// Stats returns snapshot of counters of the connection
+func (conn *Conn) Stats() Stats {
+	conn.wio.Lock()
+	buffered := conn.buf.Writer.Buffered()
+	conn.wio.Unlock()
+
+	return Stats{
+		FramesRead:     conn.stats.framesRead.Load(),
+		BytesRead:      conn.stats.bytesRead.Load(),
+		FramesWritten:  conn.stats.framesWritten.Load(),
+		BytesWritten:   conn.stats.bytesWritten.Load(),
+		Oversized:      conn.stats.oversized.Load(),
+		ProtocolErrors: conn.stats.protocolErrors.Load(),
+		Buffered:       buffered,
+	}
+}
@@ -0,0 +1,50 @@
+package gotcpws
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnStats(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+	conn.DisableAutoFlush = true
+
+	_, err := conn.Write([]byte("first"))
+	assert.Equal(t, nil, err, "should not be error to write")
+	_, err = conn.Write([]byte("second"))
+	assert.Equal(t, nil, err, "should not be error to write")
+
+	t.Run("check written frames", func(t *testing.T) {
+		stats := conn.Stats()
+		assert.Equal(t, int64(2), stats.FramesWritten, "should count written frames")
+		assert.Equal(t, int64(len("firstsecond")), stats.BytesWritten, "should count written payload bytes")
+		assert.Equal(t, 2*(len(preambule)+2)+len("firstsecond"), stats.Buffered, "should count buffered bytes")
+	})
+
+	assert.Equal(t, nil, conn.Flush(), "should not be error to flush")
+	conn.MaxPayloadBytes = 5
+	bw := bufio.NewWriter(connBuffer)
+	writeTestFrame(t, bw, 5, true, []byte("bad"))
+
+	t.Run("check read frames", func(t *testing.T) {
+		_, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		_, err = conn.ReadFrame()
+		assert.Equal(t, ErrFrameTooLarge, err, "should be ErrFrameTooLarge error")
+		_, err = conn.ReadFrame()
+		assert.Equal(t, ErrBadOpCode, err, "should be ErrBadOpCode error")
+
+		stats := conn.Stats()
+		assert.Equal(t, int64(3), stats.FramesRead, "should count read frames")
+		assert.Equal(t, int64(len("firstsecondbad")), stats.BytesRead, "should count read payload bytes")
+		assert.Equal(t, int64(1), stats.Oversized, "should count oversized frames")
+		assert.Equal(t, int64(1), stats.ProtocolErrors, "should count protocol errors")
+		assert.Equal(t, 0, stats.Buffered, "should not be buffered bytes after flush")
+	})
+}
@@ -2,11 +2,19 @@ package gotcpws
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"log/slog"
 	"net"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
 const (
@@ -21,6 +29,9 @@ const (
 	closeStatusPolicyViolation   = 1008
 	closeStatusTooBigData        = 1009
 	closeStatusExtensionMismatch = 1010
+	closeStatusInternalError     = 1011
+	closeStatusServiceRestart    = 1012
+	closeStatusTryAgainLater     = 1013
 )
 
 // frameReader is interface to read ws like frame
@@ -36,6 +47,16 @@ type frameReader interface {
 
 	// Len returns total len of the frame = header len + payload len
 	Len() int
+
+	// Rsv returns RSV1, RSV2, RSV3 bits of the frame
+	Rsv() [3]bool
+
+	// Fin returns true if the frame is the final fragment of a message
+	Fin() bool
+
+	// Consumed returns bytes of the frame actually read so far = header len +
+	// read payload len, it is less than Len if the payload is truncated
+	Consumed() int64
 }
 
 // frameReaderFactory is interface to create new frame reader
@@ -58,6 +79,13 @@ type frameHandler interface {
 	WriteClose(writerFactory frameWriterFactory, status int) (err error)
 }
 
+// frameHandlerResetter is interface of a frame handler which keeps
+// state between frames and can drop it
+type frameHandlerResetter interface {
+	// drop state of the handler
+	Reset()
+}
+
 // frameWriterFactory is interface to create new frame writer
 type frameWriterFactory interface {
 	NewFrameWriter(payloadType byte) (w frameWriter, err error)
@@ -72,35 +100,248 @@ type Conn struct {
 	frameReader
 	frameReaderFactory
 
+	// peeked is frame with read header but not handled yet by PeekType
+	peeked frameReader
+
+	// shortFrame is frame rejected by ReadFrameInto with short buffer,
+	// it is read by the next ReadFrameInto if it is not read by other reads
+	shortFrame frameReader
+
+	// controlHook is called with ping and pong frames read by message
+	// readers instead of skipping them, guarded by rio
+	controlHook func(payloadType byte, payload []byte) error
+
+	// paused blocks reads until ResumeReads, guarded by pauseMu,
+	// resumed is signaled on resume and on close
+	pauseMu sync.Mutex
+	resumed *sync.Cond
+	paused  bool
+
+	// prefetch, if set, reads frames ahead for ReadFrame
+	prefetch atomic.Pointer[prefetcher]
+
+	// frameSizeHint is default size of fragments, guarded by wio
+	frameSizeHint int
+
+	// readSeq is expected sequence number of the next read frame,
+	// guarded by rio, writeSeq is sequence number of the next written
+	// frame, guarded by wio
+	readSeq  uint32
+	writeSeq uint32
+
+	// deadlines set by the caller, they are restored after deadlines
+	// set by methods of the connection, guarded by deadlineMu
+	deadlineMu    sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	// deadlineHook, if set, is called instead of setting deadlines of rwc
+	deadlineHook atomic.Pointer[func(t time.Time) error]
+
+	// frameOverhead is len of preambule and header of the last written
+	// frame, guarded by wio
+	frameOverhead int
+
+	// events are control frames queued for NextEvent, guarded by rio
+	events []Event
+
+	// scratch is frame reader reused by ReadFrameUnmaskInto, guarded by rio
+	scratch *scratchFrame
+
+	// handlers of ping and pong frames read by the default frame handler,
+	// guarded by rio
+	pingHandler func(payload []byte) error
+	pongHandler func(payload []byte) error
+
+	// handlers of messages dispatched by ServeLoop, guarded by rio
+	handlers       map[byte]func(payload []byte) error
+	defaultHandler func(payloadType byte, payload []byte) error
+
+	// jsonBuf keeps read but not decoded data of ReadJSONStream
+	jsonBuf bytes.Buffer
+
 	wio sync.Mutex
 	frameWriterFactory
 
+	// pendingControl is control frames of WriteControl waiting for wio,
+	// guarded by controlMu
+	controlMu      sync.Mutex
+	pendingControl []*controlWrite
+
+	// pendingPong is pong frame of writePong waiting for wio, pong frames
+	// answering later ping frames replace its payload, guarded by controlMu
+	pendingPong *controlWrite
+
+	clock        clock
+	readLimiter  *rateLimiter
+	writeLimiter *rateLimiter
+	credit       *writeCredit
+
+	// flushTimer flushes buffered frames if FlushInterval is set
+	flushTimer timer
+
+	// writeWatchdog and onWriteStuck are set by SetWriteWatchdog,
+	// guarded by wio
+	writeWatchdog time.Duration
+	onWriteStuck  func()
+
+	// status and reason of received close frame, guarded by rio
+	closeReceived bool
+	closeStatus   int
+	closeReason   string
+
+	// alloc and free are allocator of payloads set by SetAllocator,
+	// guarded by rio
+	alloc func(n int) []byte
+	free  func(b []byte)
+
+	// controlFrames receives read control frames after ControlFrames is called
+	controlFrames      chan ControlFrame
+	routeControlFrames atomic.Bool
+
+	// onFragment is called on each read frame of a fragmented message,
+	// guarded by rio
+	onFragment func(payloadType byte, fragmentBytes int, fin bool)
+
+	// masking key of the last read frame, nil if it was not masked,
+	// guarded by rio
+	lastMaskingKey []byte
+
+	maskedFramesRead    atomic.Int64
+	maskedFramesWritten atomic.Int64
+
+	stats connStats
+
+	// metrics is set by SetMetricsCollector, guarded by rio and wio
+	metrics MetricsCollector
+
+	// closeSent is set under wio when close frame is written,
+	// after that writes return ErrConnClosed
+	closeSent atomic.Bool
+
+	// closed is set on close of rwc, after that reads return ErrConnClosed
+	closed atomic.Bool
+
+	// done is closed with closed set, so blocked sends of reads are woken
+	done chan struct{}
+
 	frameHandler
-	PayloadType        byte
+
+	// PayloadType is payload type of messages written by Write, it is
+	// TextFrame by default, BinaryFrame is set for binary messages
+	PayloadType byte
+
 	defaultCloseStatus int
 
 	// MaxPayloadBytes is max len of payload, if payload len
 	// is greater than that len will return ErrFrameTooLarge
 	MaxPayloadBytes int
+
+	// maxPayloadBytesForType overrides MaxPayloadBytes for payload types
+	maxPayloadBytesForType map[byte]int
+
+	// LenientOpcodes treats frames with reserved non-control opcodes (3-7)
+	// as binary frames instead of rejecting them with ErrBadOpCode.
+	// It is not standard behavior and should be used only for legacy peers
+	LenientOpcodes bool
+
+	// ReadExpectMasked is masking policy of read frames, frames violating
+	// the policy are discarded with ErrMaskingPolicy. It is independent
+	// of masking of written frames set by SetWriteMasking
+	ReadExpectMasked MaskingPolicy
+
+	// FlushInterval, if positive, makes Write to buffer frames and flush
+	// them to the connection once per interval instead of on each Write
+	FlushInterval time.Duration
+
+	// FragmentAssemblyTimeout, if positive, bounds time from the first to
+	// the last fragment of a message, it is checked on arrival of each
+	// continuation frame and bounds waiting for them by the read deadline.
+	// If it is exceeded the connection is closed and ErrAssemblyTimeout
+	// returns
+	FragmentAssemblyTimeout time.Duration
+
+	// assemblyStart is time of the first fragment of the current
+	// fragmented message, guarded by rio
+	assemblyStart time.Time
+
+	// assemblyDeadline is read deadline set until the last fragment of
+	// the current fragmented message, zero if it is not set, guarded by rio
+	assemblyDeadline time.Time
+
+	// DisableAutoFlush makes Write to buffer frames until Flush is called,
+	// control frames flush all buffered frames
+	DisableAutoFlush bool
+
+	// logger, if set, logs frames, close frames and swallowed errors
+	logger atomic.Pointer[slog.Logger]
+
+	// frameLogger, if set, is called with each read and written frame
+	frameLogger atomic.Pointer[frameLoggerFunc]
+
+	// FramePreviewLength is maximum length of payload preview passed to
+	// the frame logger, if 0 it is 32 bytes
+	FramePreviewLength int
+
+	// WriteRetries is number of retries of a flush failed with temporary
+	// error, the flush is retried with backoff only if no bytes of it were
+	// written. Retries work only if the connection created its buffer
+	WriteRetries int
+
+	// errorMapper maps errors to status and reason of CloseWithError
+	errorMapper func(err error) (status int, reason string)
+
+	// TruncateCloseReason makes CloseWithStatus to truncate too long reason
+	// on UTF-8 boundary instead of returning ErrControlFrameTooLarge
+	TruncateCloseReason bool
+
+	// ProtocolVersion, if not 0, is written after the preambule of each
+	// frame and validated on read, ErrVersionMismatch returns on mismatch.
+	// It is an extension of the wire format, so both peers must set it
+	ProtocolVersion byte
+
+	// LengthByteOrder is byte order of extended length fields of headers
+	// of read and written frames, BigEndian by default. LittleEndian is
+	// for interop with peers violating the wire format, both peers must
+	// set the same order
+	LengthByteOrder binary.ByteOrder
+
+	// OnBadPreambule, if set, is called when a frame has bad preambule
+	// and returns action to recover the connection
+	OnBadPreambule func() BadPreambuleAction
+
+	// ErrorHook, if set, is called with errors which the connection
+	// does not return to the caller
+	ErrorHook func(err error)
 }
 
 // Read implements io.Reader interface
 // it reads data of a frame from custom frame connection
 // if msg is smaller than a frame size, the rest of a frame
-// fills the msg and next Read will read next of the frame
+// fills the msg and next Read will read next of the frame.
+// Frame with payload greater than MaxPayloadBytes closes the connection
+// and ErrFrameTooLarge returns before the payload is read
 func (conn *Conn) Read(msg []byte) (int, error) {
+	if err := conn.checkPrefetch(); err != nil {
+		return 0, err
+	}
+
 	conn.rio.Lock()
 	defer conn.rio.Unlock()
 
+	if conn.closed.Load() {
+		return 0, ErrConnClosed
+	}
+
+	// empty msg does not consume a frame
+	if len(msg) == 0 {
+		return 0, nil
+	}
+
 	for {
 		if conn.frameReader == nil {
-			frame, err := conn.frameReaderFactory.NewFrameReader()
-			if err != nil {
-				return 0, err
-			}
-
-			// handle frame
-			conn.frameReader, err = conn.frameHandler.HandleFrame(frame)
+			var err error
+			conn.frameReader, err = conn.readFrame()
 			if err != nil {
 				return 0, err
 			}
@@ -109,6 +350,11 @@ func (conn *Conn) Read(msg []byte) (int, error) {
 			if conn.frameReader == nil {
 				continue
 			}
+
+			if err := conn.checkDeclaredLength(conn.frameReader); err != nil {
+				conn.frameReader = nil
+				return 0, err
+			}
 		}
 
 		n, err := conn.frameReader.Read(msg)
@@ -121,128 +367,2413 @@ func (conn *Conn) Read(msg []byte) (int, error) {
 	}
 }
 
-// ReadFrame reads all frame of the connection
-// if frame is too large return nil, ErrFrameTooLarge
+// checkDeclaredLength rejects frame with declared length of payload greater
+// than MaxPayloadBytes before its payload is read, so a peer can not stream
+// unbounded payload. Payload up to maxDiscardPayloadBytes is discarded and the
+// connection stays usable, greater payload is left unread and the connection
+// is closed without close frame, rio must be held by the caller
+func (conn *Conn) checkDeclaredLength(frame frameReader) error {
+	r, ok := frame.(*tcpFrameReader)
+	if !ok || r.header.Length <= int64(conn.maxPayloadBytes(frame.PayloadType())) {
+		return nil
+	}
+
+	conn.stats.oversized.Add(1)
+	conn.metrics.IncOversized()
+
+	if r.header.Length <= maxDiscardPayloadBytes {
+		// finish reading frame
+		if _, err := io.Copy(io.Discard, frame); err != nil {
+			return err
+		}
+
+		return ErrFrameTooLarge
+	}
+
+	if err := conn.closeWithoutHandshake(); err != nil {
+		conn.log().Debug("close on too large frame failed", "error", err)
+	}
+
+	return ErrFrameTooLarge
+}
+
+// ReadFrame reads the next message of the connection reassembling payloads
+// of its fragments. If a frame or the whole message is greater than
+// MaxPayloadBytes the rest of the message is discarded and ErrFrameTooLarge
+// returns, frame declaring more than 32MB of payload closes the connection
+// instead. ReadFrameRaw reads fragments one by one
 func (conn *Conn) ReadFrame() ([]byte, error) {
+	if p := conn.prefetch.Load(); p != nil {
+		return p.next()
+	}
+
+	return conn.readFrameData()
+}
+
+// readFrameData reads payload of the next frame of the connection
+func (conn *Conn) readFrameData() ([]byte, error) {
 	conn.rio.Lock()
 	defer conn.rio.Unlock()
 
-	// finish reading frameReader if it exists
-	if conn.frameReader != nil {
-		_, err := io.Copy(io.Discard, conn.frameReader)
+	frame, err := conn.nextFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := conn.readPayload(frame)
+	if err != nil || frame.Fin() {
+		return data, err
+	}
+
+	return conn.readFragments(frame, data)
+}
+
+// readFragments reads the rest fragments of the message started by frame
+// with payload data and returns payload of the whole message,
+// rio must be held by the caller
+func (conn *Conn) readFragments(frame frameReader, data []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(append([]byte{}, data...))
+	if conn.alloc != nil {
+		conn.free(data)
+	}
+
+	if err := conn.readFragmentsTo(frame, buf); err != nil {
+		return nil, err
+	}
+
+	msg := buf.Bytes()
+	if conn.alloc == nil {
+		return msg, nil
+	}
+
+	// payload of ReadFrame is freed by the caller, so it is allocated
+	// by the allocator like payload of not fragmented message
+	data = conn.alloc(len(msg))[:len(msg)]
+	copy(data, msg)
+	return data, nil
+}
+
+// readFragmentsTo appends payloads of the rest fragments of the message
+// started by frame to buf, if the whole message is greater than
+// MaxPayloadBytes the rest of the message is discarded and ErrFrameTooLarge
+// returns, rio must be held by the caller
+func (conn *Conn) readFragmentsTo(frame frameReader, buf *bytes.Buffer) error {
+	maxBytes := int64(conn.maxPayloadBytes(frame.PayloadType()))
+
+	for !frame.Fin() {
+		var err error
+		frame, err = conn.nextFrame()
 		if err != nil {
-			return nil, err
+			return err
 		}
-		conn.frameReader = nil
+
+		if int64(buf.Len())+payloadLength(frame) > maxBytes {
+			conn.stats.oversized.Add(1)
+			conn.metrics.IncOversized()
+
+			if _, err := conn.discardMessage(frame, 0); err != nil {
+				return err
+			}
+
+			return ErrFrameTooLarge
+		}
+
+		if _, err := buf.ReadFrom(frame); err != nil {
+			return conn.checkAssemblyDeadline(err)
+		}
+	}
+
+	return nil
+}
+
+// ReadFrameRaw reads payload of exactly one frame of the connection, even if
+// it is a fragment of a message, and returns payload type of the message
+// and whether the frame is the final fragment of the message
+func (conn *Conn) ReadFrameRaw() (byte, bool, []byte, error) {
+	if err := conn.checkPrefetch(); err != nil {
+		return 0, false, nil, err
+	}
+
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	frame, err := conn.nextFrame()
+	if err != nil {
+		return 0, false, nil, err
 	}
 
+	data, err := io.ReadAll(frame)
+	return frame.PayloadType(), frame.Fin(), data, err
+}
+
+// CopyTo reads frames of the connection and writes them to dst preserving
+// payload types and fragmentation until close frame is received.
+// Payloads are masked by dst as it is configured.
+// It returns amount of payload bytes was copied
+func (conn *Conn) CopyTo(dst *Conn) (int64, error) {
+	var (
+		total      int64
+		fragmented bool
+	)
+
 	for {
-		frame, err := conn.frameReaderFactory.NewFrameReader()
-		if err != nil {
-			return nil, err
+		payloadType, fin, data, err := conn.ReadFrameRaw()
+		if err == io.EOF {
+			return total, nil
 		}
 
-		frame, err = conn.frameHandler.HandleFrame(frame)
 		if err != nil {
-			return nil, err
+			return total, err
 		}
 
-		if frame == nil {
-			continue
+		// frames after the first one of a message are continuation frames
+		if fragmented {
+			payloadType = ContinuationFrame
 		}
+		fragmented = !fin
 
-		maxPayloadBytes := conn.MaxPayloadBytes
-		if maxPayloadBytes == 0 {
-			maxPayloadBytes = DefaultMaxPayloadBytes
+		if _, err := dst.WriteFrame(payloadType, fin, data); err != nil {
+			return total, err
 		}
+		total += int64(len(data))
+	}
+}
 
-		// check payload size if we can
-		if r, ok := frame.(*tcpFrameReader); ok && maxPayloadBytes < int(r.header.Length) {
-			// finish reading frame
-			_, err := io.Copy(io.Discard, frame)
-			if err != nil {
-				return nil, err
-			}
+// ReadFrameWithHeader reads payload of exactly one frame of the connection
+// like ReadFrameRaw and returns copy of its header, opcode of the header
+// is opcode of the frame as it is read
+func (conn *Conn) ReadFrameWithHeader() (FrameHeader, []byte, error) {
+	if err := conn.checkPrefetch(); err != nil {
+		return FrameHeader{}, nil, err
+	}
 
-			return nil, ErrFrameTooLarge
-		}
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
 
-		data, err := io.ReadAll(frame)
-		return data, err
+	frame, err := conn.nextFrame()
+	if err != nil {
+		return FrameHeader{}, nil, err
+	}
+
+	header := FrameHeader{
+		Fin:    frame.Fin(),
+		Rsv:    frame.Rsv(),
+		OpCode: frame.PayloadType(),
+		Length: int64(frame.Len()),
 	}
+
+	if r, ok := frame.(*tcpFrameReader); ok {
+		header.OpCode = r.wireOpCode
+		header.Length = r.header.Length
+		if r.header.MaskingKey != nil {
+			header.MaskingKey = append([]byte{}, r.header.MaskingKey...)
+		}
+	}
+
+	data, err := conn.readPayload(frame)
+	return header, data, err
 }
 
-// Write implemets io.Writer interface
-// write data as a custom frame of framing connection
-func (conn *Conn) Write(msg []byte) (int, error) {
-	conn.wio.Lock()
-	defer conn.wio.Unlock()
+// ReadFramePriority reads the next message of the connection like ReadFrame
+// and reports whether RSV3 bit of its first frame is set by WritePriority
+func (conn *Conn) ReadFramePriority() ([]byte, bool, error) {
+	if err := conn.checkPrefetch(); err != nil {
+		return nil, false, err
+	}
+
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
 
-	w, err := conn.frameWriterFactory.NewFrameWriter(conn.PayloadType)
+	frame, err := conn.nextFrame()
 	if err != nil {
-		return 0, err
+		return nil, false, err
+	}
+	high := frame.Rsv()[2]
+
+	data, err := conn.readPayload(frame)
+	if err != nil || frame.Fin() {
+		return data, high, err
 	}
-	defer w.Close()
 
-	n, err := w.Write(msg)
-	return n, err
+	data, err = conn.readFragments(frame, data)
+	return data, high, err
 }
 
-// Close implements io.Closer interface
-// send close frame and close rwc
-func (conn *Conn) Close() error {
-	err := conn.frameHandler.WriteClose(conn.frameWriterFactory, conn.defaultCloseStatus)
-	err1 := conn.rwc.Close()
-	if err != nil {
-		return err
+// ReadFrameWithin reads all frame of the connection like ReadFrame,
+// but the whole reading of header and payload must finish before deadline.
+// The read deadline set by the caller is restored after reading.
+// With prefetch enabled it only waits for the read ahead frame until deadline
+func (conn *Conn) ReadFrameWithin(deadline time.Time) ([]byte, error) {
+	if p := conn.prefetch.Load(); p != nil {
+		return p.nextWithin(deadline)
 	}
 
-	return err1
+	if err := conn.setReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	defer conn.restoreReadDeadline()
+
+	return conn.ReadFrame()
 }
 
-// LocalAddr return local address, if known
-func (conn *Conn) LocalAddr() net.Addr {
-	if c, ok := conn.rwc.(net.Conn); ok {
-		return c.LocalAddr()
+// ReadFrameContext reads the next message of the connection like ReadFrame, but
+// cancellation of ctx is honored only at frame boundaries: if ctx is done
+// before the frame starts it returns ctx.Err(), a frame already started
+// is read completely so the stream stays aligned.
+// Waiting for the frame is interrupted by the read deadline of the
+// connection, deadline set by the caller is restored after waiting
+func (conn *Conn) ReadFrameContext(ctx context.Context) ([]byte, error) {
+	if err := conn.checkPrefetch(); err != nil {
+		return nil, err
 	}
 
-	return nil
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if conn.closed.Load() {
+		return nil, ErrConnClosed
+	}
+
+	if conn.frameReader == nil && conn.peeked == nil {
+		if err := conn.waitFrameStart(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	frame, err := conn.nextFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := conn.readPayload(frame)
+	if err != nil || frame.Fin() {
+		return data, err
+	}
+
+	return conn.readFragments(frame, data)
 }
 
-// RemoteAddr return remote address, if known
-func (conn *Conn) RemoteAddr() net.Addr {
-	if c, ok := conn.rwc.(net.Conn); ok {
-		return c.RemoteAddr()
+// waitFrameStart blocks until first byte of the next frame is available
+// or ctx is done, rio must be held by the caller
+func (conn *Conn) waitFrameStart(ctx context.Context) error {
+	if conn.buf.Reader.Buffered() > 0 {
+		return nil
+	}
+
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		defer close(interrupted)
+		_ = conn.setReadDeadline(time.Unix(1, 0))
+	})
+
+	_, err := conn.buf.Reader.Peek(1)
+	if stop() {
+		// the connection may be closed while waiting
+		if err != nil && conn.closed.Load() {
+			return ErrConnClosed
+		}
+		return err
+	}
+
+	// ctx is done and the read deadline may be set
+	<-interrupted
+	conn.restoreReadDeadline()
+	if err != nil {
+		return ctx.Err()
 	}
 
 	return nil
 }
 
-var errSetDeadline = errors.New("conn: cannot set deadline: not using new.Conn")
+// PauseReads pauses reading of frames, reads block before the next frame
+// until ResumeReads or close of the connection. Data not read yet stays
+// buffered by the connection and the peer is throttled by the transport
+func (conn *Conn) PauseReads() {
+	conn.pauseMu.Lock()
+	defer conn.pauseMu.Unlock()
 
-// SetDeadline sets connection's read & write deadline
-func (conn *Conn) SetDeadline(t time.Time) error {
-	if c, ok := conn.rwc.(net.Conn); ok {
-		return c.SetDeadline(t)
+	conn.paused = true
+}
+
+// ResumeReads resumes reading of frames paused by PauseReads
+func (conn *Conn) ResumeReads() {
+	conn.pauseMu.Lock()
+	defer conn.pauseMu.Unlock()
+
+	conn.paused = false
+	conn.resumed.Broadcast()
+}
+
+// waitResumed blocks while reads are paused and the connection is not
+// closed, rio must be held by the caller, so reads keep their order
+func (conn *Conn) waitResumed() {
+	conn.pauseMu.Lock()
+	defer conn.pauseMu.Unlock()
+
+	for conn.paused && !conn.closed.Load() {
+		conn.resumed.Wait()
 	}
+}
 
-	return errSetDeadline
+// markClosed marks the connection as closed and wakes paused reads
+func (conn *Conn) markClosed() {
+	conn.pauseMu.Lock()
+	defer conn.pauseMu.Unlock()
+
+	if !conn.closed.Swap(true) {
+		close(conn.done)
+	}
+	conn.resumed.Broadcast()
+
+	if p := conn.prefetch.Load(); p != nil {
+		p.halt()
+	}
 }
 
-// SetDeadline sets connection read deadline
-func (conn *Conn) SetReadDeadline(t time.Time) error {
-	if c, ok := conn.rwc.(net.Conn); ok {
-		return c.SetReadDeadline(t)
+// WaitForReadable blocks until at least one byte is available to read
+// from the connection or the deadline, bytes are not consumed.
+// The read deadline set by the caller is restored after waiting
+func (conn *Conn) WaitForReadable(deadline time.Time) error {
+	if err := conn.checkPrefetch(); err != nil {
+		return err
 	}
 
-	return errSetDeadline
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	if conn.closed.Load() {
+		return ErrConnClosed
+	}
+
+	if conn.buf.Reader.Buffered() > 0 {
+		return nil
+	}
+
+	if err := conn.setReadDeadline(deadline); err != nil {
+		return err
+	}
+	defer conn.restoreReadDeadline()
+
+	// the connection may be closed while waiting
+	_, err := conn.buf.Reader.Peek(1)
+	if err != nil && conn.closed.Load() {
+		return ErrConnClosed
+	}
+
+	return err
 }
 
-// SetDeadline sets connection write deadline
-func (conn *Conn) SetWriteDeadline(t time.Time) error {
-	if c, ok := conn.rwc.(net.Conn); ok {
-		return c.SetWriteDeadline(t)
+// ReadFramePooled reads the next message of the connection reassembling
+// payloads of its fragments like ReadFrame into a buffer taken
+// from a pool and returns the payload with a release function.
+// The caller must call release when done with the payload, after that
+// the payload slice is invalid and must not be used, repeated calls of
+// release do nothing. If frame is too large return nil, nil, ErrFrameTooLarge
+func (conn *Conn) ReadFramePooled() ([]byte, func(), error) {
+	if err := conn.checkPrefetch(); err != nil {
+		return nil, nil, err
 	}
 
-	return errSetDeadline
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	// payload is copied into the pooled buffer, so frames are read
+	// by the scratch reader
+	conn.attachScratch()
+	defer conn.detachScratch()
+
+	frame, err := conn.nextFrame()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if conn.alloc != nil {
+		data, err := conn.readPayload(frame)
+		if err == nil && !frame.Fin() {
+			data, err = conn.readFragments(frame, data)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		free := conn.free
+		return data, func() { free(data) }, nil
+	}
+
+	buf := getPooledFrame()
+	if _, err = buf.ReadFrom(frame); err == nil {
+		err = conn.readFragmentsTo(frame, &buf.Buffer)
+	}
+	if err != nil {
+		buf.release()
+		return nil, nil, err
+	}
+
+	return buf.Bytes(), buf.release, nil
+}
+
+// ReadFrameBuffer resets buf and reads the next message of the connection
+// reassembling payloads of its fragments like ReadFrame into it, capacity
+// of buf is reused between calls.
+// if message is too large return ErrFrameTooLarge with empty buf
+func (conn *Conn) ReadFrameBuffer(buf *bytes.Buffer) error {
+	if err := conn.checkPrefetch(); err != nil {
+		return err
+	}
+
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	buf.Reset()
+
+	frame, err := conn.nextFrame()
+	if err != nil {
+		return err
+	}
+
+	if _, err = buf.ReadFrom(frame); err == nil {
+		err = conn.readFragmentsTo(frame, buf)
+	}
+	if err != nil {
+		buf.Reset()
+	}
+
+	return err
+}
+
+// ReadFrameInto reads all fragments of the next message into buf and
+// returns amount of read bytes. If buf is too small for a not fragmented
+// message, it returns *ShortBufferError with needed length and the message
+// stays unread, so the caller may retry with larger buf. If buf is too
+// small for a fragmented message, the rest of the message is discarded
+func (conn *Conn) ReadFrameInto(buf []byte) (int, error) {
+	if err := conn.checkPrefetch(); err != nil {
+		return 0, err
+	}
+
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	frame := conn.shortFrame
+	conn.shortFrame = nil
+	if frame == nil || frame != conn.frameReader {
+		var err error
+		frame, err = conn.nextFrame()
+		if err != nil {
+			return 0, err
+		}
+	}
+	conn.frameReader = nil
+
+	if frame.Fin() && payloadLength(frame) > int64(len(buf)) {
+		conn.frameReader = frame
+		conn.shortFrame = frame
+		return 0, &ShortBufferError{Needed: payloadLength(frame)}
+	}
+
+	n := 0
+	for {
+		length := payloadLength(frame)
+		if int64(n)+length > int64(len(buf)) {
+			needed, err := conn.discardMessage(frame, int64(n))
+			if err != nil {
+				return 0, err
+			}
+
+			return 0, &ShortBufferError{Needed: needed}
+		}
+
+		m, err := io.ReadFull(frame, buf[n:n+int(length)])
+		n += m
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return n, err
+		}
+
+		if frame.Fin() {
+			return n, nil
+		}
+
+		frame, err = conn.nextFrame()
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// ReadFrameUnmaskInto reads all fragments of the next message into dst
+// like ReadFrameInto unmasking payloads directly in dst. The frame reader
+// with buffers of its header is reused between calls, so reading does not
+// allocate. If dst is too small for the message, the message is discarded
+// and *ShortBufferError with needed length returns
+func (conn *Conn) ReadFrameUnmaskInto(dst []byte) (int, error) {
+	if err := conn.checkPrefetch(); err != nil {
+		return 0, err
+	}
+
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	conn.attachScratch()
+	defer conn.detachScratch()
+
+	frame, err := conn.nextFrame()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for {
+		length := payloadLength(frame)
+		if int64(n)+length > int64(len(dst)) {
+			needed, err := conn.discardMessage(frame, int64(n))
+			if err != nil {
+				return 0, err
+			}
+
+			return 0, &ShortBufferError{Needed: needed}
+		}
+
+		m, err := io.ReadFull(frame, dst[n:n+int(length)])
+		n += m
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return n, err
+		}
+
+		if frame.Fin() {
+			return n, nil
+		}
+
+		frame, err = conn.nextFrame()
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// attachScratch makes the frame reader factory read frames by the scratch
+// frame reader of the connection, so reading does not allocate. Frames are
+// read by the scratch reader only until detachScratch,
+// rio must be held by the caller
+func (conn *Conn) attachScratch() {
+	if factory, ok := conn.frameReaderFactory.(*tcpFrameReaderFactory); ok {
+		if conn.scratch == nil {
+			conn.scratch = factory.newScratchFrame()
+		}
+		factory.scratch = conn.scratch
+	}
+}
+
+// detachScratch stops reading of frames by the scratch frame reader,
+// rio must be held by the caller
+func (conn *Conn) detachScratch() {
+	if factory, ok := conn.frameReaderFactory.(*tcpFrameReaderFactory); ok {
+		factory.scratch = nil
+	}
+}
+
+// discardMessage discards frame and the rest fragments of its message
+// and returns length of the message with read bytes,
+// rio must be held by the caller
+func (conn *Conn) discardMessage(frame frameReader, read int64) (int64, error) {
+	for {
+		read += payloadLength(frame)
+		if _, err := io.Copy(io.Discard, frame); err != nil {
+			return 0, err
+		}
+
+		if frame.Fin() {
+			return read, nil
+		}
+
+		var err error
+		frame, err = conn.nextFrame()
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// payloadLength returns length of payload of the frame
+func payloadLength(frame frameReader) int64 {
+	if r, ok := frame.(*tcpFrameReader); ok {
+		return r.header.Length
+	}
+
+	return int64(frame.Len())
+}
+
+// ShortBufferError returns by ReadFrameInto if buf is too small for
+// the message, it wraps io.ErrShortBuffer
+type ShortBufferError struct {
+	// Needed is length of the message
+	Needed int64
+}
+
+func (e *ShortBufferError) Error() string {
+	return fmt.Sprintf("error short buffer, needed %d bytes", e.Needed)
+}
+
+func (e *ShortBufferError) Unwrap() error {
+	return io.ErrShortBuffer
+}
+
+// SetAllocator sets allocator of payloads read by ReadFrame and
+// ReadFramePooled. alloc must return slice with len of at least n bytes,
+// free is called by release of ReadFramePooled, payloads of ReadFrame
+// are freed by the caller. If alloc is nil payloads are allocated by make
+func (conn *Conn) SetAllocator(alloc func(n int) []byte, free func(b []byte)) {
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	if free == nil {
+		free = func([]byte) {}
+	}
+
+	conn.alloc = alloc
+	conn.free = free
+}
+
+// readPayload reads payload of frame into slice of the allocator
+// if it is set, rio must be held by the caller
+func (conn *Conn) readPayload(frame frameReader) ([]byte, error) {
+	r, ok := frame.(*tcpFrameReader)
+	if conn.alloc == nil || !ok {
+		return io.ReadAll(frame)
+	}
+
+	data := conn.alloc(int(r.header.Length))[:r.header.Length]
+	n, err := io.ReadFull(frame, data)
+	if err == io.ErrUnexpectedEOF {
+		// payload is truncated like with io.ReadAll
+		err = nil
+	}
+
+	if err != nil {
+		conn.free(data)
+		return nil, err
+	}
+
+	return data[:n], nil
+}
+
+// framePool is pool of buffers for ReadFramePooled
+var framePool sync.Pool
+
+// pooledFrame is buffer of ReadFramePooled with release function
+// created once per buffer, so reading does not allocate
+type pooledFrame struct {
+	bytes.Buffer
+	release func()
+
+	// released is set by release, so repeated calls do not put
+	// the buffer to framePool twice
+	released atomic.Bool
+}
+
+// getPooledFrame returns empty buffer from framePool
+func getPooledFrame() *pooledFrame {
+	buf, _ := framePool.Get().(*pooledFrame)
+	if buf == nil {
+		buf = &pooledFrame{}
+		buf.release = func() {
+			if buf.released.CompareAndSwap(false, true) {
+				framePool.Put(buf)
+			}
+		}
+	}
+	buf.Reset()
+	buf.released.Store(false)
+
+	return buf
+}
+
+// nextFrame finishes reading current frameReader if it exists
+// and returns next handled frame of the connection,
+// rio must be held by the caller
+func (conn *Conn) nextFrame() (frameReader, error) {
+	return conn.nextFrameUntil(nil)
+}
+
+// nextFrameUntil returns next handled frame of the connection like
+// nextFrame, but if stop is set and returns true after a frame is handled
+// without a result, e.g. control frame, it returns nil frame,
+// rio must be held by the caller
+func (conn *Conn) nextFrameUntil(stop func() bool) (frameReader, error) {
+	// finish reading frameReader if it exists
+	if conn.frameReader != nil {
+		_, err := io.Copy(io.Discard, conn.frameReader)
+		if err != nil {
+			return nil, err
+		}
+		conn.frameReader = nil
+	}
+
+	for {
+		frame, err := conn.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		if frame == nil {
+			if stop != nil && stop() {
+				return nil, nil
+			}
+			continue
+		}
+
+		// check payload size if we can
+		if err := conn.checkDeclaredLength(frame); err != nil {
+			return nil, err
+		}
+
+		return frame, nil
+	}
+}
+
+// SetMaxPayloadBytesForType sets max len of payload for frames with the
+// payload type, if n is 0 MaxPayloadBytes is used for the type
+func (conn *Conn) SetMaxPayloadBytesForType(payloadType byte, n int) {
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	if n == 0 {
+		delete(conn.maxPayloadBytesForType, payloadType)
+		return
+	}
+
+	if conn.maxPayloadBytesForType == nil {
+		conn.maxPayloadBytesForType = make(map[byte]int)
+	}
+	conn.maxPayloadBytesForType[payloadType] = n
+}
+
+// maxPayloadBytes returns max len of payload for the payload type,
+// rio must be held by the caller
+func (conn *Conn) maxPayloadBytes(payloadType byte) int {
+	if n, ok := conn.maxPayloadBytesForType[payloadType]; ok {
+		return n
+	}
+
+	if conn.MaxPayloadBytes == 0 {
+		return DefaultMaxPayloadBytes
+	}
+
+	return conn.MaxPayloadBytes
+}
+
+// readFrame creates reader of the next frame and handles it,
+// if the handler rejects the frame, its payload is discarded
+// to keep the stream aligned on frame boundaries
+func (conn *Conn) readFrame() (handled frameReader, err error) {
+	defer func() {
+		if isProtocolError(err) {
+			conn.stats.protocolErrors.Add(1)
+			conn.metrics.IncProtocolErrors()
+			conn.log().Debug("protocol error", "error", err)
+		}
+	}()
+
+	conn.waitResumed()
+	if conn.closed.Load() {
+		return nil, ErrConnClosed
+	}
+
+	frame := conn.peeked
+	conn.peeked = nil
+	if frame == nil {
+		frame, err = conn.newFrameReader()
+		if err != nil {
+			return nil, conn.checkAssemblyDeadline(err)
+		}
+	}
+
+	conn.stats.framesRead.Add(1)
+	conn.metrics.IncFramesRead()
+	if conn.debugEnabled() {
+		conn.logFrameRead(frame)
+	}
+	if fn := conn.frameLogger.Load(); fn != nil {
+		conn.logFrameReadTo(*fn, frame)
+	}
+	conn.lastMaskingKey = nil
+	if r, ok := frame.(*tcpFrameReader); ok {
+		conn.stats.bytesRead.Add(r.header.Length)
+		conn.metrics.IncBytesRead(r.header.Length)
+
+		masked := r.header.MaskingKey != nil
+		if masked {
+			conn.lastMaskingKey = r.header.MaskingKey
+			conn.maskedFramesRead.Add(1)
+		}
+
+		if r.sequenced {
+			expected := conn.readSeq
+			conn.readSeq = r.seq + 1
+			if r.seq != expected {
+				_, _ = io.Copy(io.Discard, frame)
+				return nil, &SequenceGapError{Expected: expected, Received: r.seq}
+			}
+		}
+
+		if (conn.ReadExpectMasked == MaskingRequired && !masked) ||
+			(conn.ReadExpectMasked == MaskingForbidden && masked) {
+			_, _ = io.Copy(io.Discard, frame)
+			return nil, ErrMaskingPolicy
+		}
+	}
+
+	if conn.LenientOpcodes {
+		op := frame.PayloadType()
+		if r, ok := frame.(*tcpFrameReader); ok && op >= minReservedDataFrame && op <= maxReservedDataFrame {
+			r.header.OpCode = BinaryFrame
+		}
+	}
+
+	// fragmented close frame is rejected by the handler
+	if frame.PayloadType() == CloseFrame && frame.Fin() {
+		if err := conn.readClosePayload(frame); err != nil {
+			return nil, err
+		}
+	}
+
+	if conn.routeControlFrames.Load() && frame.PayloadType() >= CloseFrame {
+		if err := conn.routeControlFrame(frame); err != nil {
+			return nil, err
+		}
+
+		// close frame is handled to finish reading
+		if frame.PayloadType() != CloseFrame {
+			return nil, nil
+		}
+	}
+
+	if conn.controlHook != nil && (frame.PayloadType() == PingFrame || frame.PayloadType() == PongFrame) {
+		return nil, conn.readControl(frame)
+	}
+
+	continuation := frame.PayloadType() == ContinuationFrame
+	handled, err = conn.frameHandler.HandleFrame(frame)
+	if err != nil {
+		_, _ = io.Copy(io.Discard, frame)
+		return nil, err
+	}
+
+	if handled != nil {
+		if err := conn.checkAssembly(handled, continuation); err != nil {
+			return nil, err
+		}
+	}
+
+	if conn.onFragment != nil && handled != nil && (continuation || !handled.Fin()) {
+		fragmentBytes := handled.Len()
+		if r, ok := handled.(*tcpFrameReader); ok {
+			fragmentBytes = int(r.header.Length)
+		}
+
+		conn.onFragment(handled.PayloadType(), fragmentBytes, handled.Fin())
+	}
+
+	return handled, nil
+}
+
+// checkAssembly tracks time of assembling of fragmented message and
+// closes the connection if FragmentAssemblyTimeout is exceeded on arrival
+// of continuation frame, rio must be held by the caller
+func (conn *Conn) checkAssembly(frame frameReader, continuation bool) error {
+	if !continuation {
+		if !frame.Fin() {
+			conn.assemblyStart = conn.clock.Now()
+			conn.setAssemblyDeadline()
+		}
+		return nil
+	}
+
+	if conn.FragmentAssemblyTimeout > 0 &&
+		conn.clock.Now().Sub(conn.assemblyStart) > conn.FragmentAssemblyTimeout {
+		_, _ = io.Copy(io.Discard, frame)
+		return conn.closeOnAssemblyTimeout()
+	}
+
+	if frame.Fin() {
+		conn.clearAssemblyDeadline()
+	}
+
+	return nil
+}
+
+// setAssemblyDeadline sets read deadline of FragmentAssemblyTimeout, so
+// a peer which stalls after the first fragment does not block reads.
+// Earlier deadline of the caller is kept, rio must be held by the caller
+func (conn *Conn) setAssemblyDeadline() {
+	if conn.FragmentAssemblyTimeout <= 0 {
+		return
+	}
+
+	deadline := time.Now().Add(conn.FragmentAssemblyTimeout)
+
+	conn.deadlineMu.Lock()
+	caller := conn.readDeadline
+	conn.deadlineMu.Unlock()
+
+	if !caller.IsZero() && caller.Before(deadline) {
+		return
+	}
+
+	// connection may not support deadlines, then the timeout is checked
+	// only on arrival of continuation frames
+	if conn.setReadDeadline(deadline) == nil {
+		conn.assemblyDeadline = deadline
+	}
+}
+
+// clearAssemblyDeadline restores read deadline of the caller after
+// assembling of fragmented message, rio must be held by the caller
+func (conn *Conn) clearAssemblyDeadline() {
+	if conn.assemblyDeadline.IsZero() {
+		return
+	}
+
+	conn.assemblyDeadline = time.Time{}
+	conn.restoreReadDeadline()
+}
+
+// checkAssemblyDeadline returns ErrAssemblyTimeout and closes the
+// connection if read error err is caused by the assembly deadline,
+// otherwise returns err, rio must be held by the caller
+func (conn *Conn) checkAssemblyDeadline(err error) error {
+	if conn.assemblyDeadline.IsZero() || !errors.Is(err, os.ErrDeadlineExceeded) ||
+		time.Now().Before(conn.assemblyDeadline) {
+		return err
+	}
+
+	return conn.closeOnAssemblyTimeout()
+}
+
+// closeOnAssemblyTimeout closes the connection with policy violation
+// status and returns ErrAssemblyTimeout, rio must be held by the caller
+func (conn *Conn) closeOnAssemblyTimeout() error {
+	conn.clearAssemblyDeadline()
+	if err := conn.CloseWithStatus(closeStatusPolicyViolation, ErrAssemblyTimeout.Error()); err != nil {
+		conn.log().Debug("close on assembly timeout failed", "error", err)
+	}
+
+	return ErrAssemblyTimeout
+}
+
+// ControlFrame is control frame received by the connection
+type ControlFrame struct {
+	PayloadType byte
+	Payload     []byte
+}
+
+// ControlFrames returns channel of read ping, pong and close frames, after
+// the call ping and pong frames are delivered only to the channel and close
+// frames are delivered before reads return io.EOF. The channel must be
+// drained, otherwise reading of frames is blocked until close of the connection
+func (conn *Conn) ControlFrames() <-chan ControlFrame {
+	conn.routeControlFrames.Store(true)
+	return conn.controlFrames
+}
+
+// routeControlFrame reads payload of control frame and sends it to
+// controlFrames, rio must be held by the caller
+func (conn *Conn) routeControlFrame(frame frameReader) error {
+	if !frame.Fin() {
+		_, _ = io.Copy(io.Discard, frame)
+		return ErrUnexpectedFragment
+	}
+
+	if r, ok := frame.(*tcpFrameReader); ok && r.header.Length > maxControlPayloadBytes {
+		_, _ = io.Copy(io.Discard, frame)
+		return ErrControlFrameTooLarge
+	}
+
+	payload, err := io.ReadAll(frame)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case conn.controlFrames <- ControlFrame{PayloadType: frame.PayloadType(), Payload: payload}:
+		return nil
+	case <-conn.done:
+		return ErrConnClosed
+	}
+}
+
+// SetFragmentHandler sets handler called on each read frame of a fragmented
+// message with payload type of the message, len of payload of the frame
+// and its fin bit, e.g. to report progress of large messages
+func (conn *Conn) SetFragmentHandler(handler func(payloadType byte, fragmentBytes int, fin bool)) {
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	conn.onFragment = handler
+}
+
+// PeekType reads header of the next frame and returns its payload type
+// without reading the payload, the frame stays available for the next read.
+// For a continuation frame it returns payload type of the fragmented message
+func (conn *Conn) PeekType() (byte, error) {
+	if err := conn.checkPrefetch(); err != nil {
+		return 0, err
+	}
+
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	if conn.closed.Load() {
+		return 0, ErrConnClosed
+	}
+
+	// finish reading frameReader if it exists
+	if conn.frameReader != nil {
+		_, err := io.Copy(io.Discard, conn.frameReader)
+		if err != nil {
+			return 0, err
+		}
+		conn.frameReader = nil
+	}
+
+	if conn.peeked == nil {
+		frame, err := conn.newFrameReader()
+		if err != nil {
+			return 0, err
+		}
+		conn.peeked = frame
+	}
+
+	payloadType := conn.peeked.PayloadType()
+	if handler, ok := conn.frameHandler.(*tcpFrameHandler); ok && payloadType == ContinuationFrame {
+		payloadType = handler.payloadType
+	}
+
+	return payloadType, nil
+}
+
+// BadPreambuleAction is action on bad preambule returned by OnBadPreambule
+type BadPreambuleAction int
+
+const (
+	// CloseConn closes the connection with protocol error status,
+	// the read returns ErrBadPreambule
+	CloseConn BadPreambuleAction = iota
+
+	// Resync skips bytes until the next preambule followed
+	// by a plausible header and reads the frame
+	Resync
+
+	// Skip skips bytes until the next preambule and reads the frame
+	Skip
+)
+
+// newFrameReader reads header of the next frame and recovers
+// on bad preambule with action of OnBadPreambule,
+// rio must be held by the caller
+func (conn *Conn) newFrameReader() (frameReader, error) {
+	frame, err := conn.frameReaderFactory.NewFrameReader()
+	if !errors.Is(err, ErrBadPreambule) || conn.OnBadPreambule == nil {
+		return frame, err
+	}
+
+	factory, ok := conn.frameReaderFactory.(*tcpFrameReaderFactory)
+	switch action := conn.OnBadPreambule(); {
+	case action == Resync && ok:
+		return factory.recoverFrameReader(true)
+	case action == Skip && ok:
+		return factory.recoverFrameReader(false)
+	default:
+		if err := conn.CloseWithStatus(closeStatusProtocolError, ErrBadPreambule.Error()); err != nil {
+			conn.log().Debug("close on bad preambule failed", "error", err)
+		}
+		return nil, ErrBadPreambule
+	}
+}
+
+// readClosePayload reads payload of close frame and stores its status and
+// reason, the payload stays available to read from the frame,
+// rio must be held by the caller
+func (conn *Conn) readClosePayload(frame frameReader) error {
+	r, ok := frame.(*tcpFrameReader)
+	if !ok {
+		return nil
+	}
+
+	if r.header.Length > maxControlPayloadBytes {
+		return ErrControlFrameTooLarge
+	}
+
+	raw, err := io.ReadAll(r.reader)
+	if err != nil {
+		return err
+	}
+	r.reader = bytes.NewReader(raw)
+
+	payload := append([]byte{}, raw...)
+	for i := range payload {
+		payload[i] ^= maskByte(r.header.MaskingKey, i)
+	}
+
+	conn.closeReceived = true
+	conn.closeStatus, conn.closeReason = parseClosePayload(payload)
+	conn.log().Debug("close frame received", "status", conn.closeStatus, "reason", conn.closeReason)
+	return nil
+}
+
+// CloseStatus returns status and reason of close frame received by
+// the connection and whether it is received. Close frame without
+// payload has closeStatusNoStatusRcvd status
+func (conn *Conn) CloseStatus() (int, string, bool) {
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	return conn.closeStatus, conn.closeReason, conn.closeReceived
+}
+
+// maskByte returns byte of the masking key for the payload position
+func maskByte(maskingKey []byte, pos int) byte {
+	if maskingKey == nil {
+		return 0
+	}
+
+	return maskingKey[pos%4]
+}
+
+// DrainUntilClose reads and discards all frames until the close frame of
+// the peer or the deadline and returns status and reason of the close frame.
+// If the connection does not support deadlines, it drains without deadline.
+// If close frame is already sent and the transport fails before the close
+// frame of the peer, e.g. both peers close at the same time, the close is
+// clean and it returns closeStatusNoStatusRcvd
+func (conn *Conn) DrainUntilClose(deadline time.Time) (int, string, error) {
+	if err := conn.checkPrefetch(); err != nil {
+		return 0, "", err
+	}
+
+	err := conn.setReadDeadline(deadline)
+	if err != nil && err != errSetDeadline {
+		return 0, "", err
+	}
+	if err == nil {
+		defer conn.restoreReadDeadline()
+	}
+
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	for {
+		frame, err := conn.nextFrame()
+		switch {
+		case err == io.EOF && conn.closeReceived:
+			return conn.closeStatus, conn.closeReason, nil
+		case isRejectedFrame(err):
+			// payload of rejected frame is already discarded
+			continue
+		case err != nil && conn.closedByPeer(err):
+			return closeStatusNoStatusRcvd, "", nil
+		case err != nil:
+			return 0, "", err
+		}
+
+		if _, err := io.Copy(io.Discard, frame); err != nil {
+			if conn.closedByPeer(err) {
+				return closeStatusNoStatusRcvd, "", nil
+			}
+			return 0, "", err
+		}
+	}
+}
+
+// closedByPeer reports whether read error err is caused by the peer closing
+// the transport after our close frame is sent, e.g. when both peers close
+// at the same time, so the close handshake is finished
+func (conn *Conn) closedByPeer(err error) bool {
+	if !conn.closeSent.Load() || isProtocolError(err) {
+		return false
+	}
+
+	var ne net.Error
+	return !errors.As(err, &ne) || !ne.Timeout()
+}
+
+// ReadAllFrames reads messages of the connection until close frame is
+// received and returns them with status of the close frame. Fragments of
+// a message are joined. If total size of payloads is greater than maxTotal
+// return read messages and ErrFrameTooLarge, if maxTotal <= 0 there is no cap
+func (conn *Conn) ReadAllFrames(maxTotal int64) ([][]byte, int, error) {
+	if err := conn.checkPrefetch(); err != nil {
+		return nil, 0, err
+	}
+
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	var (
+		messages [][]byte
+		msg      []byte
+		total    int64
+	)
+
+	for {
+		frame, err := conn.nextFrame()
+		if err == io.EOF && conn.closeReceived {
+			return messages, conn.closeStatus, nil
+		}
+
+		if err != nil {
+			return messages, 0, err
+		}
+
+		reader := io.Reader(frame)
+		if maxTotal > 0 {
+			reader = io.LimitReader(frame, maxTotal-total+1)
+		}
+
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return messages, 0, err
+		}
+
+		total += int64(len(data))
+		if maxTotal > 0 && total > maxTotal {
+			// finish reading frame
+			if _, err := io.Copy(io.Discard, frame); err != nil {
+				return messages, 0, err
+			}
+
+			return messages, 0, ErrFrameTooLarge
+		}
+
+		msg = append(msg, data...)
+		if frame.Fin() {
+			if msg == nil {
+				msg = data
+			}
+
+			messages = append(messages, msg)
+			msg = nil
+		}
+	}
+}
+
+// Reset drops partially read frame and fragmentation state of the
+// frame handler, so the next read starts from a new message.
+// It is useful to continue reading after the handler returned an error
+func (conn *Conn) Reset() error {
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	if conn.frameReader != nil {
+		_, err := io.Copy(io.Discard, conn.frameReader)
+		if err != nil {
+			return err
+		}
+		conn.frameReader = nil
+	}
+
+	if handler, ok := conn.frameHandler.(frameHandlerResetter); ok {
+		handler.Reset()
+	}
+	conn.clearAssemblyDeadline()
+
+	return nil
+}
+
+// Write implemets io.Writer interface
+// write data as a custom frame of framing connection and returns amount
+// of bytes of msg was written, preambule and header are not counted
+func (conn *Conn) Write(msg []byte) (int, error) {
+	conn.wio.Lock()
+	defer conn.wio.Unlock()
+
+	n, err := conn.writeFrame(conn.PayloadType, msg)
+	if err != nil {
+		// preambule and header are written before the payload
+		return max(0, n-conn.frameOverhead), err
+	}
+
+	return len(msg), nil
+}
+
+// Writev writes payloads as one frame with payloadType without
+// concatenating them and returns amount of bytes was written
+// + len(preambule) + len(header)
+func (conn *Conn) Writev(payloadType byte, payloads ...[]byte) (int, error) {
+	conn.wio.Lock()
+	defer conn.wio.Unlock()
+
+	return conn.writeFrame(payloadType, payloads...)
+}
+
+// WriteFrame writes msg as one frame with payloadType and fin bit, it is
+// used to fragment messages manually: the first frame has payload type of
+// the message and the next ones have ContinuationFrame payload type
+func (conn *Conn) WriteFrame(payloadType byte, fin bool, msg []byte) (int, error) {
+	conn.wio.Lock()
+	defer conn.wio.Unlock()
+
+	return conn.writeFrameHeader(tcpFrameHeader{Fin: fin, OpCode: payloadType}, msg)
+}
+
+// WritevFrame writes payloads as one frame with payloadType and fin bit
+// like WriteFrame without concatenating them, masking continues
+// across the payloads
+func (conn *Conn) WritevFrame(payloadType byte, fin bool, payloads ...[]byte) (int, error) {
+	conn.wio.Lock()
+	defer conn.wio.Unlock()
+
+	return conn.writeFrameHeader(tcpFrameHeader{Fin: fin, OpCode: payloadType}, payloads...)
+}
+
+// WritePrebuilt writes frame built by BuildFrame verbatim, so the same
+// frame may be written to many connections without framing it for each
+// of them. The frame is not validated, it must match preambule, protocol
+// version, sequencing and masking of the connection and does not consume
+// the write credit
+func (conn *Conn) WritePrebuilt(frame []byte) (int, error) {
+	conn.wio.Lock()
+	defer conn.wio.Unlock()
+
+	if conn.closeSent.Load() {
+		return 0, ErrConnClosed
+	}
+
+	conn.writeLimiter.wait(len(frame))
+	if _, err := conn.buf.Writer.Write(frame); err != nil {
+		return 0, err
+	}
+
+	conn.stats.framesWritten.Add(1)
+	conn.metrics.IncFramesWritten()
+
+	if conn.DisableAutoFlush || conn.FlushInterval > 0 {
+		if conn.FlushInterval > 0 && conn.flushTimer == nil {
+			conn.flushTimer = conn.clock.AfterFunc(conn.FlushInterval, conn.flushBuffered)
+		}
+		return len(frame), nil
+	}
+
+	if err := conn.buf.Writer.Flush(); err != nil {
+		// not written bytes of the frame are at the end of the buffer
+		return len(frame) - min(len(frame), conn.buf.Writer.Buffered()), err
+	}
+
+	return len(frame), nil
+}
+
+// WriteFragmented writes msg as a message with payloadType fragmented into
+// frames with payload of at most fragmentSize bytes, if fragmentSize <= 0
+// the hint of SetFrameSizeHint is used, without the hint msg is written
+// as one frame. Frames of the message are contiguous on the
+// wire. It returns amount of bytes was written with all frame headers
+func (conn *Conn) WriteFragmented(payloadType byte, msg []byte, fragmentSize int) (int, error) {
+	conn.wio.Lock()
+	defer conn.wio.Unlock()
+
+	return conn.writeFragmented(payloadType, msg, fragmentSize)
+}
+
+// writeFragmented writes fragmented message, wio must be held by the caller
+func (conn *Conn) writeFragmented(payloadType byte, msg []byte, fragmentSize int) (int, error) {
+	if fragmentSize <= 0 {
+		fragmentSize = conn.frameSizeHint
+	}
+
+	if fragmentSize <= 0 {
+		fragmentSize = max(len(msg), 1)
+	}
+
+	total := 0
+	header := tcpFrameHeader{OpCode: payloadType}
+	for {
+		fragment := msg[:min(fragmentSize, len(msg))]
+		msg = msg[len(fragment):]
+		header.Fin = len(msg) == 0
+
+		n, err := conn.writeFrameHeader(header, fragment)
+		total += n
+		if err != nil || header.Fin {
+			return total, err
+		}
+
+		// control frames are not delayed until the end of the message
+		conn.writePendingControl()
+
+		header.OpCode = ContinuationFrame
+	}
+}
+
+// SetFrameSizeHint sets default size of fragments of WriteFragmented and
+// WriteFrom. n must be positive and not greater than MaxPayloadBytes
+// of the connection, otherwise ErrBadFrameSizeHint is returned
+func (conn *Conn) SetFrameSizeHint(n int) error {
+	maxPayload := conn.MaxPayloadBytes
+	if maxPayload <= 0 {
+		maxPayload = DefaultMaxPayloadBytes
+	}
+
+	if n <= 0 || n > maxPayload {
+		return ErrBadFrameSizeHint
+	}
+
+	conn.wio.Lock()
+	defer conn.wio.Unlock()
+
+	conn.frameSizeHint = n
+	return nil
+}
+
+// WriteFrom reads r until io.EOF and writes read data as a message with
+// payloadType fragmented by the hint of SetFrameSizeHint, without the hint
+// fragments are of defaultFrameSizeHint bytes. Frames of the message are
+// contiguous on the wire. It returns amount of bytes was read from r
+func (conn *Conn) WriteFrom(payloadType byte, r io.Reader) (int64, error) {
+	conn.wio.Lock()
+	defer conn.wio.Unlock()
+
+	size := conn.frameSizeHint
+	if size <= 0 {
+		size = defaultFrameSizeHint
+	}
+
+	// the next fragment is read before writing of the current one,
+	// so the final fragment is known
+	cur, next := make([]byte, size), make([]byte, size)
+	n, err := io.ReadFull(r, cur)
+
+	var total int64
+	header := tcpFrameHeader{OpCode: payloadType}
+	for {
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return total, err
+		}
+
+		var (
+			m       int
+			nextErr error
+		)
+		if !last {
+			m, nextErr = io.ReadFull(r, next)
+			last = nextErr == io.EOF
+		}
+
+		header.Fin = last
+		if _, err := conn.writeFrameHeader(header, cur[:n]); err != nil {
+			return total, err
+		}
+		total += int64(n)
+
+		if last {
+			return total, nil
+		}
+
+		// control frames are not delayed until the end of the message
+		conn.writePendingControl()
+
+		header.OpCode = ContinuationFrame
+		cur, next = next, cur
+		n, err = m, nextErr
+	}
+}
+
+// controlWrite is control frame waiting for write by WriteControl
+type controlWrite struct {
+	payloadType byte
+	payload     []byte
+
+	n    int
+	err  error
+	done chan struct{}
+
+	// report is set if error of the write is reported to ErrorHook
+	report bool
+}
+
+// WriteControl writes ping or pong frame with payload. The frame has
+// priority over data: it is written between fragments of a message
+// written by WriteFragmented instead of waiting for the whole message
+func (conn *Conn) WriteControl(payloadType byte, payload []byte) (int, error) {
+	if payloadType != PingFrame && payloadType != PongFrame {
+		return 0, ErrBadOpCode
+	}
+
+	if len(payload) > maxControlPayloadBytes {
+		return 0, ErrControlFrameTooLarge
+	}
+
+	req := conn.queueControl(payloadType, payload)
+	<-req.done
+	return req.n, req.err
+}
+
+// WriteControlQueued queues ping or pong frame with payload and returns
+// without waiting for the write lock, so a reading goroutine does not stall
+// behind a long write. The frame is written after the current write or
+// between fragments of the current fragmented write, its write errors are
+// reported to ErrorHook
+func (conn *Conn) WriteControlQueued(payloadType byte, payload []byte) error {
+	if payloadType != PingFrame && payloadType != PongFrame {
+		return ErrBadOpCode
+	}
+
+	if len(payload) > maxControlPayloadBytes {
+		return ErrControlFrameTooLarge
+	}
+
+	if conn.closeSent.Load() {
+		return ErrConnClosed
+	}
+
+	req := conn.queueControl(payloadType, payload)
+	go func() {
+		<-req.done
+		if req.err != nil && req.err != ErrConnClosed {
+			conn.reportError(req.err)
+		}
+	}()
+
+	return nil
+}
+
+// Ping writes ping frame with payload to probe liveness of the peer,
+// payload must be at most 125 bytes
+func (conn *Conn) Ping(payload []byte) error {
+	_, err := conn.WriteControl(PingFrame, payload)
+	return err
+}
+
+// Pong writes pong frame with payload, e.g. as unsolicited heartbeat,
+// payload must be at most 125 bytes
+func (conn *Conn) Pong(payload []byte) error {
+	_, err := conn.WriteControl(PongFrame, payload)
+	return err
+}
+
+// SetPingHandler sets handler of ping frames read by the default frame
+// handler, if h is nil ping frames are answered with pong frames.
+// Error of the handler closes the connection and is returned by the read
+func (conn *Conn) SetPingHandler(h func(payload []byte) error) {
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	conn.pingHandler = h
+}
+
+// SetPongHandler sets handler of pong frames read by the default frame
+// handler, if h is nil pong frames are skipped.
+// Error of the handler closes the connection and is returned by the read
+func (conn *Conn) SetPongHandler(h func(payload []byte) error) {
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	conn.pongHandler = h
+}
+
+// handleControl calls handler of ping or pong frame with payload,
+// rio must be held by the caller
+func (conn *Conn) handleControl(payloadType byte, payload []byte) error {
+	h := conn.pongHandler
+	if payloadType == PingFrame {
+		h = conn.pingHandler
+		if h == nil {
+			conn.writePong(payload)
+			return nil
+		}
+	}
+
+	if h == nil {
+		return nil
+	}
+
+	if err := h(payload); err != nil {
+		if closeErr := conn.CloseWithError(err); closeErr != nil {
+			conn.log().Debug("close on control handler error failed", "error", closeErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// writePong queues pong frame answering ping frame with payload, so the
+// reader does not wait for wio held by a long or blocked write. Until the
+// pong frame is written, pong frames answering later ping frames only
+// replace its payload. The pong frame is not written after close frame,
+// other errors are reported to ErrorHook
+func (conn *Conn) writePong(payload []byte) {
+	if conn.closeSent.Load() {
+		return
+	}
+
+	conn.controlMu.Lock()
+	defer conn.controlMu.Unlock()
+
+	if conn.pendingPong != nil {
+		conn.pendingPong.payload = payload
+		return
+	}
+
+	conn.pendingPong = &controlWrite{
+		payloadType: PongFrame,
+		payload:     payload,
+		done:        make(chan struct{}),
+		report:      true,
+	}
+	conn.pendingControl = append(conn.pendingControl, conn.pendingPong)
+
+	go conn.writeQueuedControl()
+}
+
+// queueControl queues control frame to write with priority over data,
+// done of the returned request is closed after the frame is written
+func (conn *Conn) queueControl(payloadType byte, payload []byte) *controlWrite {
+	req := &controlWrite{
+		payloadType: payloadType,
+		payload:     payload,
+		done:        make(chan struct{}),
+	}
+
+	conn.controlMu.Lock()
+	conn.pendingControl = append(conn.pendingControl, req)
+	conn.controlMu.Unlock()
+
+	go conn.writeQueuedControl()
+
+	return req
+}
+
+// writeQueuedControl writes queued control frames, the frames are written
+// by the current fragmented write or after the current write
+func (conn *Conn) writeQueuedControl() {
+	conn.wio.Lock()
+	defer conn.wio.Unlock()
+
+	conn.writePendingControl()
+}
+
+// writePendingControl writes control frames waiting for wio,
+// wio must be held by the caller
+func (conn *Conn) writePendingControl() {
+	conn.controlMu.Lock()
+	pending := conn.pendingControl
+	conn.pendingControl = nil
+	conn.pendingPong = nil
+	conn.controlMu.Unlock()
+
+	for _, req := range pending {
+		req.n, req.err = conn.writeFrame(req.payloadType, req.payload)
+		close(req.done)
+
+		if req.report && req.err != nil && req.err != ErrConnClosed {
+			conn.reportError(req.err)
+		}
+	}
+}
+
+// WritePriority writes msg as a frame with PayloadType and sets RSV3 bit
+// of the frame if high is true, it is read back by ReadFramePriority
+func (conn *Conn) WritePriority(msg []byte, high bool) (int, error) {
+	conn.wio.Lock()
+	defer conn.wio.Unlock()
+
+	header := tcpFrameHeader{Fin: true, OpCode: conn.PayloadType}
+	header.Rsv[2] = high
+	return conn.writeFrameHeader(header, msg)
+}
+
+// writeFrame writes payloads as a frame with payloadType,
+// wio must be held by the caller
+func (conn *Conn) writeFrame(payloadType byte, payloads ...[]byte) (int, error) {
+	return conn.writeFrameHeader(tcpFrameHeader{Fin: true, OpCode: payloadType}, payloads...)
+}
+
+// writeFrameHeader writes payloads as a frame with Fin, Rsv and OpCode
+// of the header, wio must be held by the caller
+func (conn *Conn) writeFrameHeader(header tcpFrameHeader, payloads ...[]byte) (int, error) {
+	if conn.closeSent.Load() {
+		return 0, ErrConnClosed
+	}
+
+	length := 0
+	for _, payload := range payloads {
+		length += len(payload)
+	}
+
+	// control frames do not consume the write credit
+	payloadType := header.OpCode
+	if payloadType < CloseFrame {
+		if err := conn.credit.acquire(int64(length)); err != nil {
+			return 0, err
+		}
+	}
+
+	if conn.writeWatchdog > 0 && conn.onWriteStuck != nil {
+		watchdog := conn.clock.AfterFunc(conn.writeWatchdog, conn.onWriteStuck)
+		defer watchdog.Stop()
+	}
+
+	w, err := conn.frameWriterFactory.NewFrameWriter(payloadType)
+	if err != nil {
+		if payloadType < CloseFrame {
+			conn.credit.release(int64(length))
+		}
+		return 0, err
+	}
+	defer w.Close()
+
+	if fw, ok := w.(*tcpFrameWriter); ok {
+		fw.header.Fin = header.Fin
+		fw.header.Rsv = header.Rsv
+	}
+
+	// control frames are always flushed
+	if (conn.DisableAutoFlush || conn.FlushInterval > 0) && payloadType < CloseFrame {
+		if fw, ok := w.(*tcpFrameWriter); ok {
+			fw.noFlush = true
+		}
+
+		if conn.FlushInterval > 0 && conn.flushTimer == nil {
+			conn.flushTimer = conn.clock.AfterFunc(conn.FlushInterval, conn.flushBuffered)
+		}
+	}
+
+	var n int
+	conn.frameOverhead = 0
+	if fw, ok := w.(*tcpFrameWriter); ok {
+		if fw.header.MaskingKey != nil {
+			conn.maskedFramesWritten.Add(1)
+		}
+
+		n, err = fw.writev(payloads...)
+		conn.frameOverhead = fw.overhead
+	} else {
+		n, err = w.Write(bytes.Join(payloads, nil))
+	}
+
+	if err != nil {
+		if payloadType < CloseFrame {
+			conn.credit.release(int64(length))
+		}
+		conn.log().Debug("frame write failed", "opcode", payloadType, "length", length, "error", err)
+		return n, err
+	}
+
+	if conn.debugEnabled() {
+		conn.log().Debug("frame written", "opcode", payloadType, "fin", header.Fin, "length", length)
+	}
+	if fn := conn.frameLogger.Load(); fn != nil {
+		conn.logFrameWrittenTo(*fn, header, w, length, payloads)
+	}
+	conn.stats.framesWritten.Add(1)
+	conn.stats.bytesWritten.Add(int64(length))
+	conn.metrics.IncFramesWritten()
+	conn.metrics.IncBytesWritten(int64(length))
+	return n, nil
+}
+
+// SetWriteWatchdog sets callback cb called if any single write of a frame
+// takes longer than d, e.g. because of blocked peer. The write is not
+// aborted. If d <= 0 or cb is nil the watchdog is disabled
+func (conn *Conn) SetWriteWatchdog(d time.Duration, cb func()) {
+	conn.wio.Lock()
+	defer conn.wio.Unlock()
+
+	conn.writeWatchdog = d
+	conn.onWriteStuck = cb
+}
+
+// Flush writes buffered frames to the connection
+func (conn *Conn) Flush() error {
+	conn.wio.Lock()
+	defer conn.wio.Unlock()
+
+	if conn.closeSent.Load() {
+		return ErrConnClosed
+	}
+
+	return conn.buf.Flush()
+}
+
+// flushBuffered flushes frames buffered by Write with FlushInterval
+func (conn *Conn) flushBuffered() {
+	conn.wio.Lock()
+	defer conn.wio.Unlock()
+
+	conn.flushTimer = nil
+	if err := conn.buf.Flush(); err != nil {
+		conn.log().Debug("flush of buffered frames failed", "error", err)
+	}
+}
+
+// Close implements io.Closer interface
+// send close frame and close rwc, after that methods of the connection
+// return ErrConnClosed
+func (conn *Conn) Close() error {
+	// writes blocked by the write credit hold wio
+	conn.credit.close()
+
+	conn.wio.Lock()
+	if conn.closeSent.Load() {
+		conn.wio.Unlock()
+		return ErrConnClosed
+	}
+	conn.stopFlushTimer()
+
+	// close frame is flushed with all buffered frames
+	err := conn.frameHandler.WriteClose(conn.frameWriterFactory, conn.defaultCloseStatus)
+	conn.closeSent.Store(true)
+	conn.log().Debug("close frame sent", "status", conn.defaultCloseStatus, "error", err)
+	conn.markClosed()
+	conn.wio.Unlock()
+
+	err1 := conn.rwc.Close()
+	if err != nil {
+		return err
+	}
+
+	return err1
+}
+
+// FlushAndClose flushes buffered frames, sends close frame with status and
+// reason and closes rwc, so all frames written before, e.g. with
+// DisableAutoFlush, are delivered before the close frame. Unlike Close it
+// returns error of flushing, then rwc is closed without close frame
+func (conn *Conn) FlushAndClose(status int, reason string) error {
+	if err := conn.Flush(); err != nil {
+		if err == ErrConnClosed {
+			return err
+		}
+
+		return errors.Join(err, conn.closeWithoutHandshake())
+	}
+
+	return conn.CloseWithStatus(status, reason)
+}
+
+// closeWithoutHandshake closes rwc without close frame, e.g. when the read
+// stream can not be continued, after that methods of the connection
+// return ErrConnClosed. It does not take rio and wio, so it may be called
+// while reading
+func (conn *Conn) closeWithoutHandshake() error {
+	// writes blocked by the write credit hold wio
+	conn.credit.close()
+
+	if conn.closeSent.Swap(true) {
+		return ErrConnClosed
+	}
+
+	conn.markClosed()
+	return conn.rwc.Close()
+}
+
+// CloseWithStatus sends close frame with status and reason and close rwc,
+// if len of status with reason is greater than 125 bytes the reason is
+// truncated with TruncateCloseReason, otherwise return ErrControlFrameTooLarge
+// without closing the connection
+func (conn *Conn) CloseWithStatus(status int, reason string) error {
+	err := conn.writeClose(status, reason)
+	if err == ErrControlFrameTooLarge || err == ErrConnClosed {
+		return err
+	}
+
+	conn.markClosed()
+	err1 := conn.rwc.Close()
+	if err != nil {
+		return err
+	}
+
+	return err1
+}
+
+// CloseWithReason sends close frame with status and reason and close rwc
+// like CloseWithStatus, but too long reason is always truncated on UTF-8
+// boundary to fit the close frame. The peer reads them by CloseStatus
+func (conn *Conn) CloseWithReason(status int, reason string) error {
+	return conn.CloseWithStatus(status, truncateCloseReason(reason))
+}
+
+// WriteCloseAndWait sends close frame with status and reason, reads frames
+// until close frame of the peer or timeout and close rwc.
+// It returns status and reason of close frame of the peer
+func (conn *Conn) WriteCloseAndWait(status int, reason string, timeout time.Duration) (int, string, error) {
+	if err := conn.writeClose(status, reason); err != nil {
+		return 0, "", err
+	}
+
+	peerStatus, peerReason, err := conn.DrainUntilClose(time.Now().Add(timeout))
+
+	conn.markClosed()
+	err1 := conn.rwc.Close()
+	if err != nil {
+		return 0, "", err
+	}
+
+	return peerStatus, peerReason, err1
+}
+
+// CloseHandshake sends close frame with normal status, reads frames until
+// close frame of the peer or timeout and only then close rwc
+func (conn *Conn) CloseHandshake(timeout time.Duration) error {
+	_, _, err := conn.WriteCloseAndWait(closeStatusNormal, "", timeout)
+	return err
+}
+
+// writeClose writes close frame with status and reason, after that writes
+// return ErrConnClosed. If len of status with reason is greater than 125
+// bytes the reason is truncated with TruncateCloseReason, otherwise
+// return ErrControlFrameTooLarge without writing
+func (conn *Conn) writeClose(status int, reason string) error {
+	if 2+len(reason) > maxControlPayloadBytes {
+		if !conn.TruncateCloseReason {
+			return ErrControlFrameTooLarge
+		}
+		reason = truncateCloseReason(reason)
+	}
+
+	// writes blocked by the write credit hold wio
+	conn.credit.close()
+
+	conn.wio.Lock()
+	defer conn.wio.Unlock()
+
+	if conn.closeSent.Load() {
+		return ErrConnClosed
+	}
+	conn.stopFlushTimer()
+
+	_, err := conn.writeFrame(CloseFrame, closePayload(status, reason))
+	conn.closeSent.Store(true)
+	conn.log().Debug("close frame sent", "status", status, "reason", reason, "error", err)
+	return err
+}
+
+// SetErrorMapper sets mapper of errors to status and reason of close frame
+// sent by CloseWithError, if mapper returns 0 status the default
+// mapping is used
+func (conn *Conn) SetErrorMapper(mapper func(err error) (status int, reason string)) {
+	conn.wio.Lock()
+	defer conn.wio.Unlock()
+
+	conn.errorMapper = mapper
+}
+
+// CloseWithError sends close frame with status and reason mapped from err
+// and close rwc. Without error mapper errors of the package are mapped
+// to protocol statuses, nil to normal closure and other errors
+// to internal error with the error text as reason
+func (conn *Conn) CloseWithError(err error) error {
+	conn.wio.Lock()
+	mapper := conn.errorMapper
+	conn.wio.Unlock()
+
+	status, reason := 0, ""
+	if mapper != nil {
+		status, reason = mapper(err)
+	}
+
+	if status == 0 {
+		status, reason = defaultErrorStatus(err)
+	}
+
+	return conn.CloseWithStatus(status, truncateCloseReason(reason))
+}
+
+// defaultErrorStatus maps err to status and reason of close frame
+func defaultErrorStatus(err error) (int, string) {
+	switch {
+	case err == nil:
+		return closeStatusNormal, ""
+	case errors.Is(err, ErrFrameTooLarge):
+		return closeStatusTooBigData, err.Error()
+	case isProtocolError(err):
+		return closeStatusProtocolError, err.Error()
+	default:
+		return closeStatusInternalError, err.Error()
+	}
+}
+
+// isProtocolError reports whether err is caused by a frame
+// violating the protocol
+func isProtocolError(err error) bool {
+	return errors.Is(err, ErrBadPreambule) ||
+		errors.Is(err, ErrBadHeader) ||
+		errors.Is(err, ErrBadOpCode) ||
+		errors.Is(err, ErrUnexpectedFragment) ||
+		errors.Is(err, ErrMaskingPolicy) ||
+		errors.Is(err, ErrVersionMismatch) ||
+		errors.Is(err, ErrHeaderTooLarge) ||
+		errors.Is(err, ErrControlFrameTooLarge)
+}
+
+// isRejectedFrame reports whether err rejects only one frame, its payload
+// is discarded and the stream stays aligned on frame boundaries
+func isRejectedFrame(err error) bool {
+	return errors.Is(err, ErrFrameTooLarge) ||
+		errors.Is(err, ErrBadOpCode) ||
+		errors.Is(err, ErrUnexpectedFragment) ||
+		errors.Is(err, ErrMaskingPolicy)
+}
+
+// truncateCloseReason truncates reason on UTF-8 boundary to fit
+// into payload of close frame with status
+func truncateCloseReason(reason string) string {
+	const maxReasonBytes = maxControlPayloadBytes - 2
+	if len(reason) <= maxReasonBytes {
+		return reason
+	}
+
+	n := maxReasonBytes
+	for n > 0 && !utf8.RuneStart(reason[n]) {
+		n--
+	}
+
+	return reason[:n]
+}
+
+// stopFlushTimer stops timer of FlushInterval and reports frames which
+// are not flushed yet, they are flushed with the close frame,
+// wio must be held by the caller
+func (conn *Conn) stopFlushTimer() {
+	if conn.flushTimer != nil {
+		conn.flushTimer.Stop()
+		conn.flushTimer = nil
+	}
+
+	if conn.buf.Writer.Buffered() > 0 {
+		conn.reportError(ErrUnflushedOnClose)
+	}
+}
+
+// reportError calls ErrorHook with err if it is set
+func (conn *Conn) reportError(err error) {
+	conn.log().Debug("connection error", "error", err)
+	if conn.ErrorHook != nil {
+		conn.ErrorHook(err)
+	}
+}
+
+// LocalAddr return local address, if known
+func (conn *Conn) LocalAddr() net.Addr {
+	if c, ok := conn.rwc.(net.Conn); ok {
+		return c.LocalAddr()
+	}
+
+	return nil
+}
+
+// RemoteAddr return remote address, if known
+func (conn *Conn) RemoteAddr() net.Addr {
+	if c, ok := conn.rwc.(net.Conn); ok {
+		return c.RemoteAddr()
+	}
+
+	return nil
+}
+
+// SetReadLimitBps limits bytes per second read from the connection,
+// if n <= 0 there is no limit
+func (conn *Conn) SetReadLimitBps(n int64) {
+	conn.readLimiter.setRate(n)
+}
+
+// SetWriteLimitBps limits bytes per second written to the connection,
+// if n <= 0 there is no limit
+func (conn *Conn) SetWriteLimitBps(n int64) {
+	conn.writeLimiter.setRate(n)
+}
+
+// SetResync enables resynchronization of the stream on bad preambule,
+// the reader skips bytes until the next preambule followed by a plausible
+// header instead of returning ErrBadPreambule
+func (conn *Conn) SetResync(enable bool) {
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	if factory, ok := conn.frameReaderFactory.(*tcpFrameReaderFactory); ok {
+		factory.resync = enable
+	}
+}
+
+// SetMaxFrameHeaderBytes limits len of preambule with header of read frames,
+// frames with greater header are rejected with ErrHeaderTooLarge and
+// the connection must be closed. If n <= 0 the header is limited only
+// by the format, that is maxHeaderLengthWithPreambule for default preambule
+func (conn *Conn) SetMaxFrameHeaderBytes(n int) {
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	if factory, ok := conn.frameReaderFactory.(*tcpFrameReaderFactory); ok {
+		factory.maxHeaderBytes = n
+	}
+}
+
+// EnableSequencing enables sequence numbers of frames: each written frame
+// carries incrementing sequence number after the header and each read frame
+// is checked to carry the next one, otherwise the read returns
+// *SequenceGapError. It is an extension of the wire format, so both peers
+// must enable it before the first frame
+func (conn *Conn) EnableSequencing() {
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+	conn.wio.Lock()
+	defer conn.wio.Unlock()
+
+	if factory, ok := conn.frameReaderFactory.(*tcpFrameReaderFactory); ok {
+		factory.sequencing = true
+	}
+
+	if factory, ok := conn.frameWriterFactory.(*tcpFrameWriterFactory); ok {
+		factory.sequence = &conn.writeSeq
+	}
+}
+
+// SequenceGapError returns by reads if sequence number of a read frame
+// is not the next one, it wraps ErrSequenceGap. Reading may be continued,
+// sequence numbers are expected to follow the received one
+type SequenceGapError struct {
+	Expected uint32
+	Received uint32
+}
+
+func (e *SequenceGapError) Error() string {
+	return fmt.Sprintf("error sequence gap, expected %d, received %d", e.Expected, e.Received)
+}
+
+func (e *SequenceGapError) Unwrap() error {
+	return ErrSequenceGap
+}
+
+// SetReadBufferReuse enables reuse of the payload reader between frames
+// to avoid its allocation on each frame
+func (conn *Conn) SetReadBufferReuse(enable bool) {
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	factory, ok := conn.frameReaderFactory.(*tcpFrameReaderFactory)
+	if !ok {
+		return
+	}
+
+	factory.limited = nil
+	if enable {
+		factory.limited = &io.LimitedReader{R: factory.payloadReader()}
+	}
+}
+
+// SetReadPreambule sets preambule expected at the start of each read frame,
+// if p is nil the default preambule is used
+func (conn *Conn) SetReadPreambule(p []byte) {
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	if factory, ok := conn.frameReaderFactory.(*tcpFrameReaderFactory); ok {
+		factory.preambule = clonePreambule(p)
+	}
+}
+
+// SetWritePreambule sets preambule written at the start of each frame,
+// if p is nil the default preambule is used
+func (conn *Conn) SetWritePreambule(p []byte) {
+	conn.wio.Lock()
+	defer conn.wio.Unlock()
+
+	if factory, ok := conn.frameWriterFactory.(*tcpFrameWriterFactory); ok {
+		factory.preambule = clonePreambule(p)
+	}
+}
+
+func clonePreambule(p []byte) []byte {
+	if p == nil {
+		return nil
+	}
+
+	return append([]byte{}, p...)
+}
+
+// SetWriteMasking enables masking of written frames,
+// it is independent of ReadExpectMasked policy of read frames
+func (conn *Conn) SetWriteMasking(enable bool) {
+	conn.wio.Lock()
+	defer conn.wio.Unlock()
+
+	if factory, ok := conn.frameWriterFactory.(*tcpFrameWriterFactory); ok {
+		factory.needMaskingKey = enable
+	}
+}
+
+// DisableMasking disables masking of written frames regardless of role of
+// the connection and accepts unmasked frames on read. It is for trusted
+// links where masking costs CPU without adding security
+func (conn *Conn) DisableMasking() {
+	conn.SetWriteMasking(false)
+
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	if conn.ReadExpectMasked == MaskingRequired {
+		conn.ReadExpectMasked = MaskingAny
+	}
+}
+
+// SetVerifyMasking enables verification that unmasking offset of each read
+// masked frame advanced exactly by length of its payload, on mismatch
+// ErrorHook is called with ErrMaskingMismatch
+func (conn *Conn) SetVerifyMasking(enable bool) {
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	factory, ok := conn.frameReaderFactory.(*tcpFrameReaderFactory)
+	if !ok {
+		return
+	}
+
+	factory.onMaskingMismatch = nil
+	if enable {
+		factory.onMaskingMismatch = conn.reportError
+	}
+}
+
+// MaskedFramesRead returns number of read masked frames
+func (conn *Conn) MaskedFramesRead() int64 {
+	return conn.maskedFramesRead.Load()
+}
+
+// LastMaskingKey returns copy of masking key of the last read frame
+// and whether the frame was masked
+func (conn *Conn) LastMaskingKey() ([]byte, bool) {
+	conn.rio.Lock()
+	defer conn.rio.Unlock()
+
+	if conn.lastMaskingKey == nil {
+		return nil, false
+	}
+
+	return append([]byte{}, conn.lastMaskingKey...), true
+}
+
+// MaskedFramesWritten returns number of written masked frames
+func (conn *Conn) MaskedFramesWritten() int64 {
+	return conn.maskedFramesWritten.Load()
+}
+
+// ErrBadFrameSizeHint returns by SetFrameSizeHint if the hint is not
+// positive or greater than MaxPayloadBytes
+var ErrBadFrameSizeHint = errors.New("error bad frame size hint")
+
+var errSetDeadline = errors.New("conn: cannot set deadline: not using new.Conn")
+
+// ErrAssemblyTimeout returns when fragmented message is not finished
+// within FragmentAssemblyTimeout
+var ErrAssemblyTimeout = errors.New("error fragmented message assembly timeout")
+
+// ErrConnClosed returns by methods of the connection after Close
+var ErrConnClosed = errors.New("error use of closed connection")
+
+// ErrUnflushedOnClose reports to ErrorHook when there are buffered but
+// not flushed frames on close, they are flushed before closing
+var ErrUnflushedOnClose = errors.New("error unflushed data on close")
+
+// SetDeadlineHook sets hook called with deadline by SetDeadline,
+// SetReadDeadline and SetWriteDeadline instead of setting the deadline
+// of net.Conn, so deadlines are usable over custom transports.
+// If hook is nil the deadline of net.Conn is set
+func (conn *Conn) SetDeadlineHook(hook func(t time.Time) error) {
+	if hook == nil {
+		conn.deadlineHook.Store(nil)
+		return
+	}
+
+	conn.deadlineHook.Store(&hook)
+}
+
+// SetDeadline sets connection's read & write deadline
+func (conn *Conn) SetDeadline(t time.Time) error {
+	conn.deadlineMu.Lock()
+	conn.readDeadline, conn.writeDeadline = t, t
+	conn.deadlineMu.Unlock()
+
+	if hook := conn.deadlineHook.Load(); hook != nil {
+		return (*hook)(t)
+	}
+
+	if c, ok := conn.rwc.(net.Conn); ok {
+		return c.SetDeadline(t)
+	}
+
+	return errSetDeadline
+}
+
+// SetDeadline sets connection read deadline
+func (conn *Conn) SetReadDeadline(t time.Time) error {
+	conn.deadlineMu.Lock()
+	conn.readDeadline = t
+	conn.deadlineMu.Unlock()
+
+	return conn.setReadDeadline(t)
+}
+
+// SetDeadline sets connection write deadline
+func (conn *Conn) SetWriteDeadline(t time.Time) error {
+	conn.deadlineMu.Lock()
+	conn.writeDeadline = t
+	conn.deadlineMu.Unlock()
+
+	return conn.setWriteDeadline(t)
+}
+
+// setReadDeadline sets read deadline of rwc without keeping it,
+// deadline of the caller is restored by restoreReadDeadline
+func (conn *Conn) setReadDeadline(t time.Time) error {
+	if hook := conn.deadlineHook.Load(); hook != nil {
+		return (*hook)(t)
+	}
+
+	if c, ok := conn.rwc.(net.Conn); ok {
+		return c.SetReadDeadline(t)
+	}
+
+	return errSetDeadline
+}
+
+// setWriteDeadline sets write deadline of rwc without keeping it,
+// deadline of the caller is restored by restoreWriteDeadline
+func (conn *Conn) setWriteDeadline(t time.Time) error {
+	if hook := conn.deadlineHook.Load(); hook != nil {
+		return (*hook)(t)
+	}
+
+	if c, ok := conn.rwc.(net.Conn); ok {
+		return c.SetWriteDeadline(t)
+	}
+
+	return errSetDeadline
+}
+
+// restoreReadDeadline restores read deadline set by the caller
+// after internal deadline
+func (conn *Conn) restoreReadDeadline() {
+	conn.deadlineMu.Lock()
+	t := conn.readDeadline
+	conn.deadlineMu.Unlock()
+
+	if err := conn.setReadDeadline(t); err != nil {
+		conn.log().Debug("restore of read deadline failed", "error", err)
+	}
+}
+
+// restoreWriteDeadline restores write deadline set by the caller
+// after internal deadline
+func (conn *Conn) restoreWriteDeadline() {
+	conn.deadlineMu.Lock()
+	t := conn.writeDeadline
+	conn.deadlineMu.Unlock()
+
+	if err := conn.setWriteDeadline(t); err != nil {
+		conn.log().Debug("restore of write deadline failed", "error", err)
+	}
 }
@@ -1,13 +1,22 @@
 package gotcpws
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	cryptorand "crypto/rand"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	rand "math/rand"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -50,14 +59,15 @@ func TestConnReadWriteClose(t *testing.T) {
 			// Write message
 			nw, err := conn.Write(genData[:nr])
 			t.Run(
-				fmt.Sprintf("check write to connection from %d to %d message", i, i+nw),
+				fmt.Sprintf("check write to connection from %d to %d message", i, connBuffer.Len()),
 				func(t *testing.T) {
 					assert.Equal(t, nil, err, "should not be error to write")
+					assert.Equal(t, nr, nw, "should write all payload")
 				})
 
-			// append data to check read after
+			// append data to check read after, i is len of frames on the wire
 			want = append(want, genData[:nr]...)
-			i += nw
+			i = connBuffer.Len()
 		}
 	})
 
@@ -90,11 +100,27 @@ func TestConnReadWriteClose(t *testing.T) {
 	t.Run("check close connection", func(t *testing.T) {
 		buf := make([]byte, 16)
 
-		conn.Close()
+		assert.Equal(t, nil, conn.Close(), "should not be error to close connection")
+
 		_, err := conn.Read(buf)
-		if assert.Error(t, err, "should error read on close connection") {
-			assert.Equal(t, io.EOF, err, "should EOF error read on close connection")
-		}
+		assert.Equal(t, ErrConnClosed, err, "should be ErrConnClosed error to read")
+
+		_, err = conn.ReadFrame()
+		assert.Equal(t, ErrConnClosed, err, "should be ErrConnClosed error to read frame")
+
+		_, err = conn.Write(buf)
+		assert.Equal(t, ErrConnClosed, err, "should be ErrConnClosed error to write")
+
+		_, err = conn.WriteFragmented(BinaryFrame, buf, 4)
+		assert.Equal(t, ErrConnClosed, err, "should be ErrConnClosed error to write fragmented")
+
+		assert.Equal(t, ErrConnClosed, conn.Flush(), "should be ErrConnClosed error to flush")
+		assert.Equal(t, ErrConnClosed, conn.Close(), "should be ErrConnClosed error to close again")
+	})
+
+	t.Run("check close frame is sent", func(t *testing.T) {
+		_, err := NewFrameConnection(connBuffer, nil, nil, 0, false).ReadFrame()
+		assert.Equal(t, io.EOF, err, "should read close frame by peer")
 	})
 }
 
@@ -126,11 +152,11 @@ func TestConnReadFrame(t *testing.T) {
 		nr, _ := cryptorand.Read(genData)
 
 		// Write message
-		nw, _ := conn.Write(genData[:nr])
+		_, _ = conn.Write(genData[:nr])
 
-		// append data to check read after
+		// append data to check read after, i is len of frames on the wire
 		want = append(want, genData[:nr])
-		i += nw
+		i = connBuffer.Len()
 	}
 
 	j := 0
@@ -185,3 +211,3068 @@ func TestSetDeadline(t *testing.T) {
 		assert.Equal(t, errSetDeadline, err, "should be error to set deadline")
 	})
 }
+
+func TestConnReadFramePooled(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+
+	handler := &tcpFrameHandler{}
+	conn := NewFrameConnection(connBuffer, nil, handler, 0, true)
+
+	lengths := []int{1024, 10, 4096, 0, 125, 2048}
+	var want [][]byte
+	for _, length := range lengths {
+		genData := make([]byte, length)
+		_, _ = cryptorand.Read(genData)
+
+		_, err := conn.Write(genData)
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		want = append(want, genData)
+	}
+
+	for i := range want {
+		t.Run(fmt.Sprintf("check pooled frame %d with length %d", i, len(want[i])), func(t *testing.T) {
+			got, release, err := conn.ReadFramePooled()
+			if !assert.Equal(t, nil, err, "should not be error to read pooled frame") {
+				return
+			}
+
+			assert.Equal(t, len(want[i]), len(got), "should be equal lengths")
+			assert.True(t, bytes.Equal(want[i], got), "should be equal messages")
+			release()
+		})
+	}
+
+	t.Run("check repeated release", func(t *testing.T) {
+		buf := getPooledFrame()
+		buf.release()
+		buf.release()
+
+		first, second := getPooledFrame(), getPooledFrame()
+		assert.NotSame(t, first, second, "should put buffer to pool once")
+		first.release()
+		second.release()
+	})
+
+	t.Run("check err frame too large", func(t *testing.T) {
+		conn.MaxPayloadBytes = 10
+
+		msg := make([]byte, 12)
+		_, _ = cryptorand.Read(msg)
+		_, _ = conn.Write(msg)
+
+		_, release, err := conn.ReadFramePooled()
+		assert.Equal(t, ErrFrameTooLarge, err, "should be ErrFrameTooLarge error")
+		assert.Nil(t, release, "should not return release function on error")
+	})
+
+	t.Run("check read does not allocate", func(t *testing.T) {
+		if raceEnabled {
+			t.Skip("sync.Pool drops items with the race detector")
+		}
+
+		connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+		frame := []byte{0x5A, 0xA5, 0x5A, 0xA5, 0x82, 0x84, 1, 2, 3, 4, 'a' ^ 1, 'b' ^ 2, 'c' ^ 3, 'd' ^ 4}
+
+		var got []byte
+		allocs := testing.AllocsPerRun(100, func() {
+			connBuffer.Write(frame)
+			data, release, err := conn.ReadFramePooled()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got[:0], data...)
+			release()
+		})
+		assert.Equal(t, float64(0), allocs, "should not allocate")
+		assert.Equal(t, []byte("abcd"), got, "should unmask payload")
+	})
+}
+
+func TestConnReadFrameBuffer(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, true)
+
+	lengths := []int{4096, 10, 1024, 0, 125}
+	var want [][]byte
+	for _, length := range lengths {
+		genData := make([]byte, length)
+		_, _ = cryptorand.Read(genData)
+
+		_, err := conn.Write(genData)
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		want = append(want, genData)
+	}
+
+	var buf bytes.Buffer
+	for i := range want {
+		t.Run(fmt.Sprintf("check frame %d with length %d", i, len(want[i])), func(t *testing.T) {
+			err := conn.ReadFrameBuffer(&buf)
+			assert.Equal(t, nil, err, "should not be error to read frame into buffer")
+			assert.Equal(t, len(want[i]), buf.Len(), "should be equal lengths")
+			assert.True(t, bytes.Equal(want[i], buf.Bytes()), "should be equal messages")
+		})
+	}
+
+	t.Run("check buffer capacity is reused", func(t *testing.T) {
+		capacity := buf.Cap()
+
+		_, err := conn.Write([]byte("small"))
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		err = conn.ReadFrameBuffer(&buf)
+		assert.Equal(t, nil, err, "should not be error to read frame into buffer")
+		assert.Equal(t, "small", buf.String(), "should not leak data of previous frames")
+		assert.Equal(t, capacity, buf.Cap(), "should reuse capacity of buffer")
+	})
+
+	t.Run("check err frame too large", func(t *testing.T) {
+		conn.MaxPayloadBytes = 10
+
+		_, _ = conn.Write(make([]byte, 12))
+
+		err := conn.ReadFrameBuffer(&buf)
+		assert.Equal(t, ErrFrameTooLarge, err, "should be ErrFrameTooLarge error")
+		assert.Equal(t, 0, buf.Len(), "should not write too large frame into buffer")
+	})
+}
+
+// repeatConn reads the same frame over and over again and discards writes
+type repeatConn struct {
+	frame []byte
+	pos   int
+}
+
+func (c *repeatConn) Read(p []byte) (int, error) {
+	n := copy(p, c.frame[c.pos:])
+	c.pos = (c.pos + n) % len(c.frame)
+	return n, nil
+}
+
+func (c *repeatConn) Write(p []byte) (int, error) { return len(p), nil }
+
+func (c *repeatConn) Close() error { return nil }
+
+func newBenchmarkConn(b *testing.B, length int) *Conn {
+	b.Helper()
+
+	buf := bytes.NewBuffer(nil)
+	msg := make([]byte, length)
+	_, _ = cryptorand.Read(msg)
+	_, err := NewFrameConnection(testConn{Buffer: buf}, nil, nil, 0, false).Write(msg)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return NewFrameConnection(&repeatConn{frame: buf.Bytes()}, nil, nil, 0, false)
+}
+
+func BenchmarkConnReadFrame(b *testing.B) {
+	conn := newBenchmarkConn(b, 4096)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.ReadFrame(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkConnReadFramePooled(b *testing.B) {
+	conn := newBenchmarkConn(b, 4096)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, release, err := conn.ReadFramePooled()
+		if err != nil {
+			b.Fatal(err)
+		}
+		release()
+	}
+	b.StopTimer()
+	if raceEnabled {
+		return
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_, release, _ := conn.ReadFramePooled()
+		release()
+	})
+	if allocs != 0 {
+		b.Fatalf("should not allocate, got %v allocs", allocs)
+	}
+}
+
+// writeTestFrame writes a frame with the given opcode and fin bit to w
+func writeTestFrame(t *testing.T, w *bufio.Writer, opCode byte, fin bool, payload []byte) {
+	t.Helper()
+
+	writer := &tcpFrameWriter{
+		writer: w,
+		header: &tcpFrameHeader{Fin: fin, OpCode: opCode},
+	}
+
+	_, err := writer.Write(payload)
+	assert.Equal(t, nil, err, "should not be error to write test frame")
+}
+
+func TestConnReset(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+
+	handler := &tcpFrameHandler{}
+	conn := NewFrameConnection(connBuffer, nil, handler, 0, false)
+
+	bw := bufio.NewWriter(connBuffer)
+	writeTestFrame(t, bw, TextFrame, false, []byte("he"))
+	writeTestFrame(t, bw, BinaryFrame, true, []byte("bad"))
+	writeTestFrame(t, bw, ContinuationFrame, true, []byte("llo"))
+	writeTestFrame(t, bw, TextFrame, true, []byte("ok"))
+
+	_, _, got, err := conn.ReadFrameRaw()
+	assert.Equal(t, nil, err, "should not be error to read first fragment")
+	assert.Equal(t, []byte("he"), got, "should be equal first fragment")
+
+	_, err = conn.ReadFrame()
+	assert.Equal(t, ErrUnexpectedFragment, err, "should be error on new message mid fragmentation")
+
+	assert.Equal(t, nil, conn.Reset(), "should not be error to reset connection")
+	assert.False(t, handler.fragmented, "should drop fragmentation state on reset")
+
+	_, err = conn.ReadFrame()
+	assert.Equal(
+		t,
+		ErrUnexpectedFragment,
+		err,
+		"should be error on continuation after reset",
+	)
+
+	got, err = conn.ReadFrame()
+	assert.Equal(t, nil, err, "should not be error to read frame after reset")
+	assert.Equal(t, []byte("ok"), got, "should be equal message after reset")
+	assert.Equal(t, byte(TextFrame), handler.payloadType, "should be text payload type")
+}
+
+func TestConnFragmentOrdering(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+
+	bw := bufio.NewWriter(connBuffer)
+	writeTestFrame(t, bw, TextFrame, false, []byte("a1"))
+	writeTestFrame(t, bw, BinaryFrame, true, []byte("interleaved"))
+	writeTestFrame(t, bw, ContinuationFrame, false, []byte("a2"))
+	writeTestFrame(t, bw, CloseFrame, false, closePayload(closeStatusNormal, ""))
+	writeTestFrame(t, bw, ContinuationFrame, true, []byte("a3"))
+	writeTestFrame(t, bw, BinaryFrame, true, []byte("b"))
+
+	tests := []struct {
+		payloadType byte
+		fin         bool
+		payload     string
+		err         error
+	}{
+		{TextFrame, false, "a1", nil},
+		{0, false, "", ErrUnexpectedFragment},
+		{TextFrame, false, "a2", nil},
+		{0, false, "", ErrUnexpectedFragment},
+		{TextFrame, true, "a3", nil},
+		{BinaryFrame, true, "b", nil},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("check frame %d", i), func(t *testing.T) {
+			payloadType, fin, got, err := conn.ReadFrameRaw()
+			assert.Equal(t, tt.err, err, "should be equal errors")
+			if tt.err != nil {
+				return
+			}
+
+			assert.Equal(t, tt.payloadType, payloadType, "should be payload type of the message")
+			assert.Equal(t, tt.fin, fin, "should be equal fin bits")
+			assert.Equal(t, []byte(tt.payload), got, "should be fragments in order")
+		})
+	}
+}
+
+func TestConnLenientOpcodes(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+	bw := bufio.NewWriter(connBuffer)
+
+	t.Run("check strict opcodes", func(t *testing.T) {
+		writeTestFrame(t, bw, 3, true, []byte("legacy"))
+
+		_, err := conn.ReadFrame()
+		assert.Equal(t, ErrBadOpCode, err, "should be error on reserved opcode")
+	})
+
+	t.Run("check lenient opcodes", func(t *testing.T) {
+		conn.LenientOpcodes = true
+		writeTestFrame(t, bw, 3, true, []byte("legacy"))
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error on reserved opcode in lenient mode")
+		assert.Equal(t, []byte("legacy"), got, "should be equal messages")
+	})
+
+	t.Run("check lenient opcodes do not affect control frames", func(t *testing.T) {
+		writeTestFrame(t, bw, 11, true, []byte("control"))
+
+		_, err := conn.ReadFrame()
+		assert.Equal(t, ErrBadOpCode, err, "should be error on reserved control opcode")
+	})
+
+	t.Run("check custom opcode is not rejected", func(t *testing.T) {
+		connBuffer := testConn{
+			Buffer: bytes.NewBuffer(nil),
+		}
+
+		conn := NewFrameConnection(connBuffer, nil, NewTranslatingHandler(map[byte]byte{TextFrame: 0x20}), 0, false)
+		writeTestFrame(t, bufio.NewWriter(connBuffer), TextFrame, true, []byte("custom"))
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error on custom opcode")
+		assert.Equal(t, []byte("custom"), got, "should be equal messages")
+	})
+}
+
+func TestConnFlushInterval(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+
+	clk := newFakeClock()
+	conn.clock = clk
+	conn.FlushInterval = 100 * time.Millisecond
+
+	msgs := [][]byte{[]byte("first"), []byte("second"), []byte("third")}
+	total := 0
+	for _, msg := range msgs {
+		_, err := conn.Write(msg)
+		assert.Equal(t, nil, err, "should not be error to write")
+		total = conn.buf.Writer.Buffered()
+	}
+
+	t.Run("check frames are buffered before interval", func(t *testing.T) {
+		clk.Advance(50 * time.Millisecond)
+		assert.Equal(t, 0, connBuffer.Len(), "should not flush before interval")
+		assert.Equal(t, total, conn.buf.Writer.Buffered(), "should buffer all frames")
+	})
+
+	t.Run("check frames are flushed after interval", func(t *testing.T) {
+		clk.Advance(50 * time.Millisecond)
+		assert.Equal(t, total, connBuffer.Len(), "should flush all frames after interval")
+
+		for _, want := range msgs {
+			got, err := conn.ReadFrame()
+			assert.Equal(t, nil, err, "should not be error to read")
+			assert.Equal(t, want, got, "should be equal messages")
+		}
+	})
+
+	t.Run("check close stops timer", func(t *testing.T) {
+		_, err := conn.Write([]byte("last"))
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		timer := conn.flushTimer
+		assert.NotNil(t, timer, "should start flush timer")
+
+		assert.Equal(t, nil, conn.Close(), "should not be error to close")
+		assert.Nil(t, conn.flushTimer, "should drop flush timer on close")
+		assert.False(t, timer.Stop(), "should stop flush timer on close")
+
+		got, err := NewFrameConnection(connBuffer, nil, nil, 0, false).ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, []byte("last"), got, "should flush buffered frame on close")
+	})
+}
+
+func TestConnCloseWithoutHandler(t *testing.T) {
+	connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+
+	assert.NotPanics(t, func() {
+		assert.Equal(t, nil, conn.Close(), "should not be error to close connection")
+	}, "should not panic to close connection created without handler")
+
+	peer := NewFrameConnection(connBuffer, nil, nil, 0, false)
+	_, err := peer.ReadFrame()
+	assert.Equal(t, io.EOF, err, "should read close frame")
+
+	status, _, ok := peer.CloseStatus()
+	assert.True(t, ok, "should receive close frame")
+	assert.Equal(t, closeStatusNormal, status, "should be normal closure status")
+}
+
+func TestConnCloseWithStatus(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+
+	t.Run("check too long reason", func(t *testing.T) {
+		err := conn.CloseWithStatus(closeStatusNormal, string(make([]byte, 124)))
+		assert.Equal(t, ErrControlFrameTooLarge, err, "should be ErrControlFrameTooLarge error")
+		assert.Equal(t, 0, connBuffer.Len(), "should not write close frame")
+	})
+
+	t.Run("check truncated reason", func(t *testing.T) {
+		connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+		conn.TruncateCloseReason = true
+
+		// 2-byte runes, so the limit of 123 bytes is in the middle of a rune
+		reason := strings.Repeat("я", 100)
+		assert.Equal(t, 200, len(reason), "should be 200-byte reason")
+
+		err := conn.CloseWithStatus(closeStatusNormal, reason)
+		assert.Equal(t, nil, err, "should not be error to close")
+
+		_, got, err := NewFrameConnection(connBuffer, nil, nil, 0, false).DrainUntilClose(time.Time{})
+		assert.Equal(t, nil, err, "should not be error to read close frame")
+		assert.Equal(t, reason[:122], got, "should truncate reason on rune boundary")
+		assert.True(t, utf8.ValidString(got), "should be valid UTF-8 reason")
+	})
+
+	t.Run("check close frame", func(t *testing.T) {
+		err := conn.CloseWithStatus(closeStatusPolicyViolation, "bye")
+		assert.Equal(t, nil, err, "should not be error to close")
+
+		readerFactory := tcpFrameReaderFactory{Reader: bufio.NewReader(connBuffer)}
+		frame, err := readerFactory.NewFrameReader()
+		assert.Equal(t, nil, err, "should not be error to read close frame")
+		assert.Equal(t, byte(CloseFrame), frame.PayloadType(), "should be close frame")
+
+		got, _ := io.ReadAll(frame)
+		assert.Equal(t, closePayload(closeStatusPolicyViolation, "bye"), got, "should be equal payloads")
+	})
+}
+
+func TestConnMaxPayloadBytesForType(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+	conn := NewFrameConnection(connBuffer, nil, nil, 100, false)
+	conn.SetMaxPayloadBytesForType(TextFrame, 10)
+	conn.SetMaxPayloadBytesForType(BinaryFrame, 1000)
+
+	bw := bufio.NewWriter(connBuffer)
+	msg := make([]byte, 50)
+	_, _ = cryptorand.Read(msg)
+
+	t.Run("check text frame above text limit", func(t *testing.T) {
+		writeTestFrame(t, bw, TextFrame, true, msg)
+
+		_, err := conn.ReadFrame()
+		assert.Equal(t, ErrFrameTooLarge, err, "should be ErrFrameTooLarge error")
+	})
+
+	t.Run("check binary frame below binary limit", func(t *testing.T) {
+		writeTestFrame(t, bw, BinaryFrame, true, msg)
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read binary frame")
+		assert.Equal(t, msg, got, "should be equal messages")
+	})
+
+	t.Run("check fallback to global limit", func(t *testing.T) {
+		conn.SetMaxPayloadBytesForType(TextFrame, 0)
+		writeTestFrame(t, bw, TextFrame, true, msg)
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read text frame")
+		assert.Equal(t, msg, got, "should be equal messages")
+
+		writeTestFrame(t, bw, TextFrame, true, make([]byte, 101))
+		_, err = conn.ReadFrame()
+		assert.Equal(t, ErrFrameTooLarge, err, "should be ErrFrameTooLarge error")
+	})
+}
+
+func TestConnReadBufferReuse(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, true)
+	conn.SetReadBufferReuse(true)
+
+	var want [][]byte
+	for _, length := range []int{10, 0, 200, 70000, 5} {
+		msg := make([]byte, length)
+		_, _ = cryptorand.Read(msg)
+		_, _ = conn.Write(msg)
+		want = append(want, msg)
+	}
+
+	for i := range want {
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read frame %d", i)
+		assert.True(t, bytes.Equal(want[i], got), "should be equal messages of frame %d", i)
+	}
+}
+
+func TestConnReadFrameUnmaskInto(t *testing.T) {
+	connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, true)
+
+	t.Run("check masked message", func(t *testing.T) {
+		_, err := conn.Write([]byte("masked"))
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		dst := make([]byte, 16)
+		n, err := conn.ReadFrameUnmaskInto(dst)
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, []byte("masked"), dst[:n], "should unmask payload into dst")
+	})
+
+	t.Run("check fragmented message", func(t *testing.T) {
+		_, err := conn.WriteFragmented(BinaryFrame, []byte("masked fragments"), 5)
+		assert.Equal(t, nil, err, "should not be error to write fragmented")
+
+		dst := make([]byte, 16)
+		n, err := conn.ReadFrameUnmaskInto(dst)
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, []byte("masked fragments"), dst[:n], "should accumulate fragments")
+	})
+
+	t.Run("check short dst", func(t *testing.T) {
+		_, err := conn.Write([]byte("too long message"))
+		assert.Equal(t, nil, err, "should not be error to write")
+		_, err = conn.Write([]byte("next"))
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		var shortErr *ShortBufferError
+		_, err = conn.ReadFrameUnmaskInto(make([]byte, 4))
+		assert.True(t, errors.As(err, &shortErr), "should be ShortBufferError error")
+		assert.Equal(t, int64(len("too long message")), shortErr.Needed, "should report needed length")
+
+		dst := make([]byte, 4)
+		n, err := conn.ReadFrameUnmaskInto(dst)
+		assert.Equal(t, nil, err, "should not be error to read next message")
+		assert.Equal(t, []byte("next"), dst[:n], "should discard too long message")
+	})
+
+	t.Run("check read does not allocate", func(t *testing.T) {
+		dst := make([]byte, 16)
+		allocs := testing.AllocsPerRun(100, func() {
+			connBuffer.Write([]byte{0x5A, 0xA5, 0x5A, 0xA5, 0x82, 0x84, 1, 2, 3, 4, 'a' ^ 1, 'b' ^ 2, 'c' ^ 3, 'd' ^ 4})
+			if _, err := conn.ReadFrameUnmaskInto(dst); err != nil {
+				t.Fatal(err)
+			}
+		})
+		assert.Equal(t, float64(0), allocs, "should not allocate")
+		assert.Equal(t, []byte("abcd"), dst[:4], "should unmask payload into dst")
+	})
+}
+
+func BenchmarkConnReadFrameUnmaskInto(b *testing.B) {
+	buf := bytes.NewBuffer(nil)
+	msg := make([]byte, 4096)
+	_, _ = cryptorand.Read(msg)
+	_, err := NewFrameConnection(testConn{Buffer: buf}, nil, nil, 0, true).Write(msg)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	conn := NewFrameConnection(&repeatConn{frame: buf.Bytes()}, nil, nil, 0, false)
+	dst := make([]byte, len(msg))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.ReadFrameUnmaskInto(dst); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkConnReadSmallFrames(b *testing.B, reuse bool) {
+	conn := newBenchmarkConn(b, 16)
+	conn.SetReadBufferReuse(reuse)
+	buf := make([]byte, 16)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.Read(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkConnReadSmallFrames(b *testing.B) {
+	benchmarkConnReadSmallFrames(b, false)
+}
+
+func BenchmarkConnReadSmallFramesReuse(b *testing.B) {
+	benchmarkConnReadSmallFrames(b, true)
+}
+
+func TestConnWritev(t *testing.T) {
+	a := make([]byte, 3)
+	b := make([]byte, 70000)
+	_, _ = cryptorand.Read(a)
+	_, _ = cryptorand.Read(b)
+
+	t.Run("check writev frame equals write frame", func(t *testing.T) {
+		writevBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+		writeBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+
+		nv, err := NewFrameConnection(writevBuffer, nil, nil, 0, false).Writev(BinaryFrame, a, nil, b)
+		assert.Equal(t, nil, err, "should not be error to writev")
+
+		conn := NewFrameConnection(writeBuffer, nil, nil, 0, false)
+		conn.PayloadType = BinaryFrame
+		nw, err := conn.Write(append(append([]byte{}, a...), b...))
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		assert.Equal(t, len(a)+len(b), nw, "should count payload written by write")
+		assert.Equal(t, writevBuffer.Len(), nv, "should count frame written by writev")
+		assert.Equal(t, writeBuffer.Bytes(), writevBuffer.Bytes(), "should be equal frames")
+	})
+
+	t.Run("check masked writev", func(t *testing.T) {
+		connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, true)
+
+		_, err := conn.Writev(BinaryFrame, a, b, a)
+		assert.Equal(t, nil, err, "should not be error to writev")
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, append(append(append([]byte{}, a...), b...), a...), got, "should be equal messages")
+	})
+}
+
+func TestConnWritevFrame(t *testing.T) {
+	a := []byte("frag")
+	b := []byte("ment")
+
+	t.Run("check writev frame equals write frame", func(t *testing.T) {
+		writevBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+		writeBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+
+		nv, err := NewFrameConnection(writevBuffer, nil, nil, 0, false).WritevFrame(BinaryFrame, false, a, b)
+		assert.Equal(t, nil, err, "should not be error to writev frame")
+
+		nw, err := NewFrameConnection(writeBuffer, nil, nil, 0, false).WriteFrame(BinaryFrame, false, []byte("fragment"))
+		assert.Equal(t, nil, err, "should not be error to write frame")
+
+		assert.Equal(t, nw, nv, "should be equal written lengths")
+		assert.Equal(t, writeBuffer.Bytes(), writevBuffer.Bytes(), "should be equal frames")
+	})
+
+	t.Run("check masked fragments", func(t *testing.T) {
+		connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, true)
+
+		_, err := conn.WritevFrame(TextFrame, false, a, b)
+		assert.Equal(t, nil, err, "should not be error to writev frame")
+		_, err = conn.WritevFrame(ContinuationFrame, true, b, a)
+		assert.Equal(t, nil, err, "should not be error to writev frame")
+
+		tests := []struct {
+			fin     bool
+			payload string
+		}{
+			{false, "fragment"},
+			{true, "mentfrag"},
+		}
+
+		for _, tt := range tests {
+			payloadType, fin, got, err := conn.ReadFrameRaw()
+			assert.Equal(t, nil, err, "should not be error to read")
+			assert.Equal(t, byte(TextFrame), payloadType, "should be payload type of the message")
+			assert.Equal(t, tt.fin, fin, "should be equal fin bits")
+			assert.Equal(t, []byte(tt.payload), got, "should be equal payloads")
+		}
+	})
+}
+
+func TestConnDisableAutoFlush(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+
+	// tiny write buffer to flush only on overflow
+	buf := bufio.NewReadWriter(bufio.NewReader(connBuffer), bufio.NewWriterSize(connBuffer, 64))
+	conn := NewFrameConnection(connBuffer, buf, nil, 0, false)
+	conn.DisableAutoFlush = true
+
+	var hookErrs []error
+	conn.ErrorHook = func(err error) { hookErrs = append(hookErrs, err) }
+
+	reader := NewFrameConnection(connBuffer, nil, nil, 0, false)
+
+	t.Run("check flush", func(t *testing.T) {
+		_, err := conn.Write([]byte("flushed"))
+		assert.Equal(t, nil, err, "should not be error to write")
+		assert.Equal(t, 0, connBuffer.Len(), "should not flush in no-flush mode")
+
+		assert.Equal(t, nil, conn.Flush(), "should not be error to flush")
+
+		got, err := reader.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, []byte("flushed"), got, "should be equal messages")
+	})
+
+	t.Run("check close flushes data", func(t *testing.T) {
+		msgs := [][]byte{[]byte("first"), []byte("second")}
+		for _, msg := range msgs {
+			_, err := conn.Write(msg)
+			assert.Equal(t, nil, err, "should not be error to write")
+		}
+		assert.Equal(t, 0, connBuffer.Len(), "should not flush in no-flush mode")
+
+		assert.Equal(t, nil, conn.Close(), "should not be error to close")
+		assert.Equal(t, []error{ErrUnflushedOnClose}, hookErrs, "should report unflushed data")
+
+		for _, want := range msgs {
+			got, err := reader.ReadFrame()
+			assert.Equal(t, nil, err, "should not be error to read")
+			assert.Equal(t, want, got, "should be equal messages")
+		}
+
+		_, err := reader.ReadFrame()
+		assert.Equal(t, io.EOF, err, "should read close frame")
+	})
+}
+
+func TestConnReadFrameWithin(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	conn := NewFrameConnection(server, nil, nil, 0, false)
+	peer := NewFrameConnection(client, nil, nil, 0, false)
+
+	t.Run("check frame in time", func(t *testing.T) {
+		go func() { _, _ = peer.Write([]byte("in time")) }()
+
+		got, err := conn.ReadFrameWithin(time.Now().Add(time.Second))
+		assert.Equal(t, nil, err, "should not be error to read frame in time")
+		assert.Equal(t, []byte("in time"), got, "should be equal messages")
+	})
+
+	t.Run("check payload after deadline", func(t *testing.T) {
+		// header declares 10 bytes of payload, but only 2 of them arrive
+		go func() {
+			_, _ = client.Write(append(append([]byte{}, preambule...), 0x81, 0x0A, 'l', 'a'))
+		}()
+
+		_, err := conn.ReadFrameWithin(time.Now().Add(50 * time.Millisecond))
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded, "should be deadline error")
+	})
+
+	t.Run("check deadline is not set on connection", func(t *testing.T) {
+		conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, false)
+
+		_, err := conn.ReadFrameWithin(time.Now().Add(time.Second))
+		assert.Equal(t, errSetDeadline, err, "should be error to set deadline")
+	})
+}
+
+func TestConnReadFrameContext(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	conn := NewFrameConnection(server, nil, nil, 0, false)
+	peer := NewFrameConnection(client, nil, nil, 0, false)
+
+	t.Run("check cancel before frame", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := conn.ReadFrameContext(ctx)
+		assert.Equal(t, context.Canceled, err, "should be context error")
+	})
+
+	t.Run("check cancel between frames", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		_, err := conn.ReadFrameContext(ctx)
+		assert.Equal(t, context.DeadlineExceeded, err, "should be context error")
+
+		go func() { _, _ = peer.Write([]byte("after cancel")) }()
+
+		got, err := conn.ReadFrameContext(context.Background())
+		assert.Equal(t, nil, err, "should not be error to read frame after cancel")
+		assert.Equal(t, []byte("after cancel"), got, "should be equal messages")
+	})
+
+	t.Run("check cancel during frame", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go func() {
+			// header declares 10 bytes of payload, the rest arrives after cancel
+			_, _ = client.Write(append(append([]byte{}, preambule...), 0x81, 0x0A, 'p', 'a'))
+			cancel()
+			time.Sleep(20 * time.Millisecond)
+			_, _ = client.Write([]byte("rtial ok"))
+			_, _ = peer.Write([]byte("aligned"))
+		}()
+
+		got, err := conn.ReadFrameContext(ctx)
+		assert.Equal(t, nil, err, "should not be error to finish started frame")
+		assert.Equal(t, []byte("partial ok"), got, "should be equal messages")
+
+		got, err = conn.ReadFrameContext(context.Background())
+		assert.Equal(t, nil, err, "should not be error to read next frame")
+		assert.Equal(t, []byte("aligned"), got, "should be aligned on the next frame")
+	})
+
+	t.Run("check after close", func(t *testing.T) {
+		go func() { _, _ = peer.ReadFrame() }()
+		assert.Equal(t, nil, conn.Close(), "should not be error to close")
+
+		_, err := conn.ReadFrameContext(context.Background())
+		assert.Equal(t, ErrConnClosed, err, "should be ErrConnClosed error")
+	})
+}
+
+func TestConnCloseWithReason(t *testing.T) {
+	for _, reason := range []string{"maintenance", strings.Repeat("ü", 100)} {
+		t.Run(fmt.Sprintf("check reason of %d bytes", len(reason)), func(t *testing.T) {
+			connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+			conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+			assert.Equal(t, nil, conn.CloseWithReason(closeStatusGoingAway, reason), "should not be error to close")
+
+			peer := NewFrameConnection(connBuffer, nil, nil, 0, false)
+			_, err := peer.ReadFrame()
+			assert.Equal(t, io.EOF, err, "should read close frame")
+
+			status, got, received := peer.CloseStatus()
+			assert.Equal(t, true, received, "should be received close frame")
+			assert.Equal(t, closeStatusGoingAway, status, "should be equal statuses")
+			assert.True(t, strings.HasPrefix(reason, got), "should be prefix of the reason")
+			assert.True(t, utf8.ValidString(got), "should truncate reason on rune boundary")
+			assert.LessOrEqual(t, 2+len(got), maxControlPayloadBytes, "should fit close frame")
+		})
+	}
+}
+
+func TestConnCloseStatus(t *testing.T) {
+	t.Run("check empty close payload", func(t *testing.T) {
+		connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+		writeTestFrame(t, bufio.NewWriter(connBuffer), CloseFrame, true, nil)
+
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+		_, _, received := conn.CloseStatus()
+		assert.Equal(t, false, received, "should not be received close frame before read")
+
+		_, err := conn.ReadFrame()
+		assert.Equal(t, io.EOF, err, "should close cleanly on empty close frame")
+
+		status, reason, received := conn.CloseStatus()
+		assert.Equal(t, true, received, "should be received close frame")
+		assert.Equal(t, closeStatusNoStatusRcvd, status, "should be no status received")
+		assert.Equal(t, "", reason, "should be empty reason")
+	})
+
+	t.Run("check close payload", func(t *testing.T) {
+		connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+		writeTestFrame(t, bufio.NewWriter(connBuffer), CloseFrame, true, closePayload(closeStatusGoingAway, "bye"))
+
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+		_, err := conn.ReadFrame()
+		assert.Equal(t, io.EOF, err, "should close cleanly on close frame")
+
+		status, reason, received := conn.CloseStatus()
+		assert.Equal(t, true, received, "should be received close frame")
+		assert.Equal(t, closeStatusGoingAway, status, "should be status of the peer")
+		assert.Equal(t, "bye", reason, "should be reason of the peer")
+	})
+}
+
+func TestConnWritePrebuilt(t *testing.T) {
+	payload := []byte(strings.Repeat("broadcast ", 20))
+	frame := BuildFrame(BinaryFrame, payload)
+
+	t.Run("check frame is equal to written one", func(t *testing.T) {
+		connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+
+		_, err := conn.WriteFrame(BinaryFrame, true, payload)
+		assert.Equal(t, nil, err, "should not be error to write")
+		assert.Equal(t, connBuffer.Bytes(), frame, "should be equal frames")
+	})
+
+	t.Run("check broadcast", func(t *testing.T) {
+		const receivers = 3
+
+		var peers []*Conn
+		for i := 0; i < receivers; i++ {
+			server, client := net.Pipe()
+			defer server.Close()
+			defer client.Close()
+
+			conn := NewFrameConnection(server, nil, nil, 0, false)
+			go func() {
+				n, err := conn.WritePrebuilt(frame)
+				assert.Equal(t, nil, err, "should not be error to write prebuilt frame")
+				assert.Equal(t, len(frame), n, "should write whole frame")
+			}()
+			peers = append(peers, NewFrameConnection(client, nil, nil, 0, false))
+		}
+
+		for _, peer := range peers {
+			got, err := peer.ReadFrame()
+			assert.Equal(t, nil, err, "should not be error to read")
+			assert.Equal(t, payload, got, "should be equal messages")
+		}
+	})
+
+	t.Run("check closed connection", func(t *testing.T) {
+		conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, false)
+		assert.Equal(t, nil, conn.Close(), "should not be error to close")
+
+		_, err := conn.WritePrebuilt(frame)
+		assert.Equal(t, ErrConnClosed, err, "should be ErrConnClosed error")
+	})
+}
+
+func TestConnReadFrameInto(t *testing.T) {
+	t.Run("check single frame too big", func(t *testing.T) {
+		conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, true)
+		_, err := conn.Write([]byte("large message"))
+		assert.Equal(t, nil, err, "should not be error to write")
+		_, err = conn.Write([]byte("next"))
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		buf := make([]byte, 4)
+		_, err = conn.ReadFrameInto(buf)
+		assert.ErrorIs(t, err, io.ErrShortBuffer, "should be short buffer error")
+
+		var shortErr *ShortBufferError
+		if assert.ErrorAs(t, err, &shortErr, "should be ShortBufferError") {
+			assert.Equal(t, int64(len("large message")), shortErr.Needed, "should expose needed length")
+			buf = make([]byte, shortErr.Needed)
+		}
+
+		n, err := conn.ReadFrameInto(buf)
+		assert.Equal(t, nil, err, "should not be error to retry with larger buffer")
+		assert.Equal(t, []byte("large message"), buf[:n], "should read the message on retry")
+
+		n, err = conn.ReadFrameInto(buf)
+		assert.Equal(t, nil, err, "should not be error to read next message")
+		assert.Equal(t, []byte("next"), buf[:n], "should be equal messages")
+	})
+
+	t.Run("check fragmented message", func(t *testing.T) {
+		conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, false)
+		_, err := conn.WriteFragmented(BinaryFrame, []byte("fragmented message"), 5)
+		assert.Equal(t, nil, err, "should not be error to write")
+		_, err = conn.WriteFragmented(BinaryFrame, []byte("fits buffer"), 5)
+		assert.Equal(t, nil, err, "should not be error to write")
+		_, err = conn.Write([]byte("next"))
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		buf := make([]byte, 12)
+		_, err = conn.ReadFrameInto(buf)
+
+		var shortErr *ShortBufferError
+		if assert.ErrorAs(t, err, &shortErr, "should be ShortBufferError") {
+			assert.Equal(t, int64(len("fragmented message")), shortErr.Needed, "should expose length of the message")
+		}
+
+		n, err := conn.ReadFrameInto(buf)
+		assert.Equal(t, nil, err, "should not be error to read fragmented message")
+		assert.Equal(t, []byte("fits buffer"), buf[:n], "should accumulate fragments")
+
+		n, err = conn.ReadFrameInto(buf)
+		assert.Equal(t, nil, err, "should not be error to read next message")
+		assert.Equal(t, []byte("next"), buf[:n], "should be aligned on the next message")
+	})
+}
+
+func TestConnDeadlineHook(t *testing.T) {
+	conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, false)
+
+	var deadlines []time.Time
+	conn.SetDeadlineHook(func(t time.Time) error {
+		deadlines = append(deadlines, t)
+		return nil
+	})
+
+	t.Run("check deadline based read", func(t *testing.T) {
+		_, err := conn.Write([]byte("in time"))
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		deadline := time.Now().Add(time.Second)
+		got, err := conn.ReadFrameWithin(deadline)
+		assert.Equal(t, nil, err, "should not be error to read frame over custom transport")
+		assert.Equal(t, []byte("in time"), got, "should be equal messages")
+		assert.Equal(t, []time.Time{deadline, {}}, deadlines, "should set and clear deadline by hook")
+	})
+
+	t.Run("check all deadlines", func(t *testing.T) {
+		deadlines = nil
+		deadline := time.Now()
+
+		assert.Equal(t, nil, conn.SetDeadline(deadline), "should not be error to set deadline")
+		assert.Equal(t, nil, conn.SetWriteDeadline(deadline), "should not be error to set write deadline")
+		assert.Equal(t, 2, len(deadlines), "should call hook for each deadline")
+	})
+
+	t.Run("check caller deadline is restored", func(t *testing.T) {
+		callerDeadline := time.Now().Add(time.Hour)
+		assert.Equal(t, nil, conn.SetReadDeadline(callerDeadline), "should not be error to set read deadline")
+
+		_, err := conn.Write([]byte("restored"))
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		deadlines = nil
+		deadline := time.Now().Add(time.Second)
+		got, err := conn.ReadFrameWithin(deadline)
+		assert.Equal(t, nil, err, "should not be error to read frame within deadline")
+		assert.Equal(t, []byte("restored"), got, "should be equal messages")
+		assert.Equal(t, []time.Time{deadline, callerDeadline}, deadlines, "should restore deadline of the caller")
+
+		deadlines = nil
+		assert.Equal(t, nil, conn.SetDeadline(time.Time{}), "should not be error to clear deadline")
+	})
+
+	t.Run("check hook is removed", func(t *testing.T) {
+		conn.SetDeadlineHook(nil)
+		assert.Equal(t, errSetDeadline, conn.SetReadDeadline(time.Now()), "should be error to set deadline without hook")
+	})
+}
+
+func TestConnSequencing(t *testing.T) {
+	wire := bytes.NewBuffer(nil)
+	writer := NewFrameConnection(testConn{Buffer: wire}, nil, nil, 0, true)
+	writer.EnableSequencing()
+
+	var frames [][]byte
+	for _, msg := range []string{"zero", "one", "two", "three"} {
+		_, err := writer.Write([]byte(msg))
+		assert.Equal(t, nil, err, "should not be error to write")
+		frames = append(frames, append([]byte{}, wire.Bytes()...))
+		wire.Reset()
+	}
+
+	t.Run("check sequenced frames", func(t *testing.T) {
+		reader := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(bytes.Join(frames, nil))}, nil, nil, 0, false)
+		reader.EnableSequencing()
+
+		for _, msg := range []string{"zero", "one", "two", "three"} {
+			got, err := reader.ReadFrame()
+			assert.Equal(t, nil, err, "should not be error to read")
+			assert.Equal(t, []byte(msg), got, "should be equal messages")
+		}
+	})
+
+	t.Run("check skipped frame", func(t *testing.T) {
+		// frame with sequence number 1 is dropped
+		stream := bytes.Join([][]byte{frames[0], frames[2], frames[3]}, nil)
+		reader := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(stream)}, nil, nil, 0, false)
+		reader.EnableSequencing()
+
+		got, err := reader.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, []byte("zero"), got, "should be equal messages")
+
+		_, err = reader.ReadFrame()
+		assert.ErrorIs(t, err, ErrSequenceGap, "should be sequence gap error")
+
+		var gapErr *SequenceGapError
+		if assert.ErrorAs(t, err, &gapErr, "should be SequenceGapError") {
+			assert.Equal(t, uint32(1), gapErr.Expected, "should expose expected sequence number")
+			assert.Equal(t, uint32(2), gapErr.Received, "should expose received sequence number")
+		}
+
+		got, err = reader.ReadFrame()
+		assert.Equal(t, nil, err, "should continue after the received sequence number")
+		assert.Equal(t, []byte("three"), got, "should be equal messages")
+	})
+}
+
+func TestConnPauseReads(t *testing.T) {
+	type readResult struct {
+		msg []byte
+		err error
+	}
+
+	t.Run("check resume", func(t *testing.T) {
+		conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, false)
+		_, err := conn.Write([]byte("first"))
+		assert.Equal(t, nil, err, "should not be error to write")
+		_, err = conn.Write([]byte("second"))
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		conn.PauseReads()
+		read := make(chan readResult, 1)
+		go func() {
+			msg, err := conn.ReadFrame()
+			read <- readResult{msg: msg, err: err}
+		}()
+
+		select {
+		case <-read:
+			t.Error("should block read while paused")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		conn.ResumeReads()
+		result := <-read
+		assert.Equal(t, nil, result.err, "should not be error to read after resume")
+		assert.Equal(t, []byte("first"), result.msg, "should not lose buffered frames")
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, []byte("second"), got, "should be equal messages")
+	})
+
+	t.Run("check close", func(t *testing.T) {
+		conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, false)
+
+		conn.PauseReads()
+		read := make(chan readResult, 1)
+		go func() {
+			msg, err := conn.ReadFrame()
+			read <- readResult{msg: msg, err: err}
+		}()
+
+		assert.Equal(t, nil, conn.CloseWithStatus(closeStatusNormal, ""), "should not be error to close")
+		result := <-read
+		assert.Equal(t, ErrConnClosed, result.err, "should wake paused read on close")
+	})
+}
+
+func TestConnFrameSizeHint(t *testing.T) {
+	conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, true)
+
+	t.Run("check validation", func(t *testing.T) {
+		assert.Equal(t, ErrBadFrameSizeHint, conn.SetFrameSizeHint(0), "should be error to set not positive hint")
+		assert.Equal(t, ErrBadFrameSizeHint, conn.SetFrameSizeHint(DefaultMaxPayloadBytes+1), "should be error to set too large hint")
+		assert.Equal(t, nil, conn.SetFrameSizeHint(4), "should not be error to set hint")
+	})
+
+	readFragments := func(t *testing.T) []string {
+		var fragments []string
+		for {
+			_, fin, data, err := conn.ReadFrameRaw()
+			assert.Equal(t, nil, err, "should not be error to read")
+			fragments = append(fragments, string(data))
+			if fin || err != nil {
+				return fragments
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		msg  string
+		want []string
+	}{
+		{"hello world", []string{"hell", "o wo", "rld"}},
+		{"abcdefgh", []string{"abcd", "efgh"}},
+		{"", []string{""}},
+	} {
+		t.Run(fmt.Sprintf("check write from %q", tc.msg), func(t *testing.T) {
+			n, err := conn.WriteFrom(BinaryFrame, strings.NewReader(tc.msg))
+			assert.Equal(t, nil, err, "should not be error to write from reader")
+			assert.Equal(t, int64(len(tc.msg)), n, "should return read bytes")
+			assert.Equal(t, tc.want, readFragments(t), "should fragment by the hint")
+		})
+	}
+
+	t.Run("check write fragmented without size", func(t *testing.T) {
+		_, err := conn.WriteFragmented(BinaryFrame, []byte("fragmented"), 0)
+		assert.Equal(t, nil, err, "should not be error to write")
+		assert.Equal(t, []string{"frag", "ment", "ed"}, readFragments(t), "should fragment by the hint")
+	})
+}
+
+func TestPacketFrameConnection(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	conn := NewPacketFrameConnection(server)
+	peer := NewPacketFrameConnection(client)
+
+	t.Run("check frames per datagram", func(t *testing.T) {
+		msgs := [][]byte{[]byte("first"), make([]byte, 1000), []byte("last")}
+		go func() {
+			for _, msg := range msgs {
+				_, _ = peer.Write(msg)
+			}
+		}()
+
+		for _, want := range msgs {
+			got, err := conn.ReadFrame()
+			assert.Equal(t, nil, err, "should not be error to read")
+			assert.Equal(t, want, got, "should be equal messages")
+		}
+	})
+
+	t.Run("check bad datagram", func(t *testing.T) {
+		go func() {
+			// header declares 10 bytes of payload, but the datagram has only 2
+			_, _ = client.Write(append(append([]byte{}, preambule...), 0x81, 0x0A, 'l', 'a'))
+			_, _ = client.Write([]byte("garbage"))
+			_, _ = peer.Write([]byte("next"))
+		}()
+
+		_, err := conn.ReadFrame()
+		assert.Equal(t, ErrBadHeader, err, "should be ErrBadHeader error for truncated frame")
+		_, err = conn.ReadFrame()
+		assert.Equal(t, ErrBadPreambule, err, "should be ErrBadPreambule error for garbage")
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read next datagram")
+		assert.Equal(t, []byte("next"), got, "should be aligned on the next datagram")
+	})
+
+	t.Run("check trailing bytes of datagram", func(t *testing.T) {
+		go func() {
+			_, _ = client.Write(append(append([]byte{}, preambule...), 0x81, 0x02, 'h', 'i', 'j', 'u', 'n', 'k'))
+			_, _ = peer.Write([]byte("next"))
+		}()
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, []byte("hi"), got, "should read payload of the frame")
+
+		got, err = conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read next datagram")
+		assert.Equal(t, []byte("next"), got, "should discard trailing bytes of datagram")
+	})
+
+	t.Run("check boundary payload size", func(t *testing.T) {
+		msg := make([]byte, maxPacketPayloadBytes)
+		_, _ = cryptorand.Read(msg)
+
+		written := make(chan error, 1)
+		go func() {
+			_, err := peer.Write(msg)
+			written <- err
+		}()
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read the largest payload")
+		assert.Equal(t, msg, got, "should be equal messages")
+		assert.Equal(t, nil, <-written, "should not be error to write the largest payload")
+
+		_, err = peer.Write(make([]byte, maxPacketPayloadBytes+1))
+		assert.Equal(t, ErrFrameTooLarge, err, "should be ErrFrameTooLarge error for payload over datagram")
+	})
+}
+
+func TestConnFlushAndClose(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+	conn.DisableAutoFlush = true
+
+	var hookErrs []error
+	conn.ErrorHook = func(err error) { hookErrs = append(hookErrs, err) }
+
+	msgs := [][]byte{[]byte("first"), []byte("second"), []byte("third")}
+	for _, msg := range msgs {
+		_, err := conn.Write(msg)
+		assert.Equal(t, nil, err, "should not be error to write")
+	}
+	assert.Equal(t, 0, connBuffer.Len(), "should not flush batched writes")
+
+	assert.Equal(t, nil, conn.FlushAndClose(closeStatusGoingAway, "bye"), "should not be error to flush and close")
+	assert.Equal(t, []error(nil), hookErrs, "should not report unflushed data")
+
+	reader := NewFrameConnection(connBuffer, nil, nil, 0, false)
+	for _, want := range msgs {
+		got, err := reader.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, want, got, "should deliver batched writes before close")
+	}
+
+	_, err := reader.ReadFrame()
+	assert.Equal(t, io.EOF, err, "should read close frame")
+
+	status, reason, _ := reader.CloseStatus()
+	assert.Equal(t, closeStatusGoingAway, status, "should be status of close frame")
+	assert.Equal(t, "bye", reason, "should be reason of close frame")
+
+	assert.Equal(t, ErrConnClosed, conn.FlushAndClose(closeStatusNormal, ""), "should be ErrConnClosed error")
+}
+
+func TestConnFlushAndCloseFlushError(t *testing.T) {
+	server, client := net.Pipe()
+	_ = client.Close()
+
+	conn := NewFrameConnection(server, nil, nil, 0, false)
+	conn.DisableAutoFlush = true
+
+	_, err := conn.Write([]byte("unflushed"))
+	assert.Equal(t, nil, err, "should not be error to write")
+
+	assert.ErrorIs(t, conn.FlushAndClose(closeStatusNormal, ""), io.ErrClosedPipe, "should be error to flush")
+	assert.Equal(t, ErrConnClosed, conn.Close(), "should be ErrConnClosed error")
+
+	_, err = conn.Write([]byte("after"))
+	assert.Equal(t, ErrConnClosed, err, "should be ErrConnClosed error to write")
+}
+
+func TestConnMaskingStats(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, true)
+	conn.SetVerifyMasking(true)
+
+	var hookErrs []error
+	conn.ErrorHook = func(err error) { hookErrs = append(hookErrs, err) }
+
+	const masked = 5
+	for i := 0; i < masked; i++ {
+		_, err := conn.Write(make([]byte, i*100))
+		assert.Equal(t, nil, err, "should not be error to write")
+	}
+
+	bw := bufio.NewWriter(connBuffer)
+	writeTestFrame(t, bw, TextFrame, true, []byte("unmasked"))
+
+	for i := 0; i < masked+1; i++ {
+		_, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+	}
+
+	assert.Equal(t, int64(masked), conn.MaskedFramesWritten(), "should count written masked frames")
+	assert.Equal(t, int64(masked), conn.MaskedFramesRead(), "should count read masked frames")
+	assert.Empty(t, hookErrs, "should not report masking mismatch")
+
+	t.Run("check masking mismatch", func(t *testing.T) {
+		// masked frame declares 10 bytes of payload, but only 4 of them arrive
+		connBuffer.Write(append(append([]byte{}, preambule...), 0x81, 0x8A, 1, 2, 3, 4, 'a', 'b', 'c', 'd'))
+
+		_, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read truncated frame")
+		assert.Equal(t, []error{ErrMaskingMismatch}, hookErrs, "should report masking mismatch")
+	})
+}
+
+func TestConnLastMaskingKey(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, true)
+
+	t.Run("check no frame was read", func(t *testing.T) {
+		key, masked := conn.LastMaskingKey()
+		assert.False(t, masked, "should not be masked before reading")
+		assert.Nil(t, key, "should not return key before reading")
+	})
+
+	for i := 0; i < 3; i++ {
+		t.Run(fmt.Sprintf("check masking key of frame %d", i), func(t *testing.T) {
+			_, err := conn.Write([]byte("masked"))
+			assert.Equal(t, nil, err, "should not be error to write")
+
+			// masking key follows preambule and 2 bytes of the header
+			want := append([]byte{}, connBuffer.Bytes()[len(preambule)+2:len(preambule)+6]...)
+
+			_, err = conn.ReadFrame()
+			assert.Equal(t, nil, err, "should not be error to read")
+
+			key, masked := conn.LastMaskingKey()
+			assert.True(t, masked, "should be masked frame")
+			assert.Equal(t, want, key, "should be equal masking keys")
+
+			key[0] ^= 0xFF
+			again, _ := conn.LastMaskingKey()
+			assert.Equal(t, want, again, "should return copy of masking key")
+		})
+	}
+
+	t.Run("check unmasked frame", func(t *testing.T) {
+		bw := bufio.NewWriter(connBuffer)
+		writeTestFrame(t, bw, TextFrame, true, []byte("unmasked"))
+
+		_, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+
+		key, masked := conn.LastMaskingKey()
+		assert.False(t, masked, "should not be masked frame")
+		assert.Nil(t, key, "should not return key of unmasked frame")
+	})
+}
+
+func TestConnPreambulePerDirection(t *testing.T) {
+	clientToServer := []byte{0xC1, 0x5E}
+	serverToClient := []byte{0x5E, 0xC1, 0x00}
+
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	serverConn := NewFrameConnection(server, nil, nil, 0, false)
+	serverConn.SetReadPreambule(clientToServer)
+	serverConn.SetWritePreambule(serverToClient)
+
+	clientConn := NewFrameConnection(client, nil, nil, 0, true)
+	clientConn.SetReadPreambule(serverToClient)
+	clientConn.SetWritePreambule(clientToServer)
+
+	t.Run("check client to server", func(t *testing.T) {
+		go func() { _, _ = clientConn.Write([]byte("request")) }()
+
+		got, err := serverConn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read on server")
+		assert.Equal(t, []byte("request"), got, "should be equal messages")
+	})
+
+	t.Run("check server to client", func(t *testing.T) {
+		go func() { _, _ = serverConn.Write([]byte("response")) }()
+
+		got, err := clientConn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read on client")
+		assert.Equal(t, []byte("response"), got, "should be equal messages")
+	})
+
+	t.Run("check mismatched preambule", func(t *testing.T) {
+		go func() { _, _ = serverConn.Write([]byte("response")) }()
+
+		peer := NewFrameConnection(client, nil, nil, 0, false)
+		_, err := peer.ReadFrame()
+		assert.Equal(t, ErrBadPreambule, err, "should be ErrBadPreambule error")
+	})
+}
+
+func TestConnDrainUntilClose(t *testing.T) {
+	t.Run("check drain data until close", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer server.Close()
+
+		conn := NewFrameConnection(server, nil, nil, 0, false)
+		peer := NewFrameConnection(client, nil, nil, 0, true)
+		go func() {
+			for i := 0; i < 5; i++ {
+				_, _ = peer.Write(make([]byte, 1000*i))
+			}
+			_ = peer.CloseWithStatus(closeStatusGoingAway, "going away")
+		}()
+
+		status, reason, err := conn.DrainUntilClose(time.Now().Add(time.Second))
+		assert.Equal(t, nil, err, "should not be error to drain")
+		assert.Equal(t, closeStatusGoingAway, status, "should be status of the peer")
+		assert.Equal(t, "going away", reason, "should be reason of the peer")
+	})
+
+	t.Run("check empty close payload", func(t *testing.T) {
+		connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+		writeTestFrame(t, bufio.NewWriter(connBuffer), TextFrame, true, []byte("data"))
+		writeTestFrame(t, bufio.NewWriter(connBuffer), CloseFrame, true, nil)
+
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+		status, reason, err := conn.DrainUntilClose(time.Now().Add(time.Second))
+		assert.Equal(t, nil, err, "should not be error to drain")
+		assert.Equal(t, closeStatusNoStatusRcvd, status, "should be no status received")
+		assert.Equal(t, "", reason, "should be empty reason")
+	})
+
+	t.Run("check deadline", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer server.Close()
+		defer client.Close()
+
+		conn := NewFrameConnection(server, nil, nil, 0, false)
+		_, _, err := conn.DrainUntilClose(time.Now().Add(50 * time.Millisecond))
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded, "should be deadline error")
+	})
+}
+
+func TestConnPriority(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, true)
+
+	for _, high := range []bool{true, false, true} {
+		t.Run(fmt.Sprintf("check priority %t", high), func(t *testing.T) {
+			want := []byte(fmt.Sprintf("priority %t", high))
+			_, err := conn.WritePriority(want, high)
+			assert.Equal(t, nil, err, "should not be error to write")
+
+			got, gotHigh, err := conn.ReadFramePriority()
+			assert.Equal(t, nil, err, "should not be error to read")
+			assert.Equal(t, want, got, "should be equal messages")
+			assert.Equal(t, high, gotHigh, "should be equal priorities")
+		})
+	}
+}
+
+func TestConnWaitForReadable(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	conn := NewFrameConnection(server, nil, nil, 0, false)
+	peer := NewFrameConnection(client, nil, nil, 0, false)
+
+	t.Run("check timeout without data", func(t *testing.T) {
+		err := conn.WaitForReadable(time.Now().Add(50 * time.Millisecond))
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded, "should be deadline error")
+	})
+
+	t.Run("check data available", func(t *testing.T) {
+		go func() { _, _ = peer.Write([]byte("ready")) }()
+
+		start := time.Now()
+		err := conn.WaitForReadable(time.Now().Add(time.Second))
+		assert.Equal(t, nil, err, "should not be error when data is available")
+		assert.Less(t, time.Since(start), time.Second, "should return before deadline")
+
+		err = conn.WaitForReadable(time.Now().Add(time.Second))
+		assert.Equal(t, nil, err, "should return on buffered data")
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, []byte("ready"), got, "should not consume data while waiting")
+	})
+
+	t.Run("check after close", func(t *testing.T) {
+		go func() { _, _ = peer.ReadFrame() }()
+		assert.Equal(t, nil, conn.Close(), "should not be error to close")
+
+		err := conn.WaitForReadable(time.Now().Add(time.Second))
+		assert.Equal(t, ErrConnClosed, err, "should be ErrConnClosed error")
+	})
+}
+
+// wiretapConn counts bytes written to the connection and fails
+// writes after limit bytes if limit is positive
+type wiretapConn struct {
+	testConn
+	written int
+	limit   int
+}
+
+func (c *wiretapConn) Write(p []byte) (int, error) {
+	if c.limit > 0 && c.written+len(p) > c.limit {
+		n, _ := c.testConn.Write(p[:c.limit-c.written])
+		c.written += n
+		return n, io.ErrShortWrite
+	}
+
+	n, err := c.testConn.Write(p)
+	c.written += n
+	return n, err
+}
+
+func TestConnWireSize(t *testing.T) {
+	for _, length := range []int{0, 125, 126, 65535, 65536} {
+		for _, masked := range []bool{false, true} {
+			name := fmt.Sprintf("length %d masked %t", length, masked)
+			msg := make([]byte, length)
+
+			t.Run("check write with "+name, func(t *testing.T) {
+				wiretap := &wiretapConn{testConn: testConn{Buffer: bytes.NewBuffer(nil)}}
+				conn := NewFrameConnection(wiretap, nil, nil, 0, masked)
+
+				n, err := conn.Write(msg)
+				assert.Equal(t, nil, err, "should not be error to write")
+				assert.Equal(t, length, n, "should report payload bytes")
+			})
+
+			t.Run("check writev with "+name, func(t *testing.T) {
+				wiretap := &wiretapConn{testConn: testConn{Buffer: bytes.NewBuffer(nil)}}
+				conn := NewFrameConnection(wiretap, nil, nil, 0, masked)
+
+				n, err := conn.Writev(BinaryFrame, msg[:length/2], msg[length/2:])
+				assert.Equal(t, nil, err, "should not be error to writev")
+				assert.Equal(t, wiretap.written, n, "should report bytes on the wire")
+			})
+
+			t.Run("check write priority with "+name, func(t *testing.T) {
+				wiretap := &wiretapConn{testConn: testConn{Buffer: bytes.NewBuffer(nil)}}
+				conn := NewFrameConnection(wiretap, nil, nil, 0, masked)
+
+				n, err := conn.WritePriority(msg, true)
+				assert.Equal(t, nil, err, "should not be error to write")
+				assert.Equal(t, wiretap.written, n, "should report bytes on the wire")
+			})
+		}
+	}
+
+	t.Run("check failed write", func(t *testing.T) {
+		wiretap := &wiretapConn{testConn: testConn{Buffer: bytes.NewBuffer(nil)}, limit: 10}
+		conn := NewFrameConnection(wiretap, nil, nil, 0, false)
+
+		n, err := conn.Write(make([]byte, 100))
+		assert.Equal(t, io.ErrShortWrite, err, "should be error of the connection")
+		assert.Equal(t, wiretap.written-len(preambule)-2, n, "should report payload bytes on the wire")
+	})
+}
+
+func TestConnReadFrameRaw(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+
+	bw := bufio.NewWriter(connBuffer)
+	writeTestFrame(t, bw, BinaryFrame, false, []byte("frag"))
+	writeTestFrame(t, bw, ContinuationFrame, false, []byte("men"))
+	writeTestFrame(t, bw, ContinuationFrame, true, []byte("ted"))
+	writeTestFrame(t, bw, TextFrame, true, []byte("whole"))
+
+	tests := []struct {
+		payloadType byte
+		fin         bool
+		payload     string
+	}{
+		{BinaryFrame, false, "frag"},
+		{BinaryFrame, false, "men"},
+		{BinaryFrame, true, "ted"},
+		{TextFrame, true, "whole"},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("check frame %d", i), func(t *testing.T) {
+			payloadType, fin, got, err := conn.ReadFrameRaw()
+			assert.Equal(t, nil, err, "should not be error to read frame")
+			assert.Equal(t, tt.payloadType, payloadType, "should be equal payload types")
+			assert.Equal(t, tt.fin, fin, "should be equal fin bits")
+			assert.Equal(t, []byte(tt.payload), got, "should be equal payloads")
+		})
+	}
+}
+
+func TestConnCloseWithError(t *testing.T) {
+	errUnauthorized := errors.New("unauthorized")
+
+	readClose := func(t *testing.T, connBuffer testConn) (int, string) {
+		t.Helper()
+
+		status, reason, err := NewFrameConnection(connBuffer, nil, nil, 0, false).
+			DrainUntilClose(time.Time{})
+		assert.Equal(t, nil, err, "should not be error to read close frame")
+		return status, reason
+	}
+
+	t.Run("check mapped error", func(t *testing.T) {
+		connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+		conn.SetErrorMapper(func(err error) (int, string) {
+			if errors.Is(err, errUnauthorized) {
+				return 4001, "unauthorized"
+			}
+			return 0, ""
+		})
+
+		err := conn.CloseWithError(fmt.Errorf("handle request: %w", errUnauthorized))
+		assert.Equal(t, nil, err, "should not be error to close")
+
+		status, reason := readClose(t, connBuffer)
+		assert.Equal(t, 4001, status, "should be mapped status")
+		assert.Equal(t, "unauthorized", reason, "should be mapped reason")
+	})
+
+	t.Run("check default mapping", func(t *testing.T) {
+		tests := []struct {
+			err    error
+			status int
+		}{
+			{nil, closeStatusNormal},
+			{ErrFrameTooLarge, closeStatusTooBigData},
+			{ErrBadOpCode, closeStatusProtocolError},
+			{errUnauthorized, closeStatusInternalError},
+		}
+
+		for _, tt := range tests {
+			connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+			conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+			conn.SetErrorMapper(func(err error) (int, string) { return 0, "" })
+
+			assert.Equal(t, nil, conn.CloseWithError(tt.err), "should not be error to close")
+
+			status, _ := readClose(t, connBuffer)
+			assert.Equal(t, tt.status, status, "should be default status of %v", tt.err)
+		}
+	})
+
+	t.Run("check long error text", func(t *testing.T) {
+		connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+
+		err := conn.CloseWithError(errors.New(strings.Repeat("ошибка ", 20)))
+		assert.Equal(t, nil, err, "should not be error to close")
+
+		_, reason := readClose(t, connBuffer)
+		assert.LessOrEqual(t, len(reason), maxControlPayloadBytes-2, "should truncate reason")
+		assert.True(t, utf8.ValidString(reason), "should truncate reason on rune boundary")
+	})
+}
+
+func TestConnPeekType(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+
+	bw := bufio.NewWriter(connBuffer)
+	writeTestFrame(t, bw, TextFrame, true, []byte("text"))
+	writeTestFrame(t, bw, BinaryFrame, false, []byte("bin"))
+	writeTestFrame(t, bw, ContinuationFrame, true, []byte("ary"))
+	writeTestFrame(t, bw, TextFrame, true, []byte("streamed"))
+
+	tests := []struct {
+		payloadType byte
+		payload     string
+	}{
+		{TextFrame, "text"},
+		{BinaryFrame, "bin"},
+		{BinaryFrame, "ary"},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("check peek frame %d", i), func(t *testing.T) {
+			for j := 0; j < 2; j++ {
+				payloadType, err := conn.PeekType()
+				assert.Equal(t, nil, err, "should not be error to peek")
+				assert.Equal(t, tt.payloadType, payloadType, "should be equal payload types")
+			}
+
+			_, _, got, err := conn.ReadFrameRaw()
+			assert.Equal(t, nil, err, "should not be error to read")
+			assert.Equal(t, []byte(tt.payload), got, "should be equal payloads")
+		})
+	}
+
+	t.Run("check peek with read", func(t *testing.T) {
+		payloadType, err := conn.PeekType()
+		assert.Equal(t, nil, err, "should not be error to peek")
+		assert.Equal(t, byte(TextFrame), payloadType, "should be text frame")
+
+		got := make([]byte, 8)
+		n, err := conn.Read(got)
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, []byte("streamed"), got[:n], "should be equal payloads")
+	})
+}
+
+// blockingConn blocks each Write until release is closed
+type blockingConn struct {
+	testConn
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (c blockingConn) Write(p []byte) (int, error) {
+	c.entered <- struct{}{}
+	<-c.release
+	return c.testConn.Write(p)
+}
+
+func TestConnWriteWatchdog(t *testing.T) {
+	connBuffer := blockingConn{
+		testConn: testConn{Buffer: bytes.NewBuffer(nil)},
+		entered:  make(chan struct{}, 1),
+		release:  make(chan struct{}),
+	}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+
+	clk := newFakeClock()
+	conn.clock = clk
+
+	var stuck atomic.Int32
+	conn.SetWriteWatchdog(time.Second, func() { stuck.Add(1) })
+
+	t.Run("check watchdog fires on blocked write", func(t *testing.T) {
+		done := make(chan error, 1)
+		go func() {
+			_, err := conn.Write([]byte("stuck"))
+			done <- err
+		}()
+
+		<-connBuffer.entered
+		clk.Advance(500 * time.Millisecond)
+		assert.Equal(t, int32(0), stuck.Load(), "should not fire before watchdog duration")
+
+		clk.Advance(500 * time.Millisecond)
+		assert.Equal(t, int32(1), stuck.Load(), "should fire on blocked write")
+
+		close(connBuffer.release)
+		assert.Equal(t, nil, <-done, "should not abort blocked write")
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, []byte("stuck"), got, "should be equal payloads")
+	})
+
+	t.Run("check watchdog is stopped after write", func(t *testing.T) {
+		_, err := conn.Write([]byte("fast"))
+		assert.Equal(t, nil, err, "should not be error to write")
+		<-connBuffer.entered
+
+		clk.Advance(2 * time.Second)
+		assert.Equal(t, int32(1), stuck.Load(), "should not fire after completed write")
+	})
+}
+
+func TestConnLengthByteOrder(t *testing.T) {
+	for _, length := range []int{125, 300, 70000} {
+		t.Run(fmt.Sprintf("check little endian round trip of %d bytes", length), func(t *testing.T) {
+			connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+			writer := NewFrameConnection(connBuffer, nil, nil, 0, true)
+			writer.LengthByteOrder = binary.LittleEndian
+
+			msg := make([]byte, length)
+			_, _ = cryptorand.Read(msg)
+			_, err := writer.Write(msg)
+			assert.Equal(t, nil, err, "should not be error to write")
+
+			ext := connBuffer.Bytes()[len(preambule)+2:]
+			switch {
+			case length > 65535:
+				assert.Equal(t, uint64(length), binary.LittleEndian.Uint64(ext), "should write little endian length")
+			case length > 125:
+				assert.Equal(t, uint16(length), binary.LittleEndian.Uint16(ext), "should write little endian length")
+			}
+
+			reader := NewFrameConnection(connBuffer, nil, nil, 0, false)
+			reader.LengthByteOrder = binary.LittleEndian
+
+			got, err := reader.ReadFrame()
+			assert.Equal(t, nil, err, "should not be error to read")
+			assert.Equal(t, msg, got, "should be equal messages")
+		})
+	}
+}
+
+func TestConnProtocolVersion(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, true)
+	conn.ProtocolVersion = 3
+
+	t.Run("check round trip with version", func(t *testing.T) {
+		n, err := conn.Write([]byte("versioned"))
+		assert.Equal(t, nil, err, "should not be error to write")
+		assert.Equal(t, len("versioned"), n, "should count payload bytes")
+		assert.Equal(t, len(preambule)+1+6+len("versioned"), connBuffer.Len(), "should write version byte")
+		assert.Equal(t, byte(3), connBuffer.Bytes()[len(preambule)], "should write version after preambule")
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, []byte("versioned"), got, "should be equal messages")
+	})
+
+	t.Run("check version mismatch", func(t *testing.T) {
+		conn.ProtocolVersion = 2
+		_, err := conn.Write([]byte("old"))
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		conn.ProtocolVersion = 3
+		_, err = conn.ReadFrame()
+		assert.Equal(t, ErrVersionMismatch, err, "should be ErrVersionMismatch error")
+	})
+
+	t.Run("check resync skips frames of other version", func(t *testing.T) {
+		connBuffer.Reset()
+		conn.SetResync(true)
+
+		conn.ProtocolVersion = 2
+		_, _ = conn.Write([]byte("old"))
+		conn.ProtocolVersion = 3
+		_, _ = conn.Write([]byte("new"))
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, []byte("new"), got, "should skip frame of other version")
+	})
+}
+
+func TestConnCopyTo(t *testing.T) {
+	srcServer, srcClient := net.Pipe()
+	dstServer, dstClient := net.Pipe()
+	defer srcServer.Close()
+	defer dstServer.Close()
+
+	client := NewFrameConnection(srcClient, nil, nil, 0, true)
+	src := NewFrameConnection(srcServer, nil, nil, 0, false)
+	dst := NewFrameConnection(dstClient, nil, nil, 0, true)
+	server := NewFrameConnection(dstServer, nil, nil, 0, false)
+
+	copied := make(chan int64, 1)
+	go func() {
+		n, err := src.CopyTo(dst)
+		assert.Equal(t, nil, err, "should not be error to copy frames")
+		copied <- n
+	}()
+
+	go func() {
+		_, _ = client.Write([]byte("text"))
+		_, _ = client.Writev(BinaryFrame, []byte("binary"))
+		_, _ = client.WriteFragmented(TextFrame, []byte("fragmented"), 4)
+		_ = client.Close()
+	}()
+
+	tests := []struct {
+		payloadType byte
+		fin         bool
+		payload     string
+	}{
+		{TextFrame, true, "text"},
+		{BinaryFrame, true, "binary"},
+		{TextFrame, false, "frag"},
+		{TextFrame, false, "ment"},
+		{TextFrame, true, "ed"},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("check copied frame %d", i), func(t *testing.T) {
+			payloadType, fin, got, err := server.ReadFrameRaw()
+			assert.Equal(t, nil, err, "should not be error to read copied frame")
+			assert.Equal(t, tt.payloadType, payloadType, "should be equal payload types")
+			assert.Equal(t, tt.fin, fin, "should be equal fin bits")
+			assert.Equal(t, []byte(tt.payload), got, "should be equal payloads")
+		})
+	}
+
+	t.Run("check copy stops on close", func(t *testing.T) {
+		assert.Equal(t, int64(len("textbinaryfragmented")), <-copied, "should count copied payload bytes")
+		assert.Equal(t, int64(len(tests)), server.MaskedFramesRead(), "should mask frames by dst")
+	})
+}
+
+func TestConnReadAllFrames(t *testing.T) {
+	t.Run("check messages until close", func(t *testing.T) {
+		connBuffer := testConn{
+			Buffer: bytes.NewBuffer(nil),
+		}
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, true)
+
+		want := [][]byte{[]byte("first"), {}, []byte("fragmented message"), []byte("last")}
+		for i, msg := range want {
+			if i == 2 {
+				_, err := conn.WriteFragmented(TextFrame, msg, 4)
+				assert.Equal(t, nil, err, "should not be error to write fragmented")
+				continue
+			}
+
+			_, err := conn.Write(msg)
+			assert.Equal(t, nil, err, "should not be error to write")
+		}
+		assert.Equal(t, nil, conn.CloseWithStatus(closeStatusGoingAway, "bye"), "should not be error to close")
+
+		peer := NewFrameConnection(connBuffer, nil, nil, 0, false)
+		got, status, err := peer.ReadAllFrames(1024)
+		assert.Equal(t, nil, err, "should not be error to read all frames")
+		assert.Equal(t, want, got, "should be equal messages")
+		assert.Equal(t, closeStatusGoingAway, status, "should be status of close frame")
+	})
+
+	t.Run("check total size cap", func(t *testing.T) {
+		connBuffer := testConn{
+			Buffer: bytes.NewBuffer(nil),
+		}
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+
+		for i := 0; i < 3; i++ {
+			_, err := conn.Write(make([]byte, 40))
+			assert.Equal(t, nil, err, "should not be error to write")
+		}
+		_, _ = conn.Write([]byte("next"))
+
+		got, _, err := conn.ReadAllFrames(100)
+		assert.Equal(t, ErrFrameTooLarge, err, "should be ErrFrameTooLarge error")
+		assert.Equal(t, 2, len(got), "should return messages read before cap")
+
+		next, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read after cap")
+		assert.Equal(t, []byte("next"), next, "should discard frame exceeding cap")
+	})
+}
+
+func TestConnMaskingPolicy(t *testing.T) {
+	upstream := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+	downstream := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+
+	// proxy reads unmasked frames from upstream and writes masked frames to downstream
+	proxyReader := NewFrameConnection(upstream, nil, nil, 0, false)
+	proxyReader.ReadExpectMasked = MaskingForbidden
+	proxyWriter := NewFrameConnection(downstream, nil, nil, 0, false)
+	proxyWriter.SetWriteMasking(true)
+
+	peer := NewFrameConnection(upstream, nil, nil, 0, false)
+	_, err := peer.Write([]byte("unmasked"))
+	assert.Equal(t, nil, err, "should not be error to write")
+
+	peer.SetWriteMasking(true)
+	_, err = peer.Write([]byte("masked"))
+	assert.Equal(t, nil, err, "should not be error to write")
+
+	t.Run("check read unmasked and write masked", func(t *testing.T) {
+		got, err := proxyReader.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read unmasked frame")
+
+		_, err = proxyWriter.Write(got)
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		server := NewFrameConnection(downstream, nil, nil, 0, false)
+		server.ReadExpectMasked = MaskingRequired
+		relayed, err := server.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read masked frame")
+		assert.Equal(t, []byte("unmasked"), relayed, "should be equal messages")
+		assert.Equal(t, int64(1), proxyWriter.MaskedFramesWritten(), "should mask written frame")
+	})
+
+	t.Run("check masked frame violates policy", func(t *testing.T) {
+		_, err := proxyReader.ReadFrame()
+		assert.Equal(t, ErrMaskingPolicy, err, "should be ErrMaskingPolicy error")
+		assert.Equal(t, 0, upstream.Len()+proxyReader.buf.Reader.Buffered(), "should discard violating frame")
+	})
+}
+
+func TestConnDisableMasking(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, true)
+	conn.ReadExpectMasked = MaskingRequired
+	conn.DisableMasking()
+
+	msg := make([]byte, 70000)
+	_, _ = cryptorand.Read(msg)
+
+	_, err := conn.Write(msg)
+	assert.Equal(t, nil, err, "should not be error to write")
+	assert.Equal(t, byte(127), connBuffer.Bytes()[len(preambule)+1], "should not set mask bit")
+
+	got, err := conn.ReadFrame()
+	assert.Equal(t, nil, err, "should not be error to read unmasked frame")
+	assert.Equal(t, msg, got, "should be equal messages")
+	assert.Equal(t, int64(0), conn.MaskedFramesWritten(), "should not mask written frames")
+	assert.Equal(t, int64(0), conn.MaskedFramesRead(), "should not read masked frames")
+}
+
+func BenchmarkConnWriteMasking(b *testing.B) {
+	for _, masked := range []bool{true, false} {
+		b.Run(fmt.Sprintf("masked %t", masked), func(b *testing.B) {
+			conn := NewFrameConnection(&repeatConn{frame: []byte{0}}, nil, nil, 0, true)
+			if !masked {
+				conn.DisableMasking()
+			}
+
+			msg := make([]byte, 1<<20)
+
+			b.SetBytes(int64(len(msg)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := conn.Write(msg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestConnFragmentHandler(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, true)
+
+	type fragment struct {
+		payloadType   byte
+		fragmentBytes int
+		fin           bool
+	}
+
+	var got []fragment
+	conn.SetFragmentHandler(func(payloadType byte, fragmentBytes int, fin bool) {
+		got = append(got, fragment{payloadType, fragmentBytes, fin})
+	})
+
+	_, err := conn.Write([]byte("whole"))
+	assert.Equal(t, nil, err, "should not be error to write")
+	_, err = conn.WriteFragmented(BinaryFrame, make([]byte, 10), 4)
+	assert.Equal(t, nil, err, "should not be error to write fragmented")
+
+	for i := 0; i < 2; i++ {
+		_, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+	}
+
+	want := []fragment{
+		{BinaryFrame, 4, false},
+		{BinaryFrame, 4, false},
+		{BinaryFrame, 2, true},
+	}
+	assert.Equal(t, want, got, "should call handler on each fragment")
+}
+
+func TestConnReadFrameReassembly(t *testing.T) {
+	t.Run("check fragments are reassembled", func(t *testing.T) {
+		connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+
+		bw := bufio.NewWriter(connBuffer)
+		writeTestFrame(t, bw, TextFrame, false, []byte("hel"))
+		writeTestFrame(t, bw, ContinuationFrame, false, []byte("lo, "))
+		writeTestFrame(t, bw, ContinuationFrame, true, []byte("world"))
+		writeTestFrame(t, bw, TextFrame, true, []byte("next"))
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read fragmented message")
+		assert.Equal(t, []byte("hello, world"), got, "should reassemble fragments")
+
+		got, err = conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read next message")
+		assert.Equal(t, []byte("next"), got, "should be equal messages")
+	})
+
+	t.Run("check fragments are reassembled by all message reads", func(t *testing.T) {
+		reads := map[string]func(conn *Conn) ([]byte, error){
+			"ReadFrameContext": func(conn *Conn) ([]byte, error) {
+				return conn.ReadFrameContext(context.Background())
+			},
+			"ReadFrameBuffer": func(conn *Conn) ([]byte, error) {
+				var buf bytes.Buffer
+				err := conn.ReadFrameBuffer(&buf)
+				return buf.Bytes(), err
+			},
+			"ReadFramePooled": func(conn *Conn) ([]byte, error) {
+				data, release, err := conn.ReadFramePooled()
+				if err != nil {
+					return nil, err
+				}
+				defer release()
+				return append([]byte{}, data...), nil
+			},
+			"ReadFramePriority": func(conn *Conn) ([]byte, error) {
+				data, _, err := conn.ReadFramePriority()
+				return data, err
+			},
+		}
+
+		for name, read := range reads {
+			t.Run(name, func(t *testing.T) {
+				connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+				conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+
+				bw := bufio.NewWriter(connBuffer)
+				writeTestFrame(t, bw, TextFrame, false, []byte("ab"))
+				writeTestFrame(t, bw, ContinuationFrame, false, []byte("cd"))
+				writeTestFrame(t, bw, ContinuationFrame, true, []byte("ef"))
+				writeTestFrame(t, bw, TextFrame, true, []byte("next"))
+
+				got, err := read(conn)
+				assert.Equal(t, nil, err, "should not be error to read fragmented message")
+				assert.Equal(t, []byte("abcdef"), got, "should reassemble fragments")
+
+				got, err = read(conn)
+				assert.Equal(t, nil, err, "should not be error to read next message")
+				assert.Equal(t, []byte("next"), got, "should be equal messages")
+			})
+		}
+	})
+
+	t.Run("check cumulative size is limited", func(t *testing.T) {
+		conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 8, false)
+
+		_, err := conn.WriteFragmented(BinaryFrame, []byte("fragmented message"), 6)
+		assert.Equal(t, nil, err, "should not be error to write fragmented")
+		_, err = conn.Write([]byte("fits"))
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		_, err = conn.ReadFrame()
+		assert.Equal(t, ErrFrameTooLarge, err, "should be ErrFrameTooLarge error")
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read after discarded message")
+		assert.Equal(t, []byte("fits"), got, "should be aligned on the next message")
+	})
+
+	t.Run("check reassembled payload is allocated", func(t *testing.T) {
+		conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, false)
+
+		allocs, frees := 0, 0
+		conn.SetAllocator(func(n int) []byte {
+			allocs++
+			return make([]byte, n)
+		}, func([]byte) { frees++ })
+
+		_, err := conn.WriteFragmented(BinaryFrame, []byte("allocated"), 4)
+		assert.Equal(t, nil, err, "should not be error to write fragmented")
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, []byte("allocated"), got, "should reassemble fragments")
+		assert.Equal(t, allocs-1, frees, "should leave only reassembled payload to the caller")
+	})
+}
+
+func TestConnFragmentAssemblyTimeout(t *testing.T) {
+	newConn := func() (*Conn, *fakeClock) {
+		clk := newFakeClock()
+		conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, false)
+		conn.clock = clk
+		conn.FragmentAssemblyTimeout = 2 * time.Second
+		return conn, clk
+	}
+
+	t.Run("check message within timeout", func(t *testing.T) {
+		conn, clk := newConn()
+
+		for i := 0; i < 2; i++ {
+			_, err := conn.WriteFragmented(BinaryFrame, []byte("in time"), 3)
+			assert.Equal(t, nil, err, "should not be error to write fragmented")
+
+			for j := 0; j < 3; j++ {
+				_, _, _, err := conn.ReadFrameRaw()
+				assert.Equal(t, nil, err, "should not be error to read fragment")
+				clk.Advance(900 * time.Millisecond)
+			}
+		}
+	})
+
+	t.Run("check slow fragments", func(t *testing.T) {
+		conn, clk := newConn()
+
+		_, err := conn.WriteFragmented(BinaryFrame, []byte("too slow"), 3)
+		assert.Equal(t, nil, err, "should not be error to write fragmented")
+
+		_, _, _, err = conn.ReadFrameRaw()
+		assert.Equal(t, nil, err, "should not be error to read first fragment")
+
+		clk.Advance(time.Second)
+		_, _, _, err = conn.ReadFrameRaw()
+		assert.Equal(t, nil, err, "should not be error to read fragment in time")
+
+		clk.Advance(1500 * time.Millisecond)
+		_, _, _, err = conn.ReadFrameRaw()
+		assert.Equal(t, ErrAssemblyTimeout, err, "should be ErrAssemblyTimeout error")
+
+		_, err = conn.Write([]byte("closed"))
+		assert.Equal(t, ErrConnClosed, err, "should close connection")
+	})
+
+	t.Run("check stalled peer", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer client.Close()
+
+		conn := NewFrameConnection(server, nil, nil, 0, false)
+		conn.FragmentAssemblyTimeout = 50 * time.Millisecond
+		peer := NewFrameConnection(client, nil, nil, 0, false)
+
+		received := make(chan error, 1)
+		go func() {
+			// the peer sends only the first fragment and reads close frame
+			_, _ = peer.WriteFrame(TextFrame, false, []byte("first"))
+			_, err := peer.ReadFrame()
+			received <- err
+		}()
+
+		read := make(chan error, 1)
+		go func() {
+			_, err := conn.ReadFrame()
+			read <- err
+		}()
+
+		select {
+		case err := <-read:
+			assert.Equal(t, ErrAssemblyTimeout, err, "should be ErrAssemblyTimeout error")
+		case <-time.After(time.Second):
+			t.Fatal("should not wait for continuation of stalled peer")
+		}
+
+		assert.Equal(t, io.EOF, <-received, "should send close frame to the peer")
+		status, _, _ := peer.CloseStatus()
+		assert.Equal(t, closeStatusPolicyViolation, status, "should be policy violation status")
+	})
+}
+
+func TestConnOnBadPreambule(t *testing.T) {
+	// fake preambule with bad opcode followed by a frame
+	garbage := append([]byte{'x', 'y'}, preambule...)
+	garbage = append(garbage, 0x8F, 0x00)
+
+	newConn := func(action BadPreambuleAction) (*Conn, testConn) {
+		connBuffer := testConn{
+			Buffer: bytes.NewBuffer(nil),
+		}
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+		conn.OnBadPreambule = func() BadPreambuleAction { return action }
+
+		connBuffer.Write(garbage)
+		bw := bufio.NewWriter(connBuffer)
+		writeTestFrame(t, bw, TextFrame, true, []byte("frame"))
+		return conn, connBuffer
+	}
+
+	t.Run("check close connection", func(t *testing.T) {
+		conn, connBuffer := newConn(CloseConn)
+
+		_, err := conn.ReadFrame()
+		assert.Equal(t, ErrBadPreambule, err, "should be ErrBadPreambule error")
+
+		_, err = conn.ReadFrame()
+		assert.Equal(t, ErrConnClosed, err, "should close connection")
+
+		peer := NewFrameConnection(connBuffer, nil, nil, 0, false)
+		peer.SetResync(true)
+		status, _, err := peer.DrainUntilClose(time.Time{})
+		assert.Equal(t, nil, err, "should not be error to drain until close")
+		assert.Equal(t, closeStatusProtocolError, status, "should close with protocol error")
+	})
+
+	t.Run("check resync", func(t *testing.T) {
+		conn, _ := newConn(Resync)
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read after resync")
+		assert.Equal(t, []byte("frame"), got, "should skip implausible header")
+	})
+
+	t.Run("check skip", func(t *testing.T) {
+		conn, _ := newConn(Skip)
+
+		_, err := conn.ReadFrame()
+		assert.Equal(t, ErrBadOpCode, err, "should read frame after the next preambule")
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read next frame")
+		assert.Equal(t, []byte("frame"), got, "should be equal messages")
+	})
+}
+
+func TestConnCloseHandshake(t *testing.T) {
+	t.Run("check both sides exchange close frames", func(t *testing.T) {
+		server, client := net.Pipe()
+
+		conn := NewFrameConnection(server, nil, nil, 0, false)
+		peer := NewFrameConnection(client, nil, nil, 0, true)
+
+		received := make(chan int, 1)
+		go func() {
+			_, err := peer.ReadFrame()
+			if err == io.EOF {
+				status, _, _ := peer.CloseStatus()
+				received <- status
+			}
+			_ = peer.Close()
+		}()
+
+		err := conn.CloseHandshake(time.Second)
+		assert.Equal(t, nil, err, "should not be error to close with handshake")
+		assert.Equal(t, closeStatusNormal, <-received, "should send close to the peer")
+
+		status, _, ok := conn.CloseStatus()
+		assert.Equal(t, true, ok, "should receive close of the peer")
+		assert.Equal(t, closeStatusNormal, status, "should be normal status of the peer")
+
+		_, err = server.Write([]byte{0})
+		assert.Equal(t, io.ErrClosedPipe, err, "should close rwc after handshake")
+	})
+
+	t.Run("check timeout", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer client.Close()
+
+		conn := NewFrameConnection(server, nil, nil, 0, false)
+		go func() { _, _ = NewFrameConnection(client, nil, nil, 0, false).ReadFrame() }()
+
+		err := conn.CloseHandshake(50 * time.Millisecond)
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded, "should be deadline error")
+
+		_, err = server.Write([]byte{0})
+		assert.Equal(t, io.ErrClosedPipe, err, "should close rwc after timeout")
+	})
+}
+
+func TestConnWriteCloseAndWait(t *testing.T) {
+	t.Run("check close of the peer", func(t *testing.T) {
+		server, client := net.Pipe()
+
+		conn := NewFrameConnection(server, nil, nil, 0, false)
+		peer := NewFrameConnection(client, nil, nil, 0, true)
+
+		received := make(chan int, 1)
+		go func() {
+			status, _, _ := peer.DrainUntilClose(time.Now().Add(time.Second))
+			received <- status
+
+			_, _ = peer.Write([]byte("late data"))
+			_ = peer.CloseWithStatus(closeStatusGoingAway, "echo")
+		}()
+
+		status, reason, err := conn.WriteCloseAndWait(closeStatusNormal, "done", time.Second)
+		assert.Equal(t, nil, err, "should not be error to wait for close")
+		assert.Equal(t, closeStatusGoingAway, status, "should be status of the peer")
+		assert.Equal(t, "echo", reason, "should be reason of the peer")
+		assert.Equal(t, closeStatusNormal, <-received, "should send close to the peer")
+
+		_, err = conn.Write([]byte("after close"))
+		assert.Equal(t, ErrConnClosed, err, "should be ErrConnClosed error to write")
+		_, err = conn.ReadFrame()
+		assert.Equal(t, ErrConnClosed, err, "should be ErrConnClosed error to read")
+	})
+
+	t.Run("check timeout", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer client.Close()
+
+		conn := NewFrameConnection(server, nil, nil, 0, false)
+		go func() { _, _ = NewFrameConnection(client, nil, nil, 0, false).ReadFrame() }()
+
+		_, _, err := conn.WriteCloseAndWait(closeStatusNormal, "", 50*time.Millisecond)
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded, "should be deadline error")
+	})
+
+	t.Run("check peer closes without echo", func(t *testing.T) {
+		server, client := net.Pipe()
+
+		conn := NewFrameConnection(server, nil, nil, 0, false)
+		go func() {
+			_, _ = NewFrameConnection(client, nil, nil, 0, false).ReadFrame()
+			_ = client.Close()
+		}()
+
+		status, _, err := conn.WriteCloseAndWait(closeStatusNormal, "", time.Second)
+		assert.Equal(t, nil, err, "should be clean close after our close frame")
+		assert.Equal(t, closeStatusNoStatusRcvd, status, "should be no status received")
+	})
+
+	t.Run("check simultaneous close", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			c, err := ln.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- c
+		}()
+
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conns := []*Conn{
+			NewFrameConnection(c, nil, nil, 0, true),
+			NewFrameConnection(<-accepted, nil, nil, 0, false),
+		}
+
+		errs := make(chan error, len(conns))
+		for _, conn := range conns {
+			go func() {
+				_, _, err := conn.WriteCloseAndWait(closeStatusGoingAway, "", time.Second)
+				errs <- err
+			}()
+		}
+
+		for range conns {
+			assert.Equal(t, nil, <-errs, "should be clean close of both peers")
+		}
+	})
+}
+
+func TestConnAllocator(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, true)
+
+	allocated := map[*byte]bool{}
+	var allocs, frees int
+	conn.SetAllocator(
+		func(n int) []byte {
+			allocs++
+			b := make([]byte, n+1)
+			allocated[&b[0]] = true
+			return b
+		},
+		func(b []byte) {
+			frees++
+			assert.True(t, allocated[&b[:1][0]], "should free allocated buffer")
+			delete(allocated, &b[:1][0])
+		},
+	)
+
+	msgs := [][]byte{[]byte("first"), make([]byte, 70000), {}, []byte("last")}
+	for _, msg := range msgs {
+		_, err := conn.Write(msg)
+		assert.Equal(t, nil, err, "should not be error to write")
+	}
+
+	t.Run("check pooled reads", func(t *testing.T) {
+		for _, want := range msgs[:3] {
+			got, release, err := conn.ReadFramePooled()
+			assert.Equal(t, nil, err, "should not be error to read")
+			assert.Equal(t, want, got, "should be equal messages")
+			release()
+		}
+
+		assert.Equal(t, 3, allocs, "should allocate each payload")
+		assert.Equal(t, allocs, frees, "should free each payload")
+		assert.Empty(t, allocated, "should not leak buffers")
+	})
+
+	t.Run("check read frame", func(t *testing.T) {
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, []byte("last"), got, "should be equal messages")
+		assert.Equal(t, 4, allocs, "should allocate payload of ReadFrame")
+		assert.Equal(t, 3, frees, "should leave payload of ReadFrame to the caller")
+	})
+}
+
+func TestConnWriteControl(t *testing.T) {
+	t.Run("check bad control frames", func(t *testing.T) {
+		conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, false)
+
+		_, err := conn.WriteControl(TextFrame, nil)
+		assert.Equal(t, ErrBadOpCode, err, "should be ErrBadOpCode error")
+
+		_, err = conn.WriteControl(PingFrame, make([]byte, maxControlPayloadBytes+1))
+		assert.Equal(t, ErrControlFrameTooLarge, err, "should be ErrControlFrameTooLarge error")
+	})
+
+	t.Run("check ping is skipped by reader", func(t *testing.T) {
+		connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, true)
+
+		_, err := conn.WriteControl(PingFrame, []byte("ping"))
+		assert.Equal(t, nil, err, "should not be error to write ping")
+		_, err = conn.Write([]byte("data"))
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, []byte("data"), got, "should skip ping frame")
+	})
+
+	t.Run("check ping is not delayed by fragmented write", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer server.Close()
+		defer client.Close()
+
+		conn := NewFrameConnection(server, nil, nil, 0, false)
+		readerFactory := tcpFrameReaderFactory{Reader: bufio.NewReader(client)}
+
+		const fragments = 64
+		go func() { _, _ = conn.WriteFragmented(BinaryFrame, make([]byte, fragments*4096), 4096) }()
+
+		readFrame := func() byte {
+			frame, err := readerFactory.NewFrameReader()
+			if !assert.Equal(t, nil, err, "should not be error to read frame") {
+				return UnknownFrame
+			}
+
+			_, _ = io.Copy(io.Discard, frame)
+			return frame.PayloadType()
+		}
+
+		assert.Equal(t, byte(BinaryFrame), readFrame(), "should start fragmented write")
+
+		pinged := make(chan error, 1)
+		go func() {
+			_, err := conn.WriteControl(PingFrame, []byte("ping"))
+			pinged <- err
+		}()
+
+		// let ping to be queued while the fragmented write is blocked
+		time.Sleep(20 * time.Millisecond)
+
+		frames := 0
+		for readFrame() != PingFrame {
+			frames++
+		}
+		assert.LessOrEqual(t, frames, 2, "should write ping between fragments")
+		assert.Equal(t, nil, <-pinged, "should not be error to write ping")
+
+		for frames++; frames < fragments-1; frames++ {
+			assert.Equal(t, byte(ContinuationFrame), readFrame(), "should continue fragmented write")
+		}
+	})
+}
+
+func TestConnDefaultPayloadType(t *testing.T) {
+	connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+	readerFactory := tcpFrameReaderFactory{Reader: bufio.NewReader(connBuffer)}
+
+	t.Run("check default payload type", func(t *testing.T) {
+		_, err := conn.Write([]byte("text"))
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		frame, err := readerFactory.NewFrameReader()
+		assert.Equal(t, nil, err, "should not be error to read frame")
+		assert.Equal(t, byte(TextFrame), frame.PayloadType(), "should write text frame by default")
+		_, _ = io.Copy(io.Discard, frame)
+	})
+
+	t.Run("check binary payload type", func(t *testing.T) {
+		conn.PayloadType = BinaryFrame
+		_, err := conn.Write([]byte{0x00, 0xFF})
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		frame, err := readerFactory.NewFrameReader()
+		assert.Equal(t, nil, err, "should not be error to read frame")
+		assert.Equal(t, byte(BinaryFrame), frame.PayloadType(), "should write binary frame")
+	})
+}
+
+func TestConnWriteControlQueued(t *testing.T) {
+	t.Run("check bad control frames", func(t *testing.T) {
+		conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, false)
+
+		assert.Equal(t, ErrBadOpCode, conn.WriteControlQueued(TextFrame, nil), "should be ErrBadOpCode error")
+		assert.Equal(
+			t,
+			ErrControlFrameTooLarge,
+			conn.WriteControlQueued(PongFrame, make([]byte, maxControlPayloadBytes+1)),
+			"should be ErrControlFrameTooLarge error",
+		)
+	})
+
+	t.Run("check control frame queued during long write", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer server.Close()
+		defer client.Close()
+
+		conn := NewFrameConnection(server, nil, nil, 0, false)
+		readerFactory := tcpFrameReaderFactory{Reader: bufio.NewReader(client)}
+
+		go func() { _, _ = conn.Write(make([]byte, 1<<16)) }()
+
+		// the write holds the write lock until the peer reads the frame
+		frame, err := readerFactory.NewFrameReader()
+		assert.Equal(t, nil, err, "should not be error to read frame header")
+
+		queued := make(chan error, 1)
+		go func() { queued <- conn.WriteControlQueued(PongFrame, []byte("queued")) }()
+
+		select {
+		case err := <-queued:
+			assert.Equal(t, nil, err, "should not be error to queue pong")
+		case <-time.After(time.Second):
+			t.Fatal("should not wait for the long write")
+		}
+
+		_, err = io.Copy(io.Discard, frame)
+		assert.Equal(t, nil, err, "should not be error to read long frame")
+
+		frame, err = readerFactory.NewFrameReader()
+		assert.Equal(t, nil, err, "should not be error to read queued frame")
+		assert.Equal(t, byte(PongFrame), frame.PayloadType(), "should deliver pong after the write")
+
+		got, err := io.ReadAll(frame)
+		assert.Equal(t, nil, err, "should not be error to read pong payload")
+		assert.Equal(t, []byte("queued"), got, "should be equal payloads")
+	})
+}
+
+func TestConnPingPong(t *testing.T) {
+	connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+	readerFactory := tcpFrameReaderFactory{Reader: bufio.NewReader(connBuffer)}
+
+	t.Run("check ping and pong frames", func(t *testing.T) {
+		assert.Equal(t, nil, conn.Ping([]byte("keepalive")), "should not be error to write ping")
+		assert.Equal(t, nil, conn.Pong([]byte("keepalive")), "should not be error to write pong")
+
+		for _, payloadType := range []byte{PingFrame, PongFrame} {
+			frame, err := readerFactory.NewFrameReader()
+			assert.Equal(t, nil, err, "should not be error to read frame")
+			assert.Equal(t, payloadType, frame.PayloadType(), "should be equal payload types")
+			assert.Equal(t, true, frame.Fin(), "should be fin control frame")
+
+			got, err := io.ReadAll(frame)
+			assert.Equal(t, nil, err, "should not be error to read payload")
+			assert.Equal(t, []byte("keepalive"), got, "should be equal payloads")
+		}
+	})
+
+	t.Run("check too large payload", func(t *testing.T) {
+		payload := make([]byte, maxControlPayloadBytes+1)
+		assert.Equal(t, ErrControlFrameTooLarge, conn.Ping(payload), "should be error to ping with large payload")
+		assert.Equal(t, ErrControlFrameTooLarge, conn.Pong(payload), "should be error to pong with large payload")
+		assert.Equal(t, 0, connBuffer.Len(), "should not write rejected frames")
+	})
+}
+
+func TestConnAutoPong(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	conn := NewFrameConnection(server, nil, nil, 0, false)
+	writerFactory := &tcpFrameWriterFactory{Writer: bufio.NewWriter(client)}
+	readerFactory := &tcpFrameReaderFactory{Reader: bufio.NewReader(client)}
+
+	type result struct {
+		msg []byte
+		err error
+	}
+	read := make(chan result, 1)
+	go func() {
+		msg, err := conn.ReadFrame()
+		read <- result{msg: msg, err: err}
+	}()
+
+	writeFrame := func(payloadType byte, payload string) {
+		w, err := writerFactory.NewFrameWriter(payloadType)
+		assert.Equal(t, nil, err, "should not be error to create frame writer")
+		_, err = w.Write([]byte(payload))
+		assert.Equal(t, nil, err, "should not be error to write frame")
+	}
+
+	t.Run("check pong answers ping", func(t *testing.T) {
+		writeFrame(PingFrame, "are you there")
+
+		frame, err := readerFactory.NewFrameReader()
+		assert.Equal(t, nil, err, "should not be error to read pong frame")
+		assert.Equal(t, byte(PongFrame), frame.PayloadType(), "should be pong frame")
+
+		got, err := io.ReadAll(frame)
+		assert.Equal(t, nil, err, "should not be error to read pong payload")
+		assert.Equal(t, []byte("are you there"), got, "should echo payload of ping")
+	})
+
+	t.Run("check ping is not returned by read", func(t *testing.T) {
+		writeFrame(TextFrame, "data")
+
+		res := <-read
+		assert.Equal(t, nil, res.err, "should not be error to read")
+		assert.Equal(t, []byte("data"), res.msg, "should skip ping frame")
+	})
+}
+
+func TestConnPingPongHandlers(t *testing.T) {
+	t.Run("check handlers observe control frames", func(t *testing.T) {
+		connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+
+		var pings, pongs [][]byte
+		conn.SetPingHandler(func(payload []byte) error {
+			pings = append(pings, payload)
+			return nil
+		})
+		conn.SetPongHandler(func(payload []byte) error {
+			pongs = append(pongs, payload)
+			return nil
+		})
+
+		assert.Equal(t, nil, conn.Ping([]byte("ping")), "should not be error to write ping")
+		assert.Equal(t, nil, conn.Pong([]byte("pong")), "should not be error to write pong")
+		_, err := conn.Write([]byte("data"))
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, []byte("data"), got, "should skip control frames")
+		assert.Equal(t, [][]byte{[]byte("ping")}, pings, "should call ping handler")
+		assert.Equal(t, [][]byte{[]byte("pong")}, pongs, "should call pong handler")
+		assert.Equal(t, 0, connBuffer.Len(), "should not answer ping with custom handler")
+	})
+
+	t.Run("check handler error aborts connection", func(t *testing.T) {
+		conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, false)
+
+		errRTT := errors.New("rtt is too high")
+		conn.SetPongHandler(func([]byte) error { return errRTT })
+
+		assert.Equal(t, nil, conn.Pong(nil), "should not be error to write pong")
+		_, err := conn.ReadFrame()
+		assert.Equal(t, errRTT, err, "should return error of the handler")
+
+		_, err = conn.Write([]byte("data"))
+		assert.Equal(t, ErrConnClosed, err, "should close connection")
+	})
+}
+
+func TestConnControlFrames(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, true)
+
+	_, _ = conn.WriteControl(PingFrame, []byte("ping 1"))
+	_, _ = conn.Write([]byte("text 1"))
+	_, _ = conn.WriteControl(PongFrame, []byte("pong 1"))
+	_, _ = conn.WriteControl(PingFrame, nil)
+	_, _ = conn.Write([]byte("text 2"))
+	_ = conn.CloseWithStatus(closeStatusGoingAway, "bye")
+
+	peer := NewFrameConnection(connBuffer, nil, nil, 0, false)
+	controlFrames := peer.ControlFrames()
+
+	t.Run("check data frames", func(t *testing.T) {
+		for _, want := range []string{"text 1", "text 2"} {
+			got, err := peer.ReadFrame()
+			assert.Equal(t, nil, err, "should not be error to read")
+			assert.Equal(t, []byte(want), got, "should read only data frames")
+		}
+
+		_, err := peer.ReadFrame()
+		assert.Equal(t, io.EOF, err, "should read close frame")
+	})
+
+	t.Run("check control frames", func(t *testing.T) {
+		want := []ControlFrame{
+			{PingFrame, []byte("ping 1")},
+			{PongFrame, []byte("pong 1")},
+			{PingFrame, []byte{}},
+			{CloseFrame, closePayload(closeStatusGoingAway, "bye")},
+		}
+
+		assert.Equal(t, len(want), len(controlFrames), "should route all control frames")
+		for _, w := range want {
+			assert.Equal(t, w, <-controlFrames, "should be equal control frames")
+		}
+	})
+
+	t.Run("check close while channel is full", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer client.Close()
+
+		conn := NewFrameConnection(client, nil, nil, 0, false)
+		go func() {
+			for i := 0; i <= controlFramesBuffer; i++ {
+				_, _ = conn.WriteControl(PingFrame, nil)
+			}
+		}()
+
+		peer := NewFrameConnection(server, nil, nil, 0, false)
+		_ = peer.ControlFrames()
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := peer.ReadFrame()
+			done <- err
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		_ = client.Close()
+		_ = peer.Close()
+
+		select {
+		case err := <-done:
+			assert.Equal(t, ErrConnClosed, err, "should be ErrConnClosed error")
+		case <-time.After(time.Second):
+			t.Fatal("should wake read blocked on control frames")
+		}
+	})
+}
+
+func TestConnReadFrameWithHeader(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, true)
+
+	_, err := conn.WriteFragmented(BinaryFrame, make([]byte, 300), 200)
+	assert.Equal(t, nil, err, "should not be error to write")
+	_, err = conn.WritePriority([]byte("high"), true)
+	assert.Equal(t, nil, err, "should not be error to write")
+
+	tests := []struct {
+		opCode byte
+		fin    bool
+		rsv3   bool
+		length int64
+	}{
+		{BinaryFrame, false, false, 200},
+		{ContinuationFrame, true, false, 100},
+		{TextFrame, true, true, 4},
+	}
+
+	// masking keys are taken from the frames on the wire
+	wire := tcpFrameReaderFactory{Reader: bufio.NewReader(bytes.NewReader(connBuffer.Bytes()))}
+	var keys [][]byte
+	for range tests {
+		frame, err := wire.NewFrameReader()
+		assert.Equal(t, nil, err, "should not be error to read frame on the wire")
+		_, _ = io.Copy(io.Discard, frame)
+		keys = append(keys, frame.(*tcpFrameReader).header.MaskingKey)
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("check header of frame %d", i), func(t *testing.T) {
+			header, payload, err := conn.ReadFrameWithHeader()
+			assert.Equal(t, nil, err, "should not be error to read")
+			assert.Equal(t, FrameHeader{
+				Fin:        tt.fin,
+				Rsv:        [3]bool{false, false, tt.rsv3},
+				OpCode:     tt.opCode,
+				Length:     tt.length,
+				MaskingKey: keys[i],
+			}, header, "should be equal headers")
+			assert.Equal(t, int(tt.length), len(payload), "should be equal payload lengths")
+		})
+	}
+}
+
+func TestConnReadEmpty(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+
+	for _, msg := range []string{"first", "", "second"} {
+		_, err := conn.Write([]byte(msg))
+		assert.Equal(t, nil, err, "should not be error to write")
+	}
+
+	readEmpty := func() {
+		for _, p := range [][]byte{nil, {}} {
+			n, err := conn.Read(p)
+			assert.Equal(t, nil, err, "should not be error to read empty slice")
+			assert.Equal(t, 0, n, "should not read bytes into empty slice")
+		}
+	}
+
+	readEmpty()
+	assert.Equal(t, 0, conn.buf.Reader.Buffered(), "should not consume a frame")
+
+	got := make([]byte, 3)
+	n, err := conn.Read(got)
+	assert.Equal(t, nil, err, "should not be error to read")
+	assert.Equal(t, "fir", string(got[:n]), "should read start of the frame")
+
+	readEmpty()
+
+	got = make([]byte, 16)
+	n, err = conn.Read(got)
+	assert.Equal(t, nil, err, "should not be error to read")
+	assert.Equal(t, "st", string(got[:n]), "should read rest of the frame")
+
+	readEmpty()
+
+	n, err = conn.Read(got)
+	assert.Equal(t, nil, err, "should not be error to read")
+	assert.Equal(t, "second", string(got[:n]), "should skip empty frame")
+}
+
+func TestConnReadDeclaredLength(t *testing.T) {
+	newConn := func() *Conn {
+		connBuffer := testConn{Buffer: bytes.NewBuffer(nil)}
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+
+		// header declares 2^62 bytes of payload
+		connBuffer.Write(preambule)
+		connBuffer.Write([]byte{0x82, 127, 0x40, 0, 0, 0, 0, 0, 0, 0})
+		connBuffer.Write([]byte("payload"))
+		return conn
+	}
+
+	t.Run("check Read", func(t *testing.T) {
+		conn := newConn()
+
+		n, err := conn.Read(make([]byte, 16))
+		assert.Equal(t, ErrFrameTooLarge, err, "should be ErrFrameTooLarge error")
+		assert.Equal(t, 0, n, "should not read payload")
+		assert.Equal(t, len("payload"), conn.buf.Reader.Buffered(), "should not consume payload")
+		assert.Equal(t, int64(1), conn.Stats().Oversized, "should count oversized frame")
+
+		_, err = conn.Read(make([]byte, 16))
+		assert.Equal(t, ErrConnClosed, err, "should close connection")
+	})
+
+	t.Run("check ReadFrame", func(t *testing.T) {
+		conn := newConn()
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := conn.ReadFrame()
+			done <- err
+		}()
+
+		select {
+		case err := <-done:
+			assert.Equal(t, ErrFrameTooLarge, err, "should be ErrFrameTooLarge error")
+		case <-time.After(time.Second):
+			t.Fatal("should not drain declared payload")
+		}
+		assert.Equal(t, len("payload"), conn.buf.Reader.Buffered(), "should not consume payload")
+		assert.Equal(t, int64(1), conn.Stats().Oversized, "should count oversized frame")
+
+		_, err := conn.ReadFrame()
+		assert.Equal(t, ErrConnClosed, err, "should close connection")
+	})
+
+	t.Run("check peer not reading", func(t *testing.T) {
+		client, server := net.Pipe()
+		defer client.Close()
+
+		conn := NewFrameConnection(server, nil, nil, 0, false)
+		go func() {
+			_, _ = client.Write(preambule)
+			_, _ = client.Write([]byte{0x82, 127, 0x40, 0, 0, 0, 0, 0, 0, 0})
+		}()
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := conn.ReadFrame()
+			done <- err
+		}()
+
+		select {
+		case err := <-done:
+			assert.Equal(t, ErrFrameTooLarge, err, "should be ErrFrameTooLarge error")
+		case <-time.After(time.Second):
+			t.Fatal("should not write close frame to the peer")
+		}
+
+		assert.Equal(t, ErrConnClosed, conn.Close(), "should be closed without close frame")
+	})
+}
+
+func TestConnMaxFrameHeaderBytes(t *testing.T) {
+	connBuffer := testConn{
+		Buffer: bytes.NewBuffer(nil),
+	}
+	conn := NewFrameConnection(connBuffer, nil, nil, 0, true)
+	conn.SetMaxFrameHeaderBytes(len(preambule) + 8)
+
+	t.Run("check short header", func(t *testing.T) {
+		_, err := conn.Write(make([]byte, 125))
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read frame with short header")
+		assert.Equal(t, 125, len(got), "should be equal payload lengths")
+	})
+
+	t.Run("check maximal header", func(t *testing.T) {
+		_, err := conn.Write(make([]byte, 70000))
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		_, err = conn.ReadFrame()
+		assert.Equal(t, ErrHeaderTooLarge, err, "should be ErrHeaderTooLarge error")
+	})
+}
@@ -0,0 +1,85 @@
+// Package tcpwstest provides utilities for testing framing connections
+// under adverse network conditions
+package tcpwstest
+
+import (
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ChaosConn wraps io.ReadWriteCloser and corrupts data read from it:
+// adds latency to each read, drops bytes and reorders bytes within a window.
+// It can be used in place of net.Conn in gotcpws.NewFrameConnection
+type ChaosConn struct {
+	conn io.ReadWriteCloser
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+
+	// Latency is added before each read
+	Latency time.Duration
+
+	// DropProbability is probability of each read byte to be dropped
+	DropProbability float64
+
+	// ReorderWindow, if greater than 1, shuffles read bytes
+	// within consecutive windows of that size
+	ReorderWindow int
+}
+
+// NewChaosConn creates new chaos connection over conn,
+// seed makes corruption reproducible
+func NewChaosConn(conn io.ReadWriteCloser, seed int64) *ChaosConn {
+	return &ChaosConn{
+		conn: conn,
+		rnd:  rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Read reads data from wrapped connection and corrupts it
+func (c *ChaosConn) Read(p []byte) (int, error) {
+	if c.Latency > 0 {
+		time.Sleep(c.Latency)
+	}
+
+	n, err := c.conn.Read(p)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.DropProbability > 0 {
+		kept := 0
+		for i := 0; i < n; i++ {
+			if c.rnd.Float64() < c.DropProbability {
+				continue
+			}
+
+			p[kept] = p[i]
+			kept++
+		}
+		n = kept
+	}
+
+	if c.ReorderWindow > 1 {
+		for start := 0; start < n; start += c.ReorderWindow {
+			window := p[start:min(start+c.ReorderWindow, n)]
+			c.rnd.Shuffle(len(window), func(i, j int) {
+				window[i], window[j] = window[j], window[i]
+			})
+		}
+	}
+
+	return n, err
+}
+
+// Write writes data to wrapped connection as is
+func (c *ChaosConn) Write(p []byte) (int, error) {
+	return c.conn.Write(p)
+}
+
+// Close closes wrapped connection
+func (c *ChaosConn) Close() error {
+	return c.conn.Close()
+}
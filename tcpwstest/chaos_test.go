@@ -0,0 +1,73 @@
+package tcpwstest
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	gotcpws "github.com/sazonovItas/go-tcpws"
+)
+
+type testConn struct {
+	*bytes.Buffer
+}
+
+func (c testConn) Close() error { return nil }
+
+func newTestStream(t *testing.T, frames, length int) *bytes.Buffer {
+	t.Helper()
+
+	buf := bytes.NewBuffer(nil)
+	writer := gotcpws.NewFrameConnection(testConn{Buffer: buf}, nil, nil, 0, false)
+	for i := 0; i < frames; i++ {
+		_, err := writer.Write(bytes.Repeat([]byte{byte(i)}, length))
+		assert.Equal(t, nil, err, "should not be error to write")
+	}
+
+	return buf
+}
+
+func TestChaosConnWithoutChaos(t *testing.T) {
+	chaos := NewChaosConn(testConn{Buffer: newTestStream(t, 10, 10)}, 1)
+	conn := gotcpws.NewFrameConnection(chaos, nil, nil, 0, false)
+
+	for i := 0; i < 10; i++ {
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read frame %d", i)
+		assert.Equal(t, bytes.Repeat([]byte{byte(i)}, 10), got, "should be equal messages")
+	}
+}
+
+func TestChaosConnDrops(t *testing.T) {
+	chaos := NewChaosConn(testConn{Buffer: newTestStream(t, 100, 1000)}, 42)
+	chaos.DropProbability = 0.001
+	conn := gotcpws.NewFrameConnection(chaos, nil, nil, 0, false)
+
+	var err error
+	for err == nil {
+		_, err = conn.ReadFrame()
+	}
+
+	assert.Equal(t, gotcpws.ErrBadPreambule, err, "should detect dropped bytes by preambule")
+}
+
+func TestChaosConnReproducible(t *testing.T) {
+	read := func() []byte {
+		chaos := NewChaosConn(testConn{Buffer: newTestStream(t, 10, 10)}, 7)
+		chaos.DropProbability = 0.1
+		chaos.ReorderWindow = 4
+
+		var got []byte
+		buf := make([]byte, 16)
+		for {
+			n, err := chaos.Read(buf)
+			got = append(got, buf[:n]...)
+			if err != nil {
+				return got
+			}
+		}
+	}
+
+	assert.Equal(t, read(), read(), "should corrupt data the same way with the same seed")
+}
@@ -0,0 +1,82 @@
+package gotcpws
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter is token bucket to limit bytes per second,
+// the bucket holds at most one second of tokens
+type rateLimiter struct {
+	mu    sync.Mutex
+	clock clock
+
+	// rate is bytes per second, if rate <= 0 there is no limit
+	rate   int64
+	tokens int64
+	last   time.Time
+}
+
+func newRateLimiter(clk clock) *rateLimiter {
+	return &rateLimiter{clock: clk}
+}
+
+// setRate sets limit of bytes per second and fills the bucket
+func (l *rateLimiter) setRate(bps int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate = bps
+	l.tokens = bps
+	l.last = l.clock.Now()
+}
+
+// wait blocks until n bytes are allowed to cross the connection
+func (l *rateLimiter) wait(n int) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	need := int64(n)
+	for need > 0 && l.rate > 0 {
+		l.refill()
+
+		take := min(need, l.tokens)
+		l.tokens -= take
+		need -= take
+		if need == 0 {
+			break
+		}
+
+		// sleep until the bucket has enough tokens for the next chunk
+		chunk := min(need, l.rate)
+		l.clock.Sleep(time.Duration(float64(chunk-l.tokens) / float64(l.rate) * float64(time.Second)))
+	}
+}
+
+func (l *rateLimiter) refill() {
+	now := l.clock.Now()
+	elapsed := now.Sub(l.last)
+	l.last = now
+
+	// the bucket is full after one second, so longer idle periods
+	// are capped, tokens are counted in float to not overflow on high rates
+	elapsed = min(elapsed, time.Second)
+	l.tokens = min(l.rate, l.tokens+int64(float64(l.rate)*elapsed.Seconds()))
+}
+
+// throttledReader is reader limited by rateLimiter
+type throttledReader struct {
+	reader  io.Reader
+	limiter *rateLimiter
+}
+
+func (r throttledReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.limiter.wait(n)
+	return n, err
+}
@@ -0,0 +1,129 @@
+package gotcpws
+
+import (
+	"bytes"
+	cryptorand "crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnThrottling(t *testing.T) {
+	const length = 10 << 10
+
+	t.Run("check write limit", func(t *testing.T) {
+		connBuffer := testConn{
+			Buffer: bytes.NewBuffer(nil),
+		}
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+
+		clk := newFakeClock()
+		conn.writeLimiter.clock = clk
+		conn.SetWriteLimitBps(1 << 10)
+
+		msg := make([]byte, length)
+		_, _ = cryptorand.Read(msg)
+
+		start := clk.Now()
+		_, err := conn.Write(msg)
+		assert.Equal(t, nil, err, "should not be error to write")
+		assert.InDelta(
+			t,
+			10*time.Second,
+			clk.Now().Sub(start),
+			float64(time.Second),
+			"should take about 10 seconds to write 10KB at 1KB/s",
+		)
+
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, msg, got, "should be equal messages")
+	})
+
+	t.Run("check read limit", func(t *testing.T) {
+		connBuffer := testConn{
+			Buffer: bytes.NewBuffer(nil),
+		}
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+
+		msg := make([]byte, length)
+		_, _ = cryptorand.Read(msg)
+		_, err := conn.Write(msg)
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		clk := newFakeClock()
+		conn.readLimiter.clock = clk
+		conn.SetReadLimitBps(1 << 10)
+
+		start := clk.Now()
+		got, err := conn.ReadFrame()
+		assert.Equal(t, nil, err, "should not be error to read")
+		assert.Equal(t, msg, got, "should be equal messages")
+		assert.InDelta(
+			t,
+			10*time.Second,
+			clk.Now().Sub(start),
+			float64(time.Second),
+			"should take about 10 seconds to read 10KB at 1KB/s",
+		)
+	})
+
+	t.Run("check write after idle period", func(t *testing.T) {
+		connBuffer := testConn{
+			Buffer: bytes.NewBuffer(nil),
+		}
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+
+		clk := newFakeClock()
+		conn.writeLimiter.clock = clk
+		conn.SetWriteLimitBps(100 << 20)
+
+		// elapsed nanoseconds multiplied by the rate overflow int64
+		clk.Advance(90 * time.Second)
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := conn.Write(make([]byte, length))
+			done <- err
+		}()
+
+		select {
+		case err := <-done:
+			assert.Equal(t, nil, err, "should not be error to write")
+		case <-time.After(time.Second):
+			t.Fatal("should not block write after idle period")
+		}
+	})
+
+	t.Run("check refill with very high rate", func(t *testing.T) {
+		clk := newFakeClock()
+		l := newRateLimiter(clk)
+		l.setRate(1 << 40)
+		l.wait(1 << 40)
+
+		// elapsed nanoseconds within one second multiplied by the rate
+		// overflow int64
+		clk.Advance(500 * time.Millisecond)
+
+		l.refill()
+		assert.Equal(t, int64(1<<39), l.tokens, "should refill half of the bucket")
+	})
+
+	t.Run("check no limit", func(t *testing.T) {
+		connBuffer := testConn{
+			Buffer: bytes.NewBuffer(nil),
+		}
+		conn := NewFrameConnection(connBuffer, nil, nil, 0, false)
+
+		clk := newFakeClock()
+		conn.writeLimiter.clock = clk
+		conn.SetWriteLimitBps(1 << 10)
+		conn.SetWriteLimitBps(0)
+
+		start := clk.Now()
+		_, err := conn.Write(make([]byte, length))
+		assert.Equal(t, nil, err, "should not be error to write")
+		assert.Equal(t, time.Duration(0), clk.Now().Sub(start), "should not wait without limit")
+	})
+}
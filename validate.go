@@ -0,0 +1,48 @@
+package gotcpws
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+var (
+	// ErrPartialFrame reports by Validate when a frame is partially read
+	ErrPartialFrame = errors.New("error partially read frame")
+
+	// ErrUnflushedData reports by Validate when written frames are buffered
+	// but not flushed
+	ErrUnflushedData = errors.New("error unflushed data")
+)
+
+// Validate checks consistency of the connection for diagnostics of long
+// running servers and returns joined errors describing found problems:
+// ErrConnClosed, ErrPartialFrame, ErrUnflushedData and error of not
+// supported deadlines. Reads and writes in progress are not checked,
+// so Validate does not block
+func (conn *Conn) Validate() error {
+	if conn.closed.Load() {
+		return ErrConnClosed
+	}
+
+	var errs []error
+	if conn.rio.TryLock() {
+		if r, ok := conn.frameReader.(*tcpFrameReader); ok && r.consumed < int64(r.length) {
+			errs = append(errs, fmt.Errorf("%w, %d bytes of payload are not read", ErrPartialFrame, int64(r.length)-r.consumed))
+		}
+		conn.rio.Unlock()
+	}
+
+	if conn.wio.TryLock() {
+		if n := conn.buf.Writer.Buffered(); n > 0 {
+			errs = append(errs, fmt.Errorf("%w, %d bytes are buffered", ErrUnflushedData, n))
+		}
+		conn.wio.Unlock()
+	}
+
+	if _, ok := conn.rwc.(net.Conn); !ok && conn.deadlineHook.Load() == nil {
+		errs = append(errs, errSetDeadline)
+	}
+
+	return errors.Join(errs...)
+}
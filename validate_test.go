@@ -0,0 +1,61 @@
+package gotcpws
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnValidate(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	conn := NewFrameConnection(server, nil, nil, 0, false)
+	peer := NewFrameConnection(client, nil, nil, 0, false)
+
+	t.Run("check healthy connection", func(t *testing.T) {
+		assert.Equal(t, nil, conn.Validate(), "should not be error for healthy connection")
+	})
+
+	t.Run("check partially read frame", func(t *testing.T) {
+		go func() { _, _ = peer.Write([]byte("partially read")) }()
+
+		buf := make([]byte, 4)
+		_, err := conn.Read(buf)
+		assert.Equal(t, nil, err, "should not be error to read")
+
+		err = conn.Validate()
+		assert.ErrorIs(t, err, ErrPartialFrame, "should report partially read frame")
+		assert.EqualError(t, err, "error partially read frame, 10 bytes of payload are not read", "should describe the frame")
+
+		_, err = conn.Read(make([]byte, 10))
+		assert.Equal(t, nil, err, "should not be error to read the rest")
+		assert.Equal(t, nil, conn.Validate(), "should not be error after frame is read")
+	})
+
+	t.Run("check unflushed data", func(t *testing.T) {
+		conn.DisableAutoFlush = true
+		_, err := conn.Write([]byte("buffered"))
+		assert.Equal(t, nil, err, "should not be error to write")
+
+		assert.ErrorIs(t, conn.Validate(), ErrUnflushedData, "should report unflushed data")
+
+		go func() { _, _ = peer.ReadFrame() }()
+		assert.Equal(t, nil, conn.Flush(), "should not be error to flush")
+		assert.Equal(t, nil, conn.Validate(), "should not be error after flush")
+	})
+
+	t.Run("check deadlines are not supported", func(t *testing.T) {
+		conn := NewFrameConnection(testConn{Buffer: bytes.NewBuffer(nil)}, nil, nil, 0, false)
+		assert.ErrorIs(t, conn.Validate(), errSetDeadline, "should report not supported deadlines")
+	})
+
+	t.Run("check closed connection", func(t *testing.T) {
+		go func() { _, _ = peer.ReadFrame() }()
+		assert.Equal(t, nil, conn.Close(), "should not be error to close")
+		assert.Equal(t, ErrConnClosed, conn.Validate(), "should report closed connection")
+	})
+}